@@ -0,0 +1,237 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// Access is a set of attributes of a file or folder.
+type Access uint8
+
+const (
+	AccessRead Access = 1 << iota
+	AccessWrite
+	AccessLocked
+	AccessHidden
+)
+
+// AccessRule grants and denies attributes to the entries matching Pattern or
+// Regexp and to everything below them. Rules apply in order on top of the
+// attributes read from the volume, so later rules win.
+type AccessRule struct {
+	// Volume limits the rule to the volume of this name, empty matches all.
+	Volume string
+	// Pattern is a path.Match glob. With a leading "/" it is matched against
+	// the path from the volume root, e.g. "/releases", otherwise against
+	// every single name, e.g. ".git" or "*.key".
+	Pattern string
+	// Regexp is matched against the path from the volume root, e.g.
+	// "/releases/v1.0". The rule applies if Pattern or Regexp matches.
+	Regexp *regexp.Regexp
+	// Users and Groups limit the rule to these callers, both empty matches
+	// everybody including anonymous requests.
+	Users  []string
+	Groups []string
+
+	Grant Access
+	Deny  Access
+}
+
+func (r *AccessRule) appliesTo(identity *Identity, volName string) bool {
+	if r.Volume != "" && r.Volume != volName {
+		return false
+	}
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if identity == nil {
+		return false
+	}
+	for i := range r.Users {
+		if r.Users[i] == identity.User {
+			return true
+		}
+	}
+	for i := range r.Groups {
+		for j := range identity.Groups {
+			if r.Groups[i] == identity.Groups[j] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match reports whether the rule matches rootPath, a path like "/a/b" from
+// the volume root, or one of its parents.
+func (r *AccessRule) match(rootPath string) bool {
+	for p := rootPath; ; p = path.Dir(p) {
+		if r.Regexp != nil && r.Regexp.MatchString(p) {
+			return true
+		}
+		if r.Pattern != "" {
+			name := p
+			if !strings.HasPrefix(r.Pattern, model.Separator) {
+				name = path.Base(p)
+			}
+			if ok, _ := path.Match(r.Pattern, name); ok {
+				return true
+			}
+		}
+		if p == model.Separator {
+			return false
+		}
+	}
+}
+
+// AccessControl evaluates access rules for a caller.
+type AccessControl struct {
+	rules []AccessRule
+}
+
+func NewAccessControl(rules ...AccessRule) (*AccessControl, error) {
+	for i := range rules {
+		if rules[i].Pattern == "" && rules[i].Regexp == nil {
+			return nil, fmt.Errorf("access rule %d: no pattern", i)
+		}
+		if _, err := path.Match(rules[i].Pattern, ""); err != nil {
+			return nil, fmt.Errorf("access rule %d: %w", i, err)
+		}
+	}
+	return &AccessControl{rules: rules}, nil
+}
+
+// Access returns the attributes of the entry at relativePath of the volume
+// volName for identity, starting from the attributes base read from the
+// volume. identity is nil for anonymous requests.
+func (a *AccessControl) Access(identity *Identity, volName, relativePath string, base Access) Access {
+	rootPath := path.Clean(model.Separator + relativePath)
+	for i := range a.rules {
+		rule := &a.rules[i]
+		if rule.appliesTo(identity, volName) && rule.match(rootPath) {
+			base = (base | rule.Grant) &^ rule.Deny
+		}
+	}
+	return base
+}
+
+var (
+	errHidden   = fmt.Errorf("%w: hidden by access rules", fs.ErrNotExist)
+	errStopWalk = errors.New("stop walk")
+)
+
+// access applies the access rules of the connector for the caller of ctx.
+func (c *Connector) access(ctx context.Context, volName, relativePath string, base Access) Access {
+	if c.accessControl == nil {
+		return base
+	}
+	identity, _ := IdentityFromContext(ctx)
+	return c.accessControl.Access(identity, volName, relativePath, base)
+}
+
+// writableDir stats the folder a command adds entries to and returns an error
+// response unless the caller may write to it.
+func (c *Connector) writableDir(ctx context.Context, id string, vol volumes.FsVolume, dirPath string) (model.FileInfo, *ErrResponse) {
+	info, err := c.StatFsVolFileByPath(ctx, id, vol, dirPath)
+	if err != nil || info.MimeType != "directory" {
+		errRes := NewErr(errs.ERRTrgFolderNotFound, errors.New(path.Base(dirPath)))
+		return info, &errRes
+	}
+	if info.WriteAble == 0 {
+		errRes := NewErr(errs.ERRPerm, errors.New(info.Name))
+		return info, &errRes
+	}
+	return info, nil
+}
+
+// readableDir stats the folder a command lists and returns an error response
+// unless the caller may read it. Hidden folders are reported as not found.
+func (c *Connector) readableDir(ctx context.Context, id string, vol volumes.FsVolume, dirPath string) (model.FileInfo, *ErrResponse) {
+	info, err := c.StatFsVolFileByPath(ctx, id, vol, dirPath)
+	if err != nil || info.MimeType != "directory" {
+		errRes := NewErr(errs.ERRFolderNotFound, errors.New(path.Base(dirPath)))
+		return info, &errRes
+	}
+	if info.ReadAble == 0 {
+		errRes := NewErr(errs.ERRPerm, errors.New(info.Name))
+		return info, &errRes
+	}
+	return info, nil
+}
+
+// movable returns an error response unless the caller may remove, rename or
+// move away the entry info at vPath. Both the entry and its folder must be
+// writable, so access rules denying write protect an entry like a lock.
+func (c *Connector) movable(ctx context.Context, id string, vol volumes.FsVolume, vPath string, info model.FileInfo) *ErrResponse {
+	if info.Locked == 1 {
+		errRes := NewErr(errs.ERRLocked, errors.New(info.Name))
+		return &errRes
+	}
+	if info.WriteAble == 0 {
+		errRes := NewErr(errs.ERRPerm, errors.New(info.Name))
+		return &errRes
+	}
+	if _, errRes := c.writableDir(ctx, id, vol, path.Dir(vPath)); errRes != nil {
+		return errRes
+	}
+	return nil
+}
+
+// hiddenFilter returns a function reporting whether a relative path of vol is
+// hidden from the caller, or nil without access rules.
+func (c *Connector) hiddenFilter(ctx context.Context, vol volumes.FsVolume) func(string) bool {
+	if c.accessControl == nil {
+		return nil
+	}
+	return func(relativePath string) bool {
+		return c.access(ctx, vol.Name(), relativePath, 0)&AccessHidden != 0
+	}
+}
+
+// lockedChild returns the name of a locked or write protected entry below the
+// folder vPath, a folder holding one must not be removed or moved as a whole.
+func (c *Connector) lockedChild(ctx context.Context, id string, vol volumes.FsVolume, vPath string) (string, bool) {
+	if c.accessControl == nil {
+		return "", false
+	}
+	var locked string
+	_ = fs.WalkDir(vol, relativeVolPath(vol, vPath), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := c.StatFsVolFileByPath(ctx, id, vol, path.Join(fmt.Sprintf("/%s", vol.Name()), p))
+		if err == nil && (info.Locked == 1 || info.WriteAble == 0) {
+			locked = info.Name
+			return errStopWalk
+		}
+		return nil
+	})
+	return locked, locked != ""
+}
+
+// replaceable returns an error response if an entry exists at vPath that the
+// caller must not overwrite, because it is locked, write protected or hidden.
+func (c *Connector) replaceable(ctx context.Context, id string, vol volumes.FsVolume, vPath string) *ErrResponse {
+	info, err := c.StatFsVolFileByPath(ctx, id, vol, vPath)
+	switch {
+	case errors.Is(err, errHidden):
+		errRes := NewErr(errs.ERRPerm, errors.New(path.Base(vPath)))
+		return &errRes
+	case err == nil && info.Locked == 1:
+		errRes := NewErr(errs.ERRLocked, errors.New(info.Name))
+		return &errRes
+	case err == nil && info.WriteAble == 0:
+		errRes := NewErr(errs.ERRPerm, errors.New(info.Name))
+		return &errRes
+	}
+	return nil
+}
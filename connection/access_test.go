@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteDenyRuleProtectsEntries(t *testing.T) {
+	ac, err := NewAccessControl(AccessRule{Pattern: "/releases", Deny: AccessWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, vol := newTestConnector(t, []string{"releases/v1.txt", "work/"}, WithAccessControl(ac))
+
+	for _, p := range []string{"/releases", "/releases/v1.txt"} {
+		target := testTarget(c, vol, p)
+		if res := getTest(t, c, url.Values{"cmd": {"rm"}, "targets[]": {target}}); res.errType() != "errPerm" {
+			t.Errorf("rm %s: %+v", p, res)
+		}
+		if res := getTest(t, c, url.Values{"cmd": {"rename"}, "target": {target}, "name": {"x"}}); res.errType() != "errPerm" {
+			t.Errorf("rename %s: %+v", p, res)
+		}
+		res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {target}, "cut": {"1"},
+			"dst": {testTarget(c, vol, "/work")}})
+		if len(res.Warning) != 1 || len(res.Added) != 0 {
+			t.Errorf("cut %s: %+v", p, res)
+			continue
+		}
+		var warning testResponse
+		if err := json.Unmarshal(res.Warning[0], &warning); err != nil || warning.errType() != "errPerm" {
+			t.Errorf("cut %s: %s", p, res.Warning[0])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(vol.Root(), "releases", "v1.txt")); err != nil {
+		t.Error(err)
+	}
+	res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/releases/v1.txt")},
+		"dst": {testTarget(c, vol, "/work")}})
+	if res.Error != nil || res.Warning != nil || len(res.Added) != 1 {
+		t.Errorf("copy out of a write protected folder: %+v", res)
+	}
+}
+
+func TestPasteOverwriteProtectedEntries(t *testing.T) {
+	ac, err := NewAccessControl(
+		AccessRule{Pattern: "/work/keep", Deny: AccessWrite},
+		AccessRule{Pattern: "/work/d/locked.txt", Grant: AccessLocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := newTestVolume(t, testVolName, []string{"src/keep", "src/d/a.txt", "work/keep", "work/d/locked.txt"})
+	c := NewConnector(WithVolume(vol, VolumeOption{CopyOverwrite: true}), WithAccessControl(ac))
+	dst := testTarget(c, vol, "/work")
+
+	for _, tc := range []struct {
+		name    string
+		q       url.Values
+		errType string
+	}{
+		{"overwrite write protected file", url.Values{}, "errPerm"},
+		{"rename write protected file", url.Values{"renames[]": {"keep"}}, "errPerm"},
+	} {
+		q := url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/src/keep")}, "dst": {dst}}
+		for k, v := range tc.q {
+			q[k] = v
+		}
+		checkPasteWarning(t, tc.name, getTest(t, c, q), tc.errType)
+	}
+	for _, renames := range [][]string{nil, {"d"}} {
+		res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/src/d")},
+			"dst": {dst}, "renames[]": renames})
+		checkPasteWarning(t, "folder holding a locked file", res, "errLocked")
+	}
+	for _, name := range []string{"work/keep", "work/d/locked.txt"} {
+		if _, err := os.Stat(filepath.Join(vol.Root(), filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(vol.Root(), "work", "d", "a.txt")); err == nil {
+		t.Error("folder pasted over a folder holding a locked file")
+	}
+}
+
+func checkPasteWarning(t *testing.T, name string, res testResponse, errType string) {
+	t.Helper()
+	if len(res.Warning) != 1 || len(res.Added) != 0 || len(res.Removed) != 0 {
+		t.Errorf("%s: %+v", name, res)
+		return
+	}
+	var warning testResponse
+	if err := json.Unmarshal(res.Warning[0], &warning); err != nil || warning.errType() != errType {
+		t.Errorf("%s: %s, want %s", name, res.Warning[0], errType)
+	}
+}
+
+func TestTreeAndParentsNeedReadableFolder(t *testing.T) {
+	ac, err := NewAccessControl(
+		AccessRule{Pattern: "/secret", Deny: AccessRead},
+		AccessRule{Pattern: "/hidden", Grant: AccessHidden})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, vol := newTestConnector(t, []string{"secret/a/", "hidden/a/", "open/a/"}, WithAccessControl(ac))
+	for _, tc := range []struct {
+		path    string
+		errType string
+	}{
+		{"/secret", "errPerm"},
+		{"/hidden", "errFolderNotFound"},
+		{"/missing", "errFolderNotFound"},
+		{"/open", ""},
+	} {
+		for _, cmd := range []string{"tree", "parents"} {
+			res := getTest(t, c, url.Values{"cmd": {cmd}, "target": {testTarget(c, vol, tc.path)}})
+			if res.errType() != tc.errType {
+				t.Errorf("%s %s: %+v, want %q", cmd, tc.path, res, tc.errType)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/LeeEirc/elfinder/errs"
+)
+
+var (
+	ErrAuthRequired   = errors.New("authentication required")
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Identity is the caller of a request as established by an Authenticator.
+type Identity struct {
+	User   string
+	Groups []string
+	// ExpiresAt is the end of the session, the zero value never expires.
+	ExpiresAt time.Time
+	// Attrs holds anything else the authenticator knows about the caller.
+	Attrs map[string]string
+}
+
+func (i *Identity) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// Authenticator establishes the identity of the caller of every request. It
+// returns ErrAuthRequired when the request carries no identity and
+// ErrSessionExpired when the identity is stale.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*Identity, error)
+}
+
+type AuthenticatorFunc func(req *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(req *http.Request) (*Identity, error) {
+	return f(req)
+}
+
+type identityCtxKey struct{}
+
+// WithIdentity returns a copy of ctx carrying identity. The connector stores
+// the authenticated identity this way, upstream middleware can use it
+// together with ContextAuthenticator.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityCtxKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the request, command handlers
+// call it with req.Context().
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityCtxKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// ContextAuthenticator accepts the identity set by upstream middleware with
+// WithIdentity.
+func ContextAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*Identity, error) {
+		if identity, ok := IdentityFromContext(req.Context()); ok {
+			return identity, nil
+		}
+		return nil, ErrAuthRequired
+	})
+}
+
+// HeaderAuthenticator trusts the user name in the header set by a reverse
+// proxy, e.g. "X-Remote-User". Only use it when clients cannot reach the
+// connector without passing the proxy.
+func HeaderAuthenticator(header string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*Identity, error) {
+		user := req.Header.Get(header)
+		if user == "" {
+			return nil, ErrAuthRequired
+		}
+		return &Identity{User: user}, nil
+	})
+}
+
+// CookieAuthenticator looks the value of the named cookie up, lookup returns
+// ErrSessionExpired for sessions it no longer knows.
+func CookieAuthenticator(name string, lookup func(value string) (*Identity, error)) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*Identity, error) {
+		cookie, err := req.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return nil, ErrAuthRequired
+		}
+		return lookup(cookie.Value)
+	})
+}
+
+// authenticate runs the authenticator and stores the identity in the request
+// context. Without an authenticator every request passes anonymously.
+func (c *Connector) authenticate(req *http.Request) (*http.Request, error) {
+	if c.authenticator == nil {
+		return req, nil
+	}
+	identity, err := c.authenticator.Authenticate(req)
+	if err == nil && identity == nil {
+		err = ErrAuthRequired
+	}
+	if err == nil && identity.Expired(time.Now()) {
+		err = ErrSessionExpired
+	}
+	if err != nil {
+		return req, err
+	}
+	return req.WithContext(WithIdentity(req.Context(), identity)), nil
+}
+
+func authErrType(err error) errs.ErrType {
+	if errors.Is(err, ErrSessionExpired) {
+		return errs.ERRSessionExpires
+	}
+	return errs.ERRReauthRequire
+}
@@ -0,0 +1,80 @@
+package connection
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthenticators(t *testing.T) {
+	sessions := map[string]*Identity{
+		"s1": {User: "alice"},
+		"s2": {User: "bob", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	cookie := CookieAuthenticator("sid", func(value string) (*Identity, error) {
+		if identity, ok := sessions[value]; ok {
+			return identity, nil
+		}
+		return nil, ErrSessionExpired
+	})
+	open := url.Values{"cmd": {"open"}, "init": {"1"}}.Encode()
+	for _, tc := range []struct {
+		name    string
+		auth    Authenticator
+		setup   func(req *http.Request) *http.Request
+		errType string
+	}{
+		{"header", HeaderAuthenticator("X-Remote-User"), func(req *http.Request) *http.Request {
+			req.Header.Set("X-Remote-User", "alice")
+			return req
+		}, ""},
+		{"no header", HeaderAuthenticator("X-Remote-User"), nil, "errReauthRequire"},
+		{"cookie", cookie, func(req *http.Request) *http.Request {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: "s1"})
+			return req
+		}, ""},
+		{"no cookie", cookie, nil, "errReauthRequire"},
+		{"unknown session", cookie, func(req *http.Request) *http.Request {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: "s3"})
+			return req
+		}, "errSessionExpires"},
+		{"expired identity", cookie, func(req *http.Request) *http.Request {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: "s2"})
+			return req
+		}, "errSessionExpires"},
+		{"context", ContextAuthenticator(), func(req *http.Request) *http.Request {
+			return req.WithContext(WithIdentity(req.Context(), &Identity{User: "alice"}))
+		}, ""},
+		{"no context", ContextAuthenticator(), nil, "errReauthRequire"},
+		{"nil identity", AuthenticatorFunc(func(*http.Request) (*Identity, error) { return nil, nil }), nil,
+			"errReauthRequire"},
+	} {
+		c, _ := newTestConnector(t, []string{"a.txt"}, WithAuthenticator(tc.auth))
+		req := httptest.NewRequest(http.MethodGet, "/?"+open, nil)
+		if tc.setup != nil {
+			req = tc.setup(req)
+		}
+		if res := serveTest(t, c, req); res.errType() != tc.errType {
+			t.Errorf("%s: %+v, want %q", tc.name, res, tc.errType)
+		}
+	}
+}
+
+func TestIdentityReachesResolver(t *testing.T) {
+	var got *Identity
+	auth := AuthenticatorFunc(func(req *http.Request) (*Identity, error) {
+		return &Identity{User: "alice", Groups: []string{"team"}}, nil
+	})
+	resolver := VolumeResolverFunc(func(req *http.Request) ([]MountedVolume, error) {
+		got, _ = IdentityFromContext(req.Context())
+		return nil, errors.New("no volumes")
+	})
+	c := NewConnector(WithAuthenticator(auth), WithVolumeResolver(resolver))
+	getTest(t, c, url.Values{"cmd": {"open"}, "init": {"1"}})
+	if got == nil || got.User != "alice" || len(got.Groups) != 1 {
+		t.Errorf("identity %+v", got)
+	}
+}
@@ -0,0 +1,366 @@
+package connection
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChunkTTL        = 24 * time.Hour
+	defaultChunkGCInterval = 10 * time.Minute
+)
+
+var (
+	chunkNameRegexp  = regexp.MustCompile(`^(.+)\.(\d+)_(\d+)\.part$`)
+	chunkCidRegexp   = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+	mergedNameRegexp = regexp.MustCompile(`^[0-9a-zA-Z]+_[0-9a-f]{32}$`)
+
+	ErrInvalidChunk    = errors.New("invalid chunk")
+	ErrChunkIncomplete = errors.New("chunk upload incomplete")
+	ErrChunkChecksum   = errors.New("chunk upload checksum mismatch")
+)
+
+// parseChunkName splits the client chunk name "name.N_M.part" into the file
+// name, the zero based chunk index N and the chunk count M+1.
+func parseChunkName(chunk string) (name string, index, total int, err error) {
+	ret := chunkNameRegexp.FindStringSubmatch(chunk)
+	if len(ret) != 4 {
+		return "", 0, 0, fmt.Errorf("%w: %s", ErrInvalidChunk, chunk)
+	}
+	index, err = strconv.Atoi(ret[2])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("%w: %s", ErrInvalidChunk, chunk)
+	}
+	last, err := strconv.Atoi(ret[3])
+	if err != nil || index > last {
+		return "", 0, 0, fmt.Errorf("%w: %s", ErrInvalidChunk, chunk)
+	}
+	return ret[1], index, last + 1, nil
+}
+
+// parseChunkRange parses the "offset,length,total" range parameter.
+func parseChunkRange(rangeData string) (offset, length, total int64, err error) {
+	ret := strings.Split(rangeData, ",")
+	if len(ret) != 3 {
+		return 0, 0, 0, fmt.Errorf("%w: range %s", ErrInvalidChunk, rangeData)
+	}
+	var values [3]int64
+	for i := range ret {
+		if values[i], err = strconv.ParseInt(ret[i], 10, 64); err != nil || values[i] < 0 {
+			return 0, 0, 0, fmt.Errorf("%w: range %s", ErrInvalidChunk, rangeData)
+		}
+	}
+	if values[0]+values[1] > values[2] {
+		return 0, 0, 0, fmt.Errorf("%w: range %s", ErrInvalidChunk, rangeData)
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// ChunkStatus reports which byte ranges of a chunked upload already arrived,
+// so that an interrupted client only needs to resend the missing ones.
+type ChunkStatus struct {
+	Cid      string     `json:"cid"`
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Received [][2]int64 `json:"received"`
+	Missing  [][2]int64 `json:"missing"`
+	Checksum string     `json:"checksum,omitempty"`
+	Complete bool       `json:"complete"`
+}
+
+// chunkSession is the manifest persisted next to the staged data of an upload.
+type chunkSession struct {
+	Owner    string     `json:"owner"`
+	Cid      string     `json:"cid"`
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Ranges   [][2]int64 `json:"ranges"` // sorted, non-overlapping [offset, end)
+	Checksum string     `json:"checksum,omitempty"`
+}
+
+func (s *chunkSession) addRange(offset, end int64) {
+	ranges := append(s.Ranges, [2]int64{offset, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.Ranges = merged
+}
+
+func (s *chunkSession) missing() [][2]int64 {
+	missing := make([][2]int64, 0)
+	var pos int64
+	for _, r := range s.Ranges {
+		if r[0] > pos {
+			missing = append(missing, [2]int64{pos, r[0]})
+		}
+		pos = r[1]
+	}
+	if pos < s.Size {
+		missing = append(missing, [2]int64{pos, s.Size})
+	}
+	return missing
+}
+
+func (s *chunkSession) complete() bool {
+	if s.Size == 0 {
+		return len(s.Ranges) > 0
+	}
+	return len(s.Ranges) == 1 && s.Ranges[0][0] == 0 && s.Ranges[0][1] == s.Size
+}
+
+func (s *chunkSession) status() ChunkStatus {
+	received := s.Ranges
+	if received == nil {
+		received = make([][2]int64, 0)
+	}
+	return ChunkStatus{Cid: s.Cid, Name: s.Name, Size: s.Size, Received: received,
+		Missing: s.missing(), Checksum: s.Checksum, Complete: s.complete()}
+}
+
+// chunkStore stages chunked uploads in a temp directory. Every chunk is
+// written at its offset into one data file and the received ranges are
+// recorded in a manifest, so uploads can be resumed after an interruption.
+type chunkStore struct {
+	dir string
+	ttl time.Duration
+
+	mux    sync.Mutex
+	lastGC time.Time
+}
+
+func newChunkStore(dir string) *chunkStore {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "elfinder-chunks")
+	}
+	return &chunkStore{dir: dir, ttl: defaultChunkTTL}
+}
+
+// chunkOwner scopes the chunk sessions to the caller of ctx. Anonymous
+// callers share one scope and are only kept apart by their random cid.
+func chunkOwner(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.User
+	}
+	return ""
+}
+
+func chunkSessionKey(owner, cid, name string) (string, error) {
+	if !chunkCidRegexp.MatchString(cid) {
+		return "", fmt.Errorf("%w: cid %s", ErrInvalidChunk, cid)
+	}
+	sum := md5.Sum([]byte(owner + "\x00" + name))
+	return cid + "_" + hex.EncodeToString(sum[:]), nil
+}
+
+func (s *chunkStore) dataPath(key string) string {
+	return filepath.Join(s.dir, key+".data")
+}
+
+func (s *chunkStore) manifestPath(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+// loadSession must be called with s.mux held.
+func (s *chunkStore) loadSession(key string) (*chunkSession, error) {
+	data, err := os.ReadFile(s.manifestPath(key))
+	if err != nil {
+		return nil, err
+	}
+	var session chunkSession
+	if err = json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// saveSession must be called with s.mux held.
+func (s *chunkStore) saveSession(key string, session *chunkSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.manifestPath(key) + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.manifestPath(key))
+}
+
+// Write stores length bytes of reader at offset of the file name uploaded
+// by owner as cid. When the range completes the file, the merged name to be
+// sent back as _chunkmerged is returned.
+func (s *chunkStore) Write(owner, cid, name string, offset, length, total int64, checksum string, reader io.Reader) (merged string, err error) {
+	key, err := chunkSessionKey(owner, cid, name)
+	if err != nil {
+		return "", err
+	}
+	s.gc()
+	if err = os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+	s.mux.Lock()
+	session, err := s.loadSession(key)
+	if errors.Is(err, os.ErrNotExist) {
+		session = &chunkSession{Owner: owner, Cid: cid, Name: name, Size: total}
+		err = s.saveSession(key, session)
+	}
+	s.mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if session.Size != total {
+		return "", fmt.Errorf("%w: size %d does not match %d", ErrInvalidChunk, total, session.Size)
+	}
+
+	fd, err := os.OpenFile(s.dataPath(key), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = fd.Seek(offset, io.SeekStart); err == nil {
+		var n int64
+		n, err = io.Copy(fd, io.LimitReader(reader, length))
+		if err == nil && n != length {
+			err = fmt.Errorf("%w: received %d of %d bytes", ErrInvalidChunk, n, length)
+		}
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if session, err = s.loadSession(key); err != nil {
+		return "", err
+	}
+	session.addRange(offset, offset+length)
+	if checksum != "" {
+		session.Checksum = strings.ToLower(checksum)
+	}
+	if err = s.saveSession(key, session); err != nil {
+		return "", err
+	}
+	if session.complete() {
+		return key, nil
+	}
+	return "", nil
+}
+
+// Status returns the bookkeeping of the upload of name by owner as cid.
+func (s *chunkStore) Status(owner, cid, name string) (ChunkStatus, error) {
+	key, err := chunkSessionKey(owner, cid, name)
+	if err != nil {
+		return ChunkStatus{}, err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	session, err := s.loadSession(key)
+	if errors.Is(err, os.ErrNotExist) {
+		session, err = &chunkSession{Owner: owner, Cid: cid, Name: name}, nil
+	}
+	if err != nil {
+		return ChunkStatus{}, err
+	}
+	return session.status(), nil
+}
+
+// Open returns the completed upload of name by owner announced as
+// _chunkmerged after verifying its SHA-256 against checksum or the one sent
+// with the chunks.
+func (s *chunkStore) Open(owner, merged, name, checksum string) (*os.File, error) {
+	if !mergedNameRegexp.MatchString(merged) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidChunk, merged)
+	}
+	s.mux.Lock()
+	session, err := s.loadSession(merged)
+	s.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if session.Owner != owner || session.Name != name {
+		return nil, fmt.Errorf("%w: %s is not an upload of %s", ErrInvalidChunk, merged, name)
+	}
+	if !session.complete() {
+		return nil, ErrChunkIncomplete
+	}
+	if checksum == "" {
+		checksum = session.Checksum
+	}
+	fd, err := os.Open(s.dataPath(merged))
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		hash := sha256.New()
+		if _, err = io.Copy(hash, fd); err == nil {
+			if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+				err = ErrChunkChecksum
+			} else {
+				_, err = fd.Seek(0, io.SeekStart)
+			}
+		}
+		if err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+	}
+	return fd, nil
+}
+
+func (s *chunkStore) Remove(merged string) error {
+	if !mergedNameRegexp.MatchString(merged) {
+		return fmt.Errorf("%w: %s", ErrInvalidChunk, merged)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_ = os.Remove(s.manifestPath(merged))
+	return os.Remove(s.dataPath(merged))
+}
+
+// gc removes sessions abandoned by the client.
+func (s *chunkStore) gc() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if time.Since(s.lastGC) < defaultChunkGCInterval {
+		return
+	}
+	s.lastGC = time.Now()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for i := range entries {
+		info, err := entries[i].Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > s.ttl {
+			_ = os.RemoveAll(filepath.Join(s.dir, entries[i].Name()))
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package connection
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+const duplicateSuffix = "_duplicate_"
+
+type DuplicateRequest struct {
+	Targets []string `elfinder:"targets[]"`
+}
+
+type DuplicateResponse struct {
+	Added    []model.FileInfo `json:"added"`
+	Warnings []ErrResponse    `json:"warning,omitempty"`
+}
+
+func DuplicateCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param DuplicateRequest
+		res   DuplicateResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	res.Added = []model.FileInfo{}
+	for _, target := range param.Targets {
+		id, vol, srcPath, err := connector.resolveTarget(req.Context(), target)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRFileNotFound, err))
+			continue
+		}
+		srcInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, srcPath)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRFileNotFound, errors.New(target)))
+			continue
+		}
+		if srcInfo.Isroot == 1 {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name)))
+			continue
+		}
+		if srcInfo.ReadAble == 0 {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRPerm, errors.New(srcInfo.Name)))
+			continue
+		}
+		dirPath := path.Dir(srcPath)
+		if _, errRes := connector.writableDir(req.Context(), id, vol, dirPath); errRes != nil {
+			res.Warnings = append(res.Warnings, *errRes)
+			continue
+		}
+		newName, err := uniqueFsVolName(vol, relativeVolPath(vol, dirPath), srcInfo.Name, duplicateSuffix)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name), err))
+			continue
+		}
+		newPath := path.Join(dirPath, newName)
+		if err = copyFsVolEntry(vol, relativeVolPath(vol, srcPath), vol, relativeVolPath(vol, newPath),
+			connector.hiddenFilter(req.Context(), vol)); err != nil {
+			connector.Logger.Errorf("duplicate %s errs: %s", srcPath, err)
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name), err))
+			continue
+		}
+		newInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, newPath)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name), err))
+			continue
+		}
+		res.Added = append(res.Added, newInfo)
+	}
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
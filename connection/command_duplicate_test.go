@@ -0,0 +1,27 @@
+package connection
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestDuplicate(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"a.txt", "d/b.txt"})
+	q := url.Values{"cmd": {"duplicate"}, "targets[]": {testTarget(c, vol, "/a.txt"), testTarget(c, vol, "/d")}}
+	res := getTest(t, c, q)
+	if names := addedNames(t, res); res.Warning != nil || fmt.Sprint(names) != "[a_duplicate_1.txt d_duplicate_1]" {
+		t.Errorf("duplicate: %v %+v", names, res)
+	}
+	res = getTest(t, c, q)
+	if names := addedNames(t, res); fmt.Sprint(names) != "[a_duplicate_2.txt d_duplicate_2]" {
+		t.Errorf("duplicate again: %v %+v", names, res)
+	}
+	if got := readTestFile(t, vol.Root(), "d_duplicate_1/b.txt"); got != "d/b.txt" {
+		t.Errorf("duplicated folder holds %q", got)
+	}
+	res = getTest(t, c, url.Values{"cmd": {"duplicate"}, "targets[]": {testTarget(c, vol, ""), testTarget(c, vol, "/missing")}})
+	if len(res.Warning) != 2 || len(res.Added) != 0 {
+		t.Errorf("duplicate the root and a missing file: %+v", res)
+	}
+}
@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LeeEirc/elfinder"
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+type FileRequest struct {
+	Target   string `elfinder:"target"`
+	Download bool   `elfinder:"download"`
+	Cpath    string `elfinder:"cpath"`
+	ReqId    string `elfinder:"reqid"`
+}
+
+func FileCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var param FileRequest
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		if jsonErr := SendJson(rw, NewErr(errs.ERRCmdReq, err)); jsonErr != nil {
+			connector.Logger.Error(jsonErr)
+		}
+		return
+	}
+	if param.Cpath != "" {
+		// the client polls this cookie to know that the download has started
+		http.SetCookie(rw, &http.Cookie{Path: param.Cpath, Name: "elfdl" + param.ReqId, Value: "1"})
+	}
+	id, vol, path, err := connector.resolveTarget(req.Context(), param.Target)
+	if err != nil {
+		connector.Logger.Errorf("resolve target %s errs: %s", param.Target, err)
+		connector.sendError(rw, errs.ERRFileNotFound, err)
+		return
+	}
+	if fileInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, path); err != nil {
+		connector.sendError(rw, errs.ERRFileNotFound, err)
+		return
+	} else if fileInfo.ReadAble == 0 {
+		connector.sendError(rw, errs.ERRPerm, errors.New(fileInfo.Name))
+		return
+	}
+	fd, err := vol.Open(relativeVolPath(vol, path))
+	if err != nil {
+		connector.Logger.Errorf("open file %s errs: %s", path, err)
+		errType := errs.ERROpen
+		if errors.Is(err, fs.ErrNotExist) {
+			errType = errs.ERRFileNotFound
+		}
+		if jsonErr := SendJson(rw, NewErr(errType, err)); jsonErr != nil {
+			connector.Logger.Error(jsonErr)
+		}
+		return
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		connector.Logger.Error(err)
+		if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err)); jsonErr != nil {
+			connector.Logger.Error(jsonErr)
+		}
+		return
+	}
+	if info.IsDir() {
+		if jsonErr := SendJson(rw, NewErr(errs.ERRNotFile, errors.New(info.Name()))); jsonErr != nil {
+			connector.Logger.Error(jsonErr)
+		}
+		return
+	}
+	name := info.Name()
+	header := rw.Header()
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType != "" {
+		header.Set(elfinder.HeaderContentType, mimeType)
+	}
+	header.Set("Etag", fileETag(info))
+	// a file the browser would run, e.g. html, must not render in the origin
+	// of the connector
+	header.Set("X-Content-Type-Options", "nosniff")
+	disposition := "inline"
+	if param.Download || !connector.volOption(req.Context(), id).inline(mimeType) {
+		disposition = "attachment"
+	}
+	header.Set(elfinder.HeaderContentDisposition,
+		mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	content, ok := fd.(io.ReadSeeker)
+	if !ok {
+		if err = serveStream(rw, req, info, fd); err != nil {
+			connector.Logger.Errorf("send file %s errs: %s", path, err)
+		}
+		return
+	}
+	// ServeContent sniffs Content-Type when it is unset and handles Range and
+	// conditional requests using the Etag and modification time.
+	http.ServeContent(rw, req, name, info.ModTime(), content)
+}
+
+// serveStream sends a file that cannot seek as a whole, Range requests are
+// answered with the full content and conditional ones only by Etag.
+func serveStream(rw http.ResponseWriter, req *http.Request, info fs.FileInfo, r io.Reader) error {
+	header := rw.Header()
+	header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "none")
+	if etagMatch(req.Header.Get("If-None-Match"), header.Get("Etag")) {
+		header.Del(elfinder.HeaderContentType)
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	br := bufio.NewReader(r)
+	if header.Get(elfinder.HeaderContentType) == "" {
+		head, _ := br.Peek(512)
+		header.Set(elfinder.HeaderContentType, http.DetectContentType(head))
+	}
+	header.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return nil
+	}
+	_, err := io.Copy(rw, br)
+	return err
+}
+
+// etagMatch reports whether the If-None-Match list matches etag, weakly
+// like net/http does.
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// fileETag returns the entity tag the volume knows for info, or one made of
+// the modification time and size.
+func fileETag(info fs.FileInfo) string {
+	if etagger, ok := info.(volumes.ETagger); ok {
+		if etag := etagger.ETag(); etag != "" {
+			if !strings.HasSuffix(etag, `"`) {
+				etag = `"` + etag + `"`
+			}
+			return etag
+		}
+	}
+	return `"` + strconv.FormatInt(info.ModTime().UnixNano(), 36) +
+		"-" + strconv.FormatInt(info.Size(), 36) + `"`
+}
@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+func fileTest(c *Connector, q url.Values, header http.Header) *httptest.ResponseRecorder {
+	q.Set("cmd", "file")
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestFileCommand(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"a.txt", "page.html"})
+	target := testTarget(c, vol, "/a.txt")
+
+	rw := fileTest(c, url.Values{"target": {target}}, nil)
+	if rw.Code != http.StatusOK || rw.Body.String() != "a.txt" {
+		t.Fatalf("file: %d %q", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Get("Content-Disposition"); got != `inline; filename=a.txt` {
+		t.Errorf("disposition of a text file: %q", got)
+	}
+	if got := rw.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options %q", got)
+	}
+	etag := rw.Header().Get("Etag")
+
+	rw = fileTest(c, url.Values{"target": {target}}, http.Header{"Range": {"bytes=2-"}})
+	if rw.Code != http.StatusPartialContent || rw.Body.String() != "txt" ||
+		rw.Header().Get("Content-Range") != "bytes 2-4/5" {
+		t.Errorf("range: %d %q %q", rw.Code, rw.Body.String(), rw.Header().Get("Content-Range"))
+	}
+
+	rw = fileTest(c, url.Values{"target": {target}}, http.Header{"If-None-Match": {etag}})
+	if etag == "" || rw.Code != http.StatusNotModified || rw.Body.Len() != 0 {
+		t.Errorf("If-None-Match %q: %d %q", etag, rw.Code, rw.Body.String())
+	}
+
+	rw = fileTest(c, url.Values{"target": {target}, "download": {"1"}, "cpath": {"/files"}, "reqid": {"42"}}, nil)
+	if got := rw.Header().Get("Content-Disposition"); got != `attachment; filename=a.txt` {
+		t.Errorf("disposition of a download: %q", got)
+	}
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "elfdl42" || cookies[0].Value != "1" || cookies[0].Path != "/files" {
+		t.Errorf("download cookies %v", cookies)
+	}
+
+	rw = fileTest(c, url.Values{"target": {testTarget(c, vol, "/page.html")}}, nil)
+	if got := rw.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment;") {
+		t.Errorf("disposition of a html file: %q", got)
+	}
+}
+
+type etagFileInfo struct {
+	fs.FileInfo
+	etag string
+}
+
+func (i etagFileInfo) ETag() string { return i.etag }
+
+type sizeFileInfo struct{ fs.FileInfo }
+
+func (sizeFileInfo) Size() int64        { return 5 }
+func (sizeFileInfo) ModTime() time.Time { return time.Unix(1, 0) }
+
+func TestFileETag(t *testing.T) {
+	for _, tc := range []struct {
+		info fs.FileInfo
+		want string
+	}{
+		{etagFileInfo{sizeFileInfo{}, `"abc"`}, `"abc"`},
+		{etagFileInfo{sizeFileInfo{}, `W/"abc"`}, `W/"abc"`},
+		{etagFileInfo{sizeFileInfo{}, "abc"}, `"abc"`},
+		{etagFileInfo{sizeFileInfo{}, ""}, fileETag(sizeFileInfo{})},
+	} {
+		if got := fileETag(tc.info); got != tc.want {
+			t.Errorf("etag %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestFileOfMapFSVolume(t *testing.T) {
+	vol, err := volumes.NewMemoryFrom("map", fstest.MapFS{"a.txt": {Data: []byte("hello")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewConnector(WithVolumes(vol))
+	res := getTest(t, c, url.Values{"cmd": {"open"}, "target": {testTarget(c, vol, "")}})
+	if res.Error != nil || len(res.Files) != 2 {
+		t.Fatalf("open: %+v", res)
+	}
+	// the root is the read-only folder MapFS synthesizes
+	if file := res.Files[1]; file.Name != "a.txt" || file.Read != 1 || file.Write != 1 || file.Locked != 0 {
+		t.Errorf("%+v", file)
+	}
+	rw := fileTest(c, url.Values{"target": {testTarget(c, vol, "/a.txt")}}, nil)
+	if rw.Code != http.StatusOK || rw.Body.String() != "hello" {
+		t.Errorf("file: %d %q", rw.Code, rw.Body.String())
+	}
+}
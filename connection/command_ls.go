@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LeeEirc/elfinder/codecs"
 	"github.com/LeeEirc/elfinder/errs"
@@ -33,12 +34,12 @@ func LsCommand(connector *Connector, req *http.Request, rw http.ResponseWriter)
 		err  error
 		vol  volumes.FsVolume
 	)
-	vol = connector.DefaultVol
-	id = connector.GetVolId(connector.DefaultVol)
-	path = fmt.Sprintf("/%s", vol.Name())
+	if id, vol = connector.defaultVolume(req.Context()); vol != nil {
+		path = fmt.Sprintf("/%s", vol.Name())
+	}
 
 	if lsReq.Target != "" {
-		id, path, err = connector.ParseTarget(lsReq.Target)
+		id, vol, path, err = connector.resolveTarget(req.Context(), lsReq.Target)
 		if err != nil {
 			connector.Logger.Errorf("parse target %s errs: %s", lsReq.Target, err)
 			if jsonErr := SendJson(rw, NewErr(errs.ERRCmdParams, err)); jsonErr != nil {
@@ -46,7 +47,6 @@ func LsCommand(connector *Connector, req *http.Request, rw http.ResponseWriter)
 			}
 			return
 		}
-		vol = connector.GetFsById(id)
 	}
 	if vol == nil {
 		connector.Logger.Errorf("not found vol by id: %s", id)
@@ -56,7 +56,14 @@ func LsCommand(connector *Connector, req *http.Request, rw http.ResponseWriter)
 		return
 	}
 
-	resFiles, err := ReadFsVolDir(id, vol, path)
+	if cwd, err := connector.StatFsVolFileByPath(req.Context(), id, vol, path); err != nil {
+		connector.sendError(rw, errs.ERROpen, err)
+		return
+	} else if cwd.ReadAble == 0 {
+		connector.sendError(rw, errs.ERRPerm, errors.New(cwd.Name))
+		return
+	}
+	resFiles, err := connector.ReadFsVolDir(req.Context(), id, vol, path)
 	if err != nil {
 		if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err)); jsonErr != nil {
 			connector.Logger.Error(jsonErr)
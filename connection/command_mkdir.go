@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+type MkdirRequest struct {
+	Target string   `elfinder:"target"`
+	Name   string   `elfinder:"name"`
+	Dirs   []string `elfinder:"dirs[]"`
+}
+
+type MkdirResponse struct {
+	Added   []model.FileInfo  `json:"added"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+	Changed []model.FileInfo  `json:"changed,omitempty"`
+}
+
+func MkdirCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param MkdirRequest
+		res   MkdirResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	id, vol, dirPath, err := connector.resolveTarget(req.Context(), param.Target)
+	if err != nil {
+		connector.Logger.Errorf("resolve target %s errs: %s", param.Target, err)
+		connector.sendError(rw, errs.ERRTrgFolderNotFound, err)
+		return
+	}
+	if _, errRes := connector.writableDir(req.Context(), id, vol, dirPath); errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
+		return
+	}
+	res.Added = []model.FileInfo{}
+	if param.Name != "" {
+		if !connector.isAllowedName(param.Name) {
+			connector.sendError(rw, errs.ERRInvName, errors.New(param.Name))
+			return
+		}
+		newPath := path.Join(dirPath, param.Name)
+		relativePath := relativeVolPath(vol, newPath)
+		if _, err = fs.Stat(vol, relativePath); err == nil {
+			connector.sendError(rw, errs.ERRExists, errors.New(param.Name))
+			return
+		}
+		if err = vol.Mkdir(relativePath); err != nil {
+			connector.Logger.Errorf("mkdir %s errs: %s", newPath, err)
+			connector.sendError(rw, errs.ERRMkdir, errors.New(param.Name), err)
+			return
+		}
+		info, err := connector.StatFsVolFileByPath(req.Context(), id, vol, newPath)
+		if err != nil {
+			connector.Logger.Error(err)
+			connector.sendError(rw, errs.ERRMkdir, errors.New(param.Name), err)
+			return
+		}
+		res.Added = append(res.Added, info)
+	}
+
+	// dirs[] carries a folder tree like ["/a", "/a/b"] created by folder upload.
+	if len(param.Dirs) > 0 {
+		res.Hashes = make(map[string]string, len(param.Dirs))
+		for _, dir := range param.Dirs {
+			cleanDir := path.Clean(model.Separator + dir)
+			if cleanDir == model.Separator {
+				continue
+			}
+			current := dirPath
+			for _, name := range strings.Split(strings.TrimPrefix(cleanDir, model.Separator), model.Separator) {
+				if !connector.isAllowedName(name) {
+					connector.sendError(rw, errs.ERRInvName, errors.New(dir))
+					return
+				}
+				current = path.Join(current, name)
+				relativePath := relativeVolPath(vol, current)
+				stat, err := fs.Stat(vol, relativePath)
+				if err == nil {
+					if !stat.IsDir() {
+						connector.sendError(rw, errs.ERRExists, errors.New(dir))
+						return
+					}
+					continue
+				}
+				if _, errRes := connector.writableDir(req.Context(), id, vol, path.Dir(current)); errRes != nil {
+					connector.sendErrResponse(rw, *errRes)
+					return
+				}
+				if err = vol.Mkdir(relativePath); err != nil {
+					connector.Logger.Errorf("mkdir %s errs: %s", current, err)
+					connector.sendError(rw, errs.ERRMkdir, errors.New(dir), err)
+					return
+				}
+				info, err := connector.StatFsVolFileByPath(req.Context(), id, vol, current)
+				if err != nil {
+					connector.Logger.Error(err)
+					connector.sendError(rw, errs.ERRMkdir, errors.New(dir), err)
+					return
+				}
+				res.Added = append(res.Added, info)
+			}
+			res.Hashes[dir] = connector.EncodeTarget(id, current)
+		}
+	}
+	if parentInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, dirPath); err == nil {
+		res.Changed = append(res.Changed, parentInfo)
+	}
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
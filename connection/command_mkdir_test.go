@@ -0,0 +1,78 @@
+package connection
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestMkdirAndMkfileNames(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"dir/", "f.txt"},
+		WithForbiddenNames(regexp.MustCompile(`^\.`)))
+	root := testTarget(c, vol, "")
+	for _, cmd := range []string{"mkdir", "mkfile"} {
+		for _, tc := range []struct {
+			name    string
+			errType string
+		}{
+			{"..", "errInvName"},
+			{"a/b", "errInvName"},
+			{".hidden", "errInvName"},
+			{"dir", "errExists"},
+			{"f.txt", "errExists"},
+			{"new-" + cmd, ""},
+		} {
+			res := getTest(t, c, url.Values{"cmd": {cmd}, "target": {root}, "name": {tc.name}})
+			if res.errType() != tc.errType {
+				t.Errorf("%s %q: %+v, want %q", cmd, tc.name, res, tc.errType)
+			}
+			if tc.errType == "" && len(res.Added) != 1 {
+				t.Errorf("%s %q added %d entries", cmd, tc.name, len(res.Added))
+			}
+		}
+	}
+	if info, err := os.Stat(filepath.Join(vol.Root(), "new-mkdir")); err != nil || !info.IsDir() {
+		t.Errorf("new folder: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(vol.Root(), "new-mkfile")); err != nil || info.IsDir() || info.Size() != 0 {
+		t.Errorf("new file: %v", err)
+	}
+	if res := getTest(t, c, url.Values{"cmd": {"mkdir"}, "target": {testTarget(c, vol, "/f.txt")}, "name": {"x"}}); res.errType() != "errTrgFolderNotFound" {
+		t.Errorf("mkdir in a file: %+v", res)
+	}
+}
+
+func TestMkdirDirs(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"a/", "f.txt"},
+		WithForbiddenNames(regexp.MustCompile(`^\.`)))
+	root := testTarget(c, vol, "")
+	res := getTest(t, c, url.Values{"cmd": {"mkdir"}, "target": {root}, "dirs[]": {"/x", "/x/y", "/a/z"}})
+	if res.Error != nil || len(res.Added) != 3 {
+		t.Fatalf("dirs: %+v", res)
+	}
+	for dir, p := range map[string]string{"/x": "/x", "/x/y": "/x/y", "/a/z": "/a/z"} {
+		if res.Hashes[dir] != testTarget(c, vol, p) {
+			t.Errorf("hash of %s: %q", dir, res.Hashes[dir])
+		}
+		if info, err := os.Stat(filepath.Join(vol.Root(), filepath.FromSlash(p))); err != nil || !info.IsDir() {
+			t.Errorf("folder %s: %v", p, err)
+		}
+	}
+	for dir, errType := range map[string]string{
+		"/b/.git":  "errInvName",
+		"/f.txt/z": "errExists",
+	} {
+		if res := getTest(t, c, url.Values{"cmd": {"mkdir"}, "target": {root}, "dirs[]": {dir}}); res.errType() != errType {
+			t.Errorf("dirs %s: %+v, want %s", dir, res, errType)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(vol.Root(), "f.txt", "z")); err == nil {
+		t.Error("folder created below a file")
+	}
+	res = getTest(t, c, url.Values{"cmd": {"mkdir"}, "target": {root}, "dirs[]": {"/../../escape"}})
+	if _, err := os.Stat(filepath.Join(vol.Root(), "escape")); res.Error != nil || err != nil {
+		t.Errorf("dirs escaping the target are cleaned to it: %+v %v", res, err)
+	}
+}
@@ -0,0 +1,78 @@
+package connection
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+type MkfileRequest struct {
+	Target string `elfinder:"target"`
+	Name   string `elfinder:"name"`
+}
+
+type MkfileResponse struct {
+	Added   []model.FileInfo `json:"added"`
+	Changed []model.FileInfo `json:"changed,omitempty"`
+}
+
+func MkfileCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param MkfileRequest
+		res   MkfileResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	id, vol, dirPath, err := connector.resolveTarget(req.Context(), param.Target)
+	if err != nil {
+		connector.Logger.Errorf("resolve target %s errs: %s", param.Target, err)
+		connector.sendError(rw, errs.ERRTrgFolderNotFound, err)
+		return
+	}
+	if _, errRes := connector.writableDir(req.Context(), id, vol, dirPath); errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
+		return
+	}
+	if !connector.isAllowedName(param.Name) {
+		connector.sendError(rw, errs.ERRInvName, errors.New(param.Name))
+		return
+	}
+	newPath := path.Join(dirPath, param.Name)
+	relativePath := relativeVolPath(vol, newPath)
+	if _, err = fs.Stat(vol, relativePath); err == nil {
+		connector.sendError(rw, errs.ERRExists, errors.New(param.Name))
+		return
+	}
+	writer, err := vol.Create(relativePath)
+	if err != nil {
+		connector.Logger.Errorf("mkfile %s errs: %s", newPath, err)
+		connector.sendError(rw, errs.ERRMkfile, errors.New(param.Name), err)
+		return
+	}
+	if err = writer.Close(); err != nil {
+		connector.Logger.Errorf("mkfile %s errs: %s", newPath, err)
+		connector.sendError(rw, errs.ERRMkfile, errors.New(param.Name), err)
+		return
+	}
+	info, err := connector.StatFsVolFileByPath(req.Context(), id, vol, newPath)
+	if err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRMkfile, errors.New(param.Name), err)
+		return
+	}
+	res.Added = append(res.Added, info)
+	if parentInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, dirPath); err == nil {
+		res.Changed = append(res.Changed, parentInfo)
+	}
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
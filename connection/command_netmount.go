@@ -0,0 +1,119 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+// protocolNetUnmount is the protocol of netmount requests unmounting the
+// volume with the netkey in host and the root hash in user.
+const protocolNetUnmount = "netunmount"
+
+type NetmountRequest struct {
+	Protocol string `elfinder:"protocol"`
+	Host     string `elfinder:"host"`
+	Port     string `elfinder:"port"`
+	Path     string `elfinder:"path"`
+	User     string `elfinder:"user"`
+	Pass     string `elfinder:"pass"`
+}
+
+type NetmountResponse struct {
+	Added   []model.FileInfo `json:"added,omitempty"`
+	Removed []string         `json:"removed,omitempty"`
+	Sync    bool             `json:"sync,omitempty"`
+}
+
+func NetmountCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param NetmountRequest
+		res   NetmountResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	if param.Protocol == protocolNetUnmount {
+		netUnmount(connector, req, rw, param)
+		return
+	}
+	driver, ok := connector.netDrivers[param.Protocol]
+	if !ok {
+		connector.sendError(rw, errs.ERRNetMountNoDriver, errors.New(param.Protocol))
+		return
+	}
+	if param.Host == "" {
+		connector.sendError(rw, errs.ERRNetMountHostReq)
+		return
+	}
+	vol, err := driver(req.Context(), NetMountParams{
+		Protocol: param.Protocol,
+		Host:     param.Host,
+		Port:     param.Port,
+		Path:     param.Path,
+		User:     param.User,
+		Pass:     param.Pass,
+	})
+	if err != nil {
+		connector.Logger.Errorf("netmount %s://%s errs: %s", param.Protocol, param.Host, err)
+		connector.sendError(rw, errs.ERRNetMountFailed, err)
+		return
+	}
+	key, err := connector.ensureNetSession(req, rw)
+	if err != nil {
+		closeVolume(vol)
+		connector.sendError(rw, errs.ERRNetMountFailed, err)
+		return
+	}
+	vid, err := connector.addNetMount(key, vol)
+	if err != nil {
+		closeVolume(vol)
+		connector.sendError(rw, errs.ERRNetMountFailed, err)
+		return
+	}
+	ctx := context.WithValue(req.Context(), netSessionCtxKey{}, key)
+	root, err := connector.StatFsVolFileByPath(ctx, vid, vol, fmt.Sprintf("/%s", vol.Name()))
+	if err != nil {
+		connector.removeNetMount(key, vid)
+		connector.sendError(rw, errs.ERRNetMountFailed, err)
+		return
+	}
+	opt := connector.clientOption(ctx, vid, root.Name)
+	root.Options = &opt
+	connector.Logger.Infof("netmount %s://%s as %s", param.Protocol, param.Host, vid)
+	res.Added = []model.FileInfo{root}
+	res.Sync = true
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
+
+// netUnmount removes the volume with the netkey param.Host, param.User is the
+// hash of its root.
+func netUnmount(connector *Connector, req *http.Request, rw http.ResponseWriter, param NetmountRequest) {
+	key, ok := req.Context().Value(netSessionCtxKey{}).(string)
+	if !ok {
+		connector.sendError(rw, errs.ERRNetUnMount, ErrNoNetSession)
+		return
+	}
+	vid, _, err := connector.ParseTarget(param.User)
+	if err != nil || vid != param.Host {
+		connector.sendError(rw, errs.ERRNetUnMount, fmt.Errorf("%w: %s", ErrNoFoundVol, param.Host))
+		return
+	}
+	if _, ok = connector.removeNetMount(key, vid); !ok {
+		connector.sendError(rw, errs.ERRNetUnMount, fmt.Errorf("%w: %s", ErrNoFoundVol, param.Host))
+		return
+	}
+	res := NetmountResponse{Removed: []string{param.User}}
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
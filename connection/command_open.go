@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -38,18 +39,17 @@ func OpenCommand(connector *Connector, req *http.Request, rw http.ResponseWriter
 		connector.Logger.Error(err)
 		return
 	}
-	res.UplMaxSize = "32M"
 	var (
 		id   string
 		path string
 		err  error
 		vol  volumes.FsVolume
 	)
-	vol = connector.DefaultVol
-	id = connector.GetVolId(connector.DefaultVol)
-	path = fmt.Sprintf("/%s", vol.Name())
+	if id, vol = connector.defaultVolume(req.Context()); vol != nil {
+		path = fmt.Sprintf("/%s", vol.Name())
+	}
 	if param.Target != "" {
-		id, path, err = connector.ParseTarget(param.Target)
+		id, vol, path, err = connector.resolveTarget(req.Context(), param.Target)
 		if err != nil {
 			connector.Logger.Errorf("parse target %s errs: %s", param.Target, err)
 			if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err)); jsonErr != nil {
@@ -57,7 +57,6 @@ func OpenCommand(connector *Connector, req *http.Request, rw http.ResponseWriter
 			}
 			return
 		}
-		vol = connector.GetFsById(id)
 	}
 	if vol == nil {
 		connector.Logger.Errorf("not found vol by id: %s", id)
@@ -67,15 +66,19 @@ func OpenCommand(connector *Connector, req *http.Request, rw http.ResponseWriter
 		return
 	}
 
-	cwd, err2 := StatFsVolFileByPath(id, vol, path)
+	cwd, err2 := connector.StatFsVolFileByPath(req.Context(), id, vol, path)
 	if err2 != nil {
 		if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err2)); jsonErr != nil {
 			connector.Logger.Error(jsonErr)
 		}
 		return
 	}
+	if cwd.ReadAble == 0 {
+		connector.sendError(rw, errs.ERRPerm, errors.New(cwd.Name))
+		return
+	}
 	res.Cwd = cwd
-	resFiles, err := ReadFsVolDir(id, vol, path)
+	resFiles, err := connector.ReadFsVolDir(req.Context(), id, vol, path)
 	if err != nil {
 		if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err)); jsonErr != nil {
 			connector.Logger.Error(jsonErr)
@@ -86,27 +89,46 @@ func OpenCommand(connector *Connector, req *http.Request, rw http.ResponseWriter
 	res.Files = append(res.Files, resFiles...)
 
 	if param.Tree {
-		for vid := range connector.Vols {
-			if connector.Vols[vid].Name() != vol.Name() {
-				vItem, err3 := StatFsVolFileByPath(vid, connector.Vols[vid], fmt.Sprintf("/%s", vol.Name()))
-				if err3 != nil {
-					connector.Logger.Error(err3)
-					if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err3)); jsonErr != nil {
-						connector.Logger.Error(jsonErr)
-					}
-					return
+		for _, mounted := range connector.volumeList(req.Context()) {
+			if mounted.ID == id {
+				continue
+			}
+			vItem, err3 := connector.StatFsVolFileByPath(req.Context(), mounted.ID, mounted.Volume, fmt.Sprintf("/%s", mounted.Volume.Name()))
+			if err3 != nil {
+				connector.Logger.Error(err3)
+				if jsonErr := SendJson(rw, NewErr(errs.ERROpen, err3)); jsonErr != nil {
+					connector.Logger.Error(jsonErr)
 				}
-				res.Files = append(res.Files, vItem)
-
+				return
+			}
+			res.Files = append(res.Files, vItem)
+		}
+		for _, vid := range connector.netMountIds(req.Context()) {
+			if vid == id {
+				continue
+			}
+			mountVol := connector.GetFsById(req.Context(), vid)
+			if mountVol == nil {
+				continue
+			}
+			vItem, err3 := connector.StatFsVolFileByPath(req.Context(), vid, mountVol, fmt.Sprintf("/%s", mountVol.Name()))
+			if err3 != nil {
+				// an unreachable network volume must not break the whole tree
+				connector.Logger.Errorf("stat network volume %s errs: %s", mountVol.Name(), err3)
+				continue
 			}
+			res.Files = append(res.Files, vItem)
 		}
 	}
+	policy := connector.uploadPolicyOf(req.Context(), id)
+	res.UplMaxSize = policy.uplMaxSize()
+	res.UplMaxFile = policy.MaxFiles
+	opt := connector.clientOption(req.Context(), id, res.Cwd.Name)
+	res.Cwd.Options = &opt
 	if param.Init {
 		res.Api = elfinder.APIVERSION
-		opt := model.NewDefaultOption()
-		opt.Path = res.Cwd.Name
 		res.Options = opt
-		res.Cwd.Options = &opt
+		res.NetDrivers = connector.netDriverNames()
 	}
 	if err := SendJson(rw, &res); err != nil {
 		connector.Logger.Error(err)
@@ -31,7 +31,7 @@ func ParentsCommand(connector *Connector, req *http.Request, rw http.ResponseWri
 		return
 	}
 	target := param.Target
-	id, path, err := connector.ParseTarget(target)
+	id, vol, path, err := connector.resolveTarget(req.Context(), target)
 	if err != nil {
 		connector.Logger.Error(err)
 		if jsonErr := SendJson(rw, NewErr(errs.ERRCmdReq, err)); jsonErr != nil {
@@ -39,10 +39,9 @@ func ParentsCommand(connector *Connector, req *http.Request, rw http.ResponseWri
 		}
 		return
 	}
-	vol := connector.Vols[id]
-	cwdInfo, err := StatFsVolFileByPath(id, vol, path)
-	if err != nil {
-		connector.Logger.Error(err)
+	cwdInfo, errRes := connector.readableDir(req.Context(), id, vol, path)
+	if errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
 		return
 	}
 	res.Tree = append(res.Tree, cwdInfo)
@@ -51,16 +50,18 @@ func ParentsCommand(connector *Connector, req *http.Request, rw http.ResponseWri
 		if path == "/" {
 			break
 		}
-		cwdInfo, err = StatFsVolFileByPath(id, vol, path)
+		cwdInfo, err = connector.StatFsVolFileByPath(req.Context(), id, vol, path)
 		if err != nil {
 			connector.Logger.Error(err)
+			connector.sendError(rw, errs.ERRFolderNotFound, err)
 			return
 		}
 		res.Tree = append(res.Tree, cwdInfo)
 
-		cwdDirs, err := ReadFsVolDir(id, vol, path)
+		cwdDirs, err := connector.ReadFsVolDir(req.Context(), id, vol, path)
 		if err != nil {
 			connector.Logger.Error(err)
+			connector.sendError(rw, errs.ERRFolderNotFound, err)
 			return
 		}
 		res.Tree = append(res.Tree, cwdDirs...)
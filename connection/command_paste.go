@@ -0,0 +1,223 @@
+package connection
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+const defaultRenameSuffix = "~"
+
+type PasteRequest struct {
+	Dst     string   `elfinder:"dst"`
+	Targets []string `elfinder:"targets[]"`
+	Cut     bool     `elfinder:"cut"`
+	Renames []string `elfinder:"renames[]"`
+	Suffix  string   `elfinder:"suffix"`
+}
+
+type PasteResponse struct {
+	Added    []model.FileInfo `json:"added"`
+	Removed  []string         `json:"removed"`
+	Changed  []model.FileInfo `json:"changed,omitempty"`
+	Warnings []ErrResponse    `json:"warning,omitempty"`
+}
+
+// PasteCommand copies or moves targets into dst. An existing item whose name
+// is listed in renames[] is kept as a backup renamed with suffix; other name
+// conflicts are overwritten or pasted under a new name depending on the
+// CopyOverwrite option of the dst volume. Failures of single targets are
+// reported in warning.
+func PasteCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param PasteRequest
+		res   PasteResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	dstId, dstVol, dstPath, err := connector.resolveTarget(req.Context(), param.Dst)
+	if err != nil {
+		connector.Logger.Errorf("resolve dst %s errs: %s", param.Dst, err)
+		connector.sendError(rw, errs.ERRTrgFolderNotFound, err)
+		return
+	}
+	if _, errRes := connector.writableDir(req.Context(), dstId, dstVol, dstPath); errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
+		return
+	}
+	suffix := param.Suffix
+	if suffix == "" {
+		suffix = defaultRenameSuffix
+	}
+	renames := make(map[string]bool, len(param.Renames))
+	for i := range param.Renames {
+		renames[param.Renames[i]] = true
+	}
+	overwrite := connector.volOption(req.Context(), dstId).CopyOverwrite
+	dstRelPath := relativeVolPath(dstVol, dstPath)
+
+	res.Added = []model.FileInfo{}
+	res.Removed = []string{}
+	for _, target := range param.Targets {
+		srcId, srcVol, srcPath, err := connector.resolveTarget(req.Context(), target)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRFileNotFound, err))
+			continue
+		}
+		srcInfo, err := connector.StatFsVolFileByPath(req.Context(), srcId, srcVol, srcPath)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRFileNotFound, errors.New(target)))
+			continue
+		}
+		name := srcInfo.Name
+		if srcInfo.Isroot == 1 {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(name)))
+			continue
+		}
+		if srcInfo.ReadAble == 0 {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRPerm, errors.New(name)))
+			continue
+		}
+		if param.Cut {
+			if errRes := connector.movable(req.Context(), srcId, srcVol, srcPath, srcInfo); errRes != nil {
+				res.Warnings = append(res.Warnings, *errRes)
+				continue
+			}
+		}
+		if param.Cut && srcInfo.MimeType == "directory" {
+			if lockedName, ok := connector.lockedChild(req.Context(), srcId, srcVol, srcPath); ok {
+				res.Warnings = append(res.Warnings, NewErr(errs.ERRLocked, errors.New(lockedName)))
+				continue
+			}
+		}
+		sameVol := srcId == dstId
+		if sameVol && srcInfo.MimeType == "directory" && isSubPath(srcPath, dstPath) {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopyInItself, errors.New(name)))
+			continue
+		}
+		srcRelPath := relativeVolPath(srcVol, srcPath)
+		newPath := path.Join(dstPath, name)
+		if sameVol && newPath == srcPath {
+			if param.Cut {
+				// moving into its own parent is a no-op
+				continue
+			}
+			if name, err = uniqueFsVolName(dstVol, dstRelPath, name, suffix); err != nil {
+				res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name), err))
+				continue
+			}
+			newPath = path.Join(dstPath, name)
+		}
+		newRelPath := relativeVolPath(dstVol, newPath)
+
+		if existInfo, err := connector.StatFsVolFileByPath(req.Context(), dstId, dstVol, newPath); err == nil {
+			// renaming or replacing the entry in the way takes the checks of rm
+			if renames[name] || overwrite {
+				if errRes := connector.movable(req.Context(), dstId, dstVol, newPath, existInfo); errRes != nil {
+					res.Warnings = append(res.Warnings, *errRes)
+					continue
+				}
+				if existInfo.MimeType == "directory" {
+					if lockedName, ok := connector.lockedChild(req.Context(), dstId, dstVol, newPath); ok {
+						res.Warnings = append(res.Warnings, NewErr(errs.ERRLocked, errors.New(lockedName)))
+						continue
+					}
+				}
+			}
+			switch {
+			case renames[name]:
+				backupName, err2 := uniqueFsVolName(dstVol, dstRelPath, name, suffix)
+				if err2 == nil {
+					err2 = dstVol.Rename(newRelPath, path.Join(dstRelPath, backupName))
+				}
+				if err2 != nil {
+					res.Warnings = append(res.Warnings, NewErr(errs.ERRRename, errors.New(name), err2))
+					continue
+				}
+				if backupInfo, err3 := connector.StatFsVolFileByPath(req.Context(), dstId, dstVol, path.Join(dstPath, backupName)); err3 == nil {
+					res.Added = append(res.Added, backupInfo)
+				}
+				res.Removed = append(res.Removed, existInfo.PathHash)
+			case overwrite:
+				if (existInfo.MimeType == "directory") != (srcInfo.MimeType == "directory") {
+					res.Warnings = append(res.Warnings, NewErr(errs.ERRNotReplace, errors.New(name)))
+					continue
+				}
+				if err2 := removeFsVolAll(dstVol, newRelPath); err2 != nil {
+					res.Warnings = append(res.Warnings, NewErr(errs.ERRReplace, errors.New(name), err2))
+					continue
+				}
+				res.Removed = append(res.Removed, existInfo.PathHash)
+			default:
+				if name, err = uniqueFsVolName(dstVol, dstRelPath, name, suffix); err != nil {
+					res.Warnings = append(res.Warnings, NewErr(errs.ERRCopy, errors.New(srcInfo.Name), err))
+					continue
+				}
+				newPath = path.Join(dstPath, name)
+				newRelPath = relativeVolPath(dstVol, newPath)
+			}
+		} else if errors.Is(err, errHidden) {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRPerm, errors.New(name)))
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopyTo, errors.New(name), err))
+			continue
+		}
+
+		moved := false
+		if param.Cut && sameVol {
+			if err = srcVol.Rename(srcRelPath, newRelPath); err == nil {
+				moved = true
+			} else {
+				connector.Logger.Infof("rename %s to %s errs: %s, fall back to copy", srcPath, newPath, err)
+			}
+		}
+		if !moved {
+			// a move takes hidden entries along, a copy leaves them out
+			var skip func(string) bool
+			if !param.Cut {
+				skip = connector.hiddenFilter(req.Context(), srcVol)
+			}
+			if err = copyFsVolEntry(srcVol, srcRelPath, dstVol, newRelPath, skip); err != nil {
+				connector.Logger.Errorf("copy %s to %s errs: %s", srcPath, newPath, err)
+				errType := errs.ERRCopy
+				if param.Cut {
+					errType = errs.ERRMove
+				}
+				res.Warnings = append(res.Warnings, NewErr(errType, errors.New(srcInfo.Name), err))
+				continue
+			}
+			if param.Cut {
+				if err = removeFsVolAll(srcVol, srcRelPath); err != nil {
+					connector.Logger.Errorf("remove source %s errs: %s", srcPath, err)
+					res.Warnings = append(res.Warnings, NewErr(errs.ERRRmSrc, errors.New(srcInfo.Name), err))
+				} else {
+					moved = true
+				}
+			}
+		}
+		if moved {
+			res.Removed = append(res.Removed, srcInfo.PathHash)
+		}
+		newInfo, err := connector.StatFsVolFileByPath(req.Context(), dstId, dstVol, newPath)
+		if err != nil {
+			res.Warnings = append(res.Warnings, NewErr(errs.ERRCopyTo, errors.New(name), err))
+			continue
+		}
+		res.Added = append(res.Added, newInfo)
+	}
+	if changed, err := connector.StatFsVolFileByPath(req.Context(), dstId, dstVol, dstPath); err == nil {
+		res.Changed = append(res.Changed, changed)
+	}
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
@@ -0,0 +1,126 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCutOutOfDisabledVolume(t *testing.T) {
+	src := newTestVolume(t, "src", []string{"a.txt"})
+	c, vol := newTestConnector(t, nil,
+		WithVolume(src, VolumeOption{Disabled: []string{cmdRm, "cut", cmdRename}}))
+	q := url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, src, "/a.txt")}, "dst": {testTarget(c, vol, "")}}
+	q.Set("cut", "1")
+	if res := getTest(t, c, q); res.errType() != "errCmdNoSupport" {
+		t.Errorf("cut out of a volume with rm disabled: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(src.Root(), "a.txt")); err != nil {
+		t.Error(err)
+	}
+	q.Del("cut")
+	if res := getTest(t, c, q); res.Error != nil || len(res.Added) != 1 {
+		t.Errorf("copy out of a volume with rm disabled: %+v", res)
+	}
+}
+
+// addedNames returns the names of the added entries of res.
+func addedNames(t *testing.T, res testResponse) []string {
+	t.Helper()
+	names := make([]string, 0, len(res.Added))
+	for _, raw := range res.Added {
+		var file testFile
+		if err := json.Unmarshal(raw, &file); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func readTestFile(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		t.Error(err)
+	}
+	return string(data)
+}
+
+func TestPasteConflicts(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"src/a.txt", "dst/a.txt"})
+	src, dst := testTarget(c, vol, "/src/a.txt"), testTarget(c, vol, "/dst")
+
+	res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {src}, "dst": {dst}})
+	if names := addedNames(t, res); res.Warning != nil || fmt.Sprint(names) != "[a~1.txt]" {
+		t.Errorf("paste onto an existing file: %v %+v", names, res)
+	}
+	res = getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {src}, "dst": {dst},
+		"renames[]": {"a.txt"}, "suffix": {".bak"}})
+	if names := addedNames(t, res); res.Warning != nil || fmt.Sprint(names) != "[a.bak1.txt a.txt]" ||
+		len(res.Removed) != 1 {
+		t.Errorf("paste with renames[]: %v %+v", names, res)
+	}
+	if got := readTestFile(t, vol.Root(), "dst/a.bak1.txt"); got != "dst/a.txt" {
+		t.Errorf("backup holds %q", got)
+	}
+	if got := readTestFile(t, vol.Root(), "dst/a.txt"); got != "src/a.txt" {
+		t.Errorf("pasted file holds %q", got)
+	}
+}
+
+func TestPasteOverwrite(t *testing.T) {
+	vol := newTestVolume(t, testVolName, []string{"src/a.txt", "src/d/b.txt", "dst/a.txt", "dst/d"})
+	c := NewConnector(WithVolume(vol, VolumeOption{CopyOverwrite: true}))
+	dst := testTarget(c, vol, "/dst")
+	res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/src/a.txt")}, "dst": {dst}})
+	if names := addedNames(t, res); res.Warning != nil || fmt.Sprint(names) != "[a.txt]" || len(res.Removed) != 1 {
+		t.Errorf("overwrite: %v %+v", names, res)
+	}
+	if got := readTestFile(t, vol.Root(), "dst/a.txt"); got != "src/a.txt" {
+		t.Errorf("overwritten file holds %q", got)
+	}
+	res = getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/src/d")}, "dst": {dst}})
+	checkPasteWarning(t, "folder over a file", res, "errNotReplace")
+}
+
+func TestPasteIntoItself(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"d/sub/a.txt"})
+	for _, cut := range []string{"0", "1"} {
+		for _, dst := range []string{"/d", "/d/sub"} {
+			res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/d")},
+				"dst": {testTarget(c, vol, dst)}, "cut": {cut}})
+			checkPasteWarning(t, fmt.Sprintf("paste cut=%s into %s", cut, dst), res, "errCopyInItself")
+		}
+	}
+	res := getTest(t, c, url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "")},
+		"dst": {testTarget(c, vol, "/d")}})
+	checkPasteWarning(t, "paste the root", res, "errCopy")
+}
+
+func TestPasteCut(t *testing.T) {
+	other := newTestVolume(t, "other", nil)
+	c, vol := newTestConnector(t, []string{"a.txt", "d/b.txt", "dst/"}, WithVolumes(other))
+	q := url.Values{"cmd": {"paste"}, "targets[]": {testTarget(c, vol, "/a.txt")}, "dst": {testTarget(c, vol, "/dst")}}
+	q.Set("cut", "1")
+	if res := getTest(t, c, q); res.Warning != nil || len(res.Added) != 1 ||
+		len(res.Removed) != 1 || res.Removed[0] != testTarget(c, vol, "/a.txt") {
+		t.Errorf("move within the volume: %+v", res)
+	}
+	q.Set("targets[]", testTarget(c, vol, "/d"))
+	q.Set("dst", testTarget(c, other, ""))
+	if res := getTest(t, c, q); res.Warning != nil || len(res.Added) != 1 || len(res.Removed) != 1 {
+		t.Errorf("move to another volume: %+v", res)
+	}
+	for _, name := range []string{"a.txt", "d"} {
+		if _, err := os.Stat(filepath.Join(vol.Root(), name)); err == nil {
+			t.Errorf("%s left at its source", name)
+		}
+	}
+	if got := readTestFile(t, other.Root(), "d/b.txt"); got != "d/b.txt" {
+		t.Errorf("moved file holds %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package connection
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/LeeEirc/elfinder/codecs"
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+type RenameRequest struct {
+	Target string `elfinder:"target"`
+	Name   string `elfinder:"name"`
+}
+
+type RenameResponse struct {
+	Added   []model.FileInfo `json:"added"`
+	Removed []string         `json:"removed"`
+}
+
+func RenameCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
+	var (
+		param RenameRequest
+		res   RenameResponse
+	)
+	if err := codecs.UnmarshalElfinderTag(&param, req.Form); err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
+		return
+	}
+	id, vol, oldPath, err := connector.resolveTarget(req.Context(), param.Target)
+	if err != nil {
+		connector.Logger.Errorf("resolve target %s errs: %s", param.Target, err)
+		connector.sendError(rw, errs.ERRFileNotFound, err)
+		return
+	}
+	oldInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, oldPath)
+	if err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRFileNotFound, err)
+		return
+	}
+	if oldInfo.Isroot == 1 {
+		connector.sendError(rw, errs.ERRPerm, errors.New(oldInfo.Name))
+		return
+	}
+	if errRes := connector.movable(req.Context(), id, vol, oldPath, oldInfo); errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
+		return
+	}
+	if !connector.isAllowedName(param.Name) {
+		connector.sendError(rw, errs.ERRInvName, errors.New(param.Name))
+		return
+	}
+	if param.Name == oldInfo.Name {
+		res.Added = append(res.Added, oldInfo)
+		res.Removed = []string{}
+		if err := SendJson(rw, &res); err != nil {
+			connector.Logger.Error(err)
+		}
+		return
+	}
+	newPath := path.Join(path.Dir(oldPath), param.Name)
+	newRelativePath := relativeVolPath(vol, newPath)
+	if _, err = fs.Stat(vol, newRelativePath); err == nil {
+		connector.sendError(rw, errs.ERRExists, errors.New(param.Name))
+		return
+	}
+	if err = vol.Rename(relativeVolPath(vol, oldPath), newRelativePath); err != nil {
+		connector.Logger.Errorf("rename %s to %s errs: %s", oldPath, newPath, err)
+		connector.sendError(rw, errs.ERRRename, errors.New(oldInfo.Name), err)
+		return
+	}
+	newInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, newPath)
+	if err != nil {
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRRename, errors.New(oldInfo.Name), err)
+		return
+	}
+	res.Added = append(res.Added, newInfo)
+	res.Removed = append(res.Removed, oldInfo.PathHash)
+	if err := SendJson(rw, &res); err != nil {
+		connector.Logger.Error(err)
+	}
+}
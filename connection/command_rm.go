@@ -1,11 +1,10 @@
 package connection
 
 import (
-	"fmt"
+	"errors"
 	"github.com/LeeEirc/elfinder/codecs"
 	"github.com/LeeEirc/elfinder/errs"
 	"net/http"
-	"strings"
 )
 
 type RmRequest struct {
@@ -32,7 +31,7 @@ func RmCommand(connector *Connector, req *http.Request, rw http.ResponseWriter)
 	}
 	for i := range cmdReq.Targets {
 		target := cmdReq.Targets[i]
-		id, path, err := connector.ParseTarget(target)
+		id, vol, path, err := connector.resolveTarget(req.Context(), target)
 		if err != nil {
 			connector.Logger.Error(err)
 			if jsonErr := SendJson(rw, NewErr(errs.ERRCmdReq, err)); jsonErr != nil {
@@ -40,21 +39,28 @@ func RmCommand(connector *Connector, req *http.Request, rw http.ResponseWriter)
 			}
 			return
 		}
-		vol, ok := connector.Vols[id]
-		if !ok {
-			connector.Logger.Errorf("not found vol by id: %s", id)
-			if err = SendJson(rw, NewErr(errs.ERROpen, ErrNoFoundVol)); err != nil {
-				connector.Logger.Errorf("send response json errs: %s", err)
-			}
-			return
-		}
-		cwdInfo, err := StatFsVolFileByPath(id, vol, path)
+		cwdInfo, err := connector.StatFsVolFileByPath(req.Context(), id, vol, path)
 		if err != nil {
 			connector.Logger.Error(err)
+			connector.sendError(rw, errs.ERRFileNotFound, err)
+			return
+		}
+		if cwdInfo.Isroot == 1 {
+			connector.sendError(rw, errs.ERRPerm, errors.New(cwdInfo.Name))
 			return
 		}
-		relativePath := strings.TrimPrefix(path, fmt.Sprintf("/%s/", vol.Name()))
-		if err := vol.Remove(relativePath); err != nil {
+		if errRes := connector.movable(req.Context(), id, vol, path, cwdInfo); errRes != nil {
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		if cwdInfo.MimeType == "directory" {
+			if lockedName, ok := connector.lockedChild(req.Context(), id, vol, path); ok {
+				connector.sendError(rw, errs.ERRLocked, errors.New(lockedName))
+				return
+			}
+		}
+		relativePath := relativeVolPath(vol, path)
+		if err := removeFsVolAll(vol, relativePath); err != nil {
 			connector.Logger.Error(err)
 			if jsonErr := SendJson(rw, NewErr(errs.ERRRm, err)); jsonErr != nil {
 				connector.Logger.Error(jsonErr)
@@ -1,8 +1,6 @@
 package connection
 
 import (
-	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/LeeEirc/elfinder/codecs"
@@ -18,27 +16,29 @@ func TreeCommand(connector *Connector, req *http.Request, rw http.ResponseWriter
 	var param TreeRequest
 
 	if err := codecs.UnmarshalElfinderTag(&param, req.URL.Query()); err != nil {
-		log.Print(err)
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdReq, err)
 		return
 	}
-	id, path, err := connector.ParseTarget(param.Target)
+	id, vol, path, err := connector.resolveTarget(req.Context(), param.Target)
 	if err != nil {
-		log.Print(err)
-		if jsonErr := SendJson(rw, NewErr(errs.ERRCmdParams, err)); jsonErr != nil {
-			log.Print(jsonErr)
-		}
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRCmdParams, err)
+		return
+	}
+	if _, errRes := connector.readableDir(req.Context(), id, vol, path); errRes != nil {
+		connector.sendErrResponse(rw, *errRes)
 		return
 	}
-	fmt.Println(id, path)
-	vol := connector.Vols[id]
 	var res ParentsResponse
-	cwdInfo, err := ReadFsVolDir(id, vol, path)
+	cwdInfo, err := connector.ReadFsVolDir(req.Context(), id, vol, path)
 	if err != nil {
-		log.Panicln(err)
+		connector.Logger.Error(err)
+		connector.sendError(rw, errs.ERRFolderNotFound, err)
 		return
 	}
 	res.Tree = append(res.Tree, cwdInfo...)
 	if err := SendJson(rw, &res); err != nil {
-		log.Print(err)
+		connector.Logger.Error(err)
 	}
 }
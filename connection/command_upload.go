@@ -1,12 +1,14 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LeeEirc/elfinder/codecs"
 	"github.com/LeeEirc/elfinder/errs"
 	"github.com/LeeEirc/elfinder/model"
 	"github.com/LeeEirc/elfinder/volumes"
-	"io"
+	"io/fs"
+	"mime/multipart"
 	"net/http"
 	"strings"
 )
@@ -28,14 +30,20 @@ type UploadRequest struct {
 	Chunk string `elfinder:"chunk"`
 	Cid   string `elfinder:"cid"`
 	Range string `elfinder:"range"`
+
+	// ChunkStatus queries the ranges of cid already received, Checksum is
+	// an optional hex SHA-256 of the whole file verified before merging.
+	ChunkStatus bool   `elfinder:"chunkstatus"`
+	Checksum    string `elfinder:"checksum"`
 }
 
 type UploadResponse struct {
 	Adds     []model.FileInfo `json:"added"`
-	Warnings []ErrResponse    `json:"warning"`
+	Warnings []ErrResponse    `json:"warning,omitempty"`
 
-	ChunkMerged string `json:"_chunkmerged"`
-	ChunkName   string `json:"_name"`
+	ChunkMerged string       `json:"_chunkmerged,omitempty"`
+	ChunkName   string       `json:"_name,omitempty"`
+	ChunkStatus *ChunkStatus `json:"_chunkstatus,omitempty"`
 }
 
 func UploadCommand(connector *Connector, req *http.Request, rw http.ResponseWriter) {
@@ -43,7 +51,7 @@ func UploadCommand(connector *Connector, req *http.Request, rw http.ResponseWrit
 		lsReq UploadRequest
 		res   UploadResponse
 	)
-	if err := codecs.UnmarshalElfinderTag(&lsReq, req.MultipartForm.Value); err != nil {
+	if err := codecs.UnmarshalElfinderTag(&lsReq, req.Form); err != nil {
 		connector.Logger.Error(err)
 		return
 	}
@@ -53,12 +61,12 @@ func UploadCommand(connector *Connector, req *http.Request, rw http.ResponseWrit
 		err  error
 		vol  volumes.FsVolume
 	)
-	vol = connector.DefaultVol
-	id = connector.GetVolId(connector.DefaultVol)
-	path = fmt.Sprintf("/%s", vol.Name())
+	if id, vol = connector.defaultVolume(req.Context()); vol != nil {
+		path = fmt.Sprintf("/%s", vol.Name())
+	}
 
 	if lsReq.Target != "" {
-		id, path, err = connector.ParseTarget(lsReq.Target)
+		id, vol, path, err = connector.resolveTarget(req.Context(), lsReq.Target)
 		if err != nil {
 			connector.Logger.Errorf("parse target %s errRet: %s", lsReq.Target, err)
 			if jsonErr := SendJson(rw, NewErr(errs.ERRCmdParams, err)); jsonErr != nil {
@@ -66,7 +74,6 @@ func UploadCommand(connector *Connector, req *http.Request, rw http.ResponseWrit
 			}
 			return
 		}
-		vol = connector.GetFsById(id)
 	}
 	if vol == nil {
 		connector.Logger.Errorf("not found vol by id: %s", id)
@@ -76,38 +83,231 @@ func UploadCommand(connector *Connector, req *http.Request, rw http.ResponseWrit
 		return
 	}
 
-	uploadFiles := req.MultipartForm.File["upload[]"]
+	overwrite := connector.volOption(req.Context(), id).UploadOverwrite
+	if _, ok := req.Form["overwrite"]; ok {
+		overwrite = lsReq.Overwrite
+	}
+	suffix := lsReq.Suffix
+	if suffix == "" {
+		suffix = defaultRenameSuffix
+	}
+
+	var uploadFiles []*multipart.FileHeader
+	if req.MultipartForm != nil {
+		uploadFiles = req.MultipartForm.File["upload[]"]
+	}
 	var errRet []ErrResponse
-	if lsReq.Chunk == "" {
+	if lsReq.ChunkStatus {
+		name, _, _, err := parseChunkName(lsReq.Chunk)
+		if err != nil {
+			connector.sendError(rw, errs.ERRCmdParams, err)
+			return
+		}
+		status, err := connector.chunks.Status(chunkOwner(req.Context()), lsReq.Cid, name)
+		if err != nil {
+			connector.Logger.Errorf("chunk status %s errRet: %s", lsReq.Chunk, err)
+			connector.sendError(rw, errs.ERRUploadTransfer, errors.New(name), err)
+			return
+		}
+		res.Adds = []model.FileInfo{}
+		res.ChunkStatus = &status
+	} else if lsReq.Chunk == "" {
+		if _, errRes := connector.writableDir(req.Context(), id, vol, path); errRes != nil {
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		policy := connector.uploadPolicyOf(req.Context(), id)
+		var totalSize int64
 		for i := range uploadFiles {
 			cwdFile := uploadFiles[i]
+			if policy.MaxFiles > 0 && i >= policy.MaxFiles {
+				errRet = append(errRet, NewErr(errs.ERRUploadFile, errors.New(cwdFile.Filename), ErrTooManyFiles))
+				continue
+			}
+			if !connector.isAllowedName(cwdFile.Filename) {
+				errRet = append(errRet, NewErr(errs.ERRInvName, errors.New(cwdFile.Filename)))
+				continue
+			}
+			if errRes := policy.checkFileSize(cwdFile.Filename, cwdFile.Size); errRes != nil {
+				errRet = append(errRet, *errRes)
+				continue
+			}
+			totalSize += cwdFile.Size
+			if policy.RequestMaxSize > 0 && totalSize > policy.RequestMaxSize {
+				errRet = append(errRet, NewErr(errs.ERRUploadTotalSize, errors.New(cwdFile.Filename)))
+				continue
+			}
 			cwdFd, err := cwdFile.Open()
 			if err != nil {
 				errRet = append(errRet, NewErr(errs.ERRUpload, err))
 				continue
 			}
-			currentPath := strings.Join([]string{path, cwdFile.Filename}, model.Separator)
-			relativePath := strings.TrimPrefix(currentPath, fmt.Sprintf("/%s/", vol.Name()))
-			if writer, err2 := vol.Create(relativePath); err2 == nil {
-				_, err3 := io.Copy(writer, cwdFd)
-				if err3 != nil {
-					connector.Logger.Errorf("upload file %s errRet:", cwdFile.Filename, err3)
-				} else {
-					if info, err := StatFsVolFileByPath(id, vol, currentPath); err == nil {
-						res.Adds = append(res.Adds, info)
-					}
-				}
-				_ = writer.Close()
+			if errRes := policy.checkMime(cwdFile.Filename, cwdFd); errRes != nil {
+				errRet = append(errRet, *errRes)
+				_ = cwdFd.Close()
+				continue
+			}
+			currentPath, err := uploadPath(vol, path, cwdFile.Filename, suffix, overwrite)
+			if err != nil {
+				errRet = append(errRet, NewErr(errs.ERRUploadFile, errors.New(cwdFile.Filename), err))
+				_ = cwdFd.Close()
+				continue
 			}
+			if errRes := connector.replaceable(req.Context(), id, vol, currentPath); errRes != nil {
+				errRet = append(errRet, *errRes)
+				_ = cwdFd.Close()
+				continue
+			}
+			err = writeFsVolFile(vol, relativeVolPath(vol, currentPath), cwdFd)
 			_ = cwdFd.Close()
+			if err != nil {
+				connector.Logger.Errorf("upload file %s errRet: %s", cwdFile.Filename, err)
+				errRet = append(errRet, NewErr(errs.ERRUploadFile, errors.New(cwdFile.Filename), err))
+				continue
+			}
+			if info, err := connector.StatFsVolFileByPath(req.Context(), id, vol, currentPath); err == nil {
+				res.Adds = append(res.Adds, info)
+			}
 		}
 		if len(errRet) > 0 {
 			res.Warnings = errRet
 		}
+	} else if lsReq.Cid != "" {
+		// one chunk "name.N_M.part" of a file split by the client
+		name, _, count, err := parseChunkName(lsReq.Chunk)
+		var offset, length, total int64
+		if err == nil {
+			offset, length, total, err = parseChunkRange(lsReq.Range)
+		}
+		if err == nil && len(uploadFiles) == 0 {
+			err = ErrInvalidChunk
+		}
+		if err != nil {
+			connector.Logger.Errorf("upload chunk %s errRet: %s", lsReq.Chunk, err)
+			connector.sendError(rw, errs.ERRUploadFile, err)
+			return
+		}
+		if connector.uploadPolicyOf(req.Context(), id).MaxConn < 0 {
+			connector.sendError(rw, errs.ERRUploadFile, errors.New(name), ErrChunkDisabled)
+			return
+		}
+		if errRes := connector.uploadPolicyOf(req.Context(), id).checkChunk(name, count, length, total); errRes != nil {
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		cwdFd, err := uploadFiles[0].Open()
+		if err != nil {
+			connector.sendError(rw, errs.ERRUploadTransfer, errors.New(name), err)
+			return
+		}
+		if offset == 0 {
+			if errRes := connector.uploadPolicyOf(req.Context(), id).checkMime(name, cwdFd); errRes != nil {
+				_ = cwdFd.Close()
+				connector.sendErrResponse(rw, *errRes)
+				return
+			}
+		}
+		merged, err := connector.chunks.Write(chunkOwner(req.Context()), lsReq.Cid, name, offset, length, total, lsReq.Checksum, cwdFd)
+		_ = cwdFd.Close()
+		if err != nil {
+			connector.Logger.Errorf("save chunk %s errRet: %s", lsReq.Chunk, err)
+			connector.sendError(rw, errs.ERRUploadTransfer, errors.New(name), err)
+			return
+		}
+		res.Adds = []model.FileInfo{}
+		if merged != "" {
+			res.ChunkMerged = merged
+			res.ChunkName = name
+		}
 	} else {
-
+		// merge request: chunk is the former _chunkmerged and upload[] the _name
+		var name string
+		if len(lsReq.Uploads) > 0 {
+			name = lsReq.Uploads[0]
+		}
+		if !connector.isAllowedName(name) {
+			connector.sendError(rw, errs.ERRInvName, errors.New(name))
+			return
+		}
+		if len(lsReq.UploadPaths) > 0 && lsReq.UploadPaths[0] != "" && lsReq.UploadPaths[0] != lsReq.Target {
+			if id, vol, path, err = connector.resolveTarget(req.Context(), lsReq.UploadPaths[0]); err != nil {
+				connector.sendError(rw, errs.ERRTrgFolderNotFound, err)
+				return
+			}
+		}
+		if _, errRes := connector.writableDir(req.Context(), id, vol, path); errRes != nil {
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		currentPath, err := uploadPath(vol, path, name, suffix, overwrite)
+		if err != nil {
+			connector.sendError(rw, errs.ERRUploadFile, errors.New(name), err)
+			return
+		}
+		if errRes := connector.replaceable(req.Context(), id, vol, currentPath); errRes != nil {
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		mergedFd, err := connector.chunks.Open(chunkOwner(req.Context()), lsReq.Chunk, name, lsReq.Checksum)
+		if err != nil {
+			connector.Logger.Errorf("open merged chunk %s errRet: %s", lsReq.Chunk, err)
+			connector.sendError(rw, errs.ERRUploadTransfer, errors.New(name), err)
+			return
+		}
+		if errRes := connector.uploadPolicyOf(req.Context(), id).checkMime(name, mergedFd); errRes != nil {
+			_ = mergedFd.Close()
+			_ = connector.chunks.Remove(lsReq.Chunk)
+			connector.sendErrResponse(rw, *errRes)
+			return
+		}
+		// write next to the destination under a temporary name and only
+		// rename it into place once the whole content has been stored
+		tmpPath := strings.Join([]string{path, "." + lsReq.Chunk + ".upload"}, model.Separator)
+		err = writeFsVolFile(vol, relativeVolPath(vol, tmpPath), mergedFd)
+		_ = mergedFd.Close()
+		if err == nil {
+			err = vol.Rename(relativeVolPath(vol, tmpPath), relativeVolPath(vol, currentPath))
+		}
+		if err != nil {
+			_ = vol.Remove(relativeVolPath(vol, tmpPath))
+		} else {
+			_ = connector.chunks.Remove(lsReq.Chunk)
+		}
+		if err != nil {
+			connector.Logger.Errorf("upload file %s errRet: %s", name, err)
+			connector.sendError(rw, errs.ERRUploadTransfer, errors.New(name), err)
+			return
+		}
+		info, err := connector.StatFsVolFileByPath(req.Context(), id, vol, currentPath)
+		if err != nil {
+			connector.sendError(rw, errs.ERRUpload, errors.New(name), err)
+			return
+		}
+		res.Adds = append(res.Adds, info)
 	}
 	if err := SendJson(rw, res); err != nil {
 		connector.Logger.Errorf("send response json errRet: %s", err)
 	}
 }
+
+// uploadPath returns the path a file named name is stored under in the folder
+// dirPath, a new name is picked if the file exists and must not be
+// overwritten.
+func uploadPath(vol volumes.FsVolume, dirPath, name, suffix string, overwrite bool) (string, error) {
+	currentPath := strings.Join([]string{dirPath, name}, model.Separator)
+	if overwrite {
+		return currentPath, nil
+	}
+	_, err := fs.Stat(vol, relativeVolPath(vol, currentPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return currentPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	newName, err := uniqueFsVolName(vol, relativeVolPath(vol, dirPath), name, suffix)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{dirPath, newName}, model.Separator), nil
+}
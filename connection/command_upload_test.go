@@ -0,0 +1,112 @@
+package connection
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+func userHeader(user string) http.Header {
+	return http.Header{"X-Remote-User": {user}}
+}
+
+func TestChunkSessionsAreScoped(t *testing.T) {
+	c, vol := newTestConnector(t, nil,
+		WithAuthenticator(HeaderAuthenticator("X-Remote-User")),
+		WithUploadTempDir(t.TempDir()))
+	root := testTarget(c, vol, "")
+	chunk := func(user, name string) testResponse {
+		return uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}, "cid": {"123"},
+			"chunk": {name + ".0_0.part"}, "range": {"0,5,5"}}, "blob", []byte("hello"), userHeader(user))
+	}
+	merge := func(user, merged, name string) testResponse {
+		return uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}, "chunk": {merged},
+			"upload[]": {name}}, "", nil, userHeader(user))
+	}
+
+	alice := chunk("alice", "a.txt")
+	if alice.Error != nil || alice.ChunkMerged == "" {
+		t.Fatalf("chunk of alice: %+v", alice)
+	}
+	bob := chunk("bob", "a.txt")
+	if bob.Error != nil || bob.ChunkMerged == alice.ChunkMerged {
+		t.Fatalf("chunk of bob shares the session of alice: %+v", bob)
+	}
+	if res := merge("bob", alice.ChunkMerged, "a.txt"); res.errType() != "errUploadTransfer" {
+		t.Errorf("bob merged the upload of alice: %+v", res)
+	}
+	if res := merge("alice", alice.ChunkMerged, "b.txt"); res.errType() != "errUploadTransfer" {
+		t.Errorf("merge under another name: %+v", res)
+	}
+	if res := merge("alice", alice.ChunkMerged, "a.txt"); res.Error != nil || len(res.Added) != 1 {
+		t.Errorf("merge of alice: %+v", res)
+	}
+	if data, err := os.ReadFile(filepath.Join(vol.Root(), "a.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("merged file: %q %v", data, err)
+	}
+}
+
+func TestChunkTotalIsBounded(t *testing.T) {
+	c, vol := newTestConnector(t, nil,
+		WithUploadPolicy(UploadPolicy{RequestMaxSize: 1 << 10, MaxFileSize: 1 << 20}),
+		WithUploadTempDir(t.TempDir()))
+	root := testTarget(c, vol, "")
+	chunk := func(part, rangeData string) testResponse {
+		return uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}, "cid": {"1"},
+			"chunk": {"big.bin." + part + ".part"}, "range": {rangeData}}, "blob", []byte("x"), nil)
+	}
+	if res := chunk("0_1", "2047,1,2048"); res.Error != nil {
+		t.Errorf("chunk within its count: %+v", res)
+	}
+	if res := chunk("0_1", "4095,1,4096"); res.errType() != "errUploadTotalSize" {
+		t.Errorf("total beyond the chunk count: %+v", res)
+	}
+	if res := chunk("0_9999", "0,1,10000000"); res.errType() != "errUploadFileSize" {
+		t.Errorf("total beyond MaxFileSize: %+v", res)
+	}
+}
+
+func TestUploadBodyIsLimited(t *testing.T) {
+	c, vol := newTestConnector(t, nil, WithUploadPolicy(UploadPolicy{RequestMaxSize: 1 << 10}))
+	root := testTarget(c, vol, "")
+	content := make([]byte, 1<<10+uploadRequestOverhead)
+	res := uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}}, "big.bin", content, nil)
+	if res.errType() != "errUploadFileSize" {
+		t.Errorf("body over the limit: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(vol.Root(), "big.bin")); err == nil {
+		t.Error("file of a body over the limit stored")
+	}
+	res = uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}}, "small.bin", content[:1<<10], nil)
+	if res.Error != nil || len(res.Added) != 1 {
+		t.Errorf("body within the limit: %+v", res)
+	}
+}
+
+func TestUploadPathOfDisabledVolume(t *testing.T) {
+	other, err := volumes.NewLocal(t.TempDir(), volumes.WithLocalName("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, vol := newTestConnector(t, nil,
+		WithVolume(other, VolumeOption{Disabled: []string{cmdUpload}}),
+		WithUploadTempDir(t.TempDir()))
+	root := testTarget(c, vol, "")
+	res := uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}, "cid": {"1"},
+		"chunk": {"a.txt.0_0.part"}, "range": {"0,5,5"}}, "blob", []byte("hello"), nil)
+	if res.Error != nil || res.ChunkMerged == "" {
+		t.Fatalf("chunk: %+v", res)
+	}
+	res = uploadTest(t, c, url.Values{"cmd": {"upload"}, "target": {root}, "chunk": {res.ChunkMerged},
+		"upload[]": {"a.txt"}, "upload_path[]": {testTarget(c, other, "")}}, "", nil, nil)
+	if res.errType() != "errCmdNoSupport" {
+		t.Errorf("merge into a volume with upload disabled: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(other.Root(), "a.txt")); err == nil {
+		t.Error("file stored on a volume with upload disabled")
+	}
+}
@@ -1,12 +1,14 @@
 package connection
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -20,13 +22,20 @@ import (
 const defaultMaxMemory = 32 << 20
 
 const (
-	cmdOpen    = "open"
-	cmdInfo    = "info"
-	cmdParents = "parents"
-	cmdTree    = "tree"
-	cmdLs      = "ls"
-	cmdUpload  = "upload"
-	cmdRm      = "rm"
+	cmdOpen      = "open"
+	cmdInfo      = "info"
+	cmdParents   = "parents"
+	cmdTree      = "tree"
+	cmdLs        = "ls"
+	cmdUpload    = "upload"
+	cmdRm        = "rm"
+	cmdFile      = "file"
+	cmdMkdir     = "mkdir"
+	cmdMkfile    = "mkfile"
+	cmdRename    = "rename"
+	cmdPaste     = "paste"
+	cmdDuplicate = "duplicate"
+	cmdNetmount  = "netmount"
 )
 
 var (
@@ -44,12 +53,19 @@ var (
 	}
 
 	supportedCommands = map[string]CommandHandler{
-		cmdOpen:    OpenCommand,
-		cmdParents: ParentsCommand,
-		cmdTree:    TreeCommand,
-		cmdLs:      LsCommand,
-		cmdUpload:  UploadCommand,
-		cmdRm:      RmCommand,
+		cmdOpen:      OpenCommand,
+		cmdParents:   ParentsCommand,
+		cmdTree:      TreeCommand,
+		cmdLs:        LsCommand,
+		cmdUpload:    UploadCommand,
+		cmdRm:        RmCommand,
+		cmdFile:      FileCommand,
+		cmdMkdir:     MkdirCommand,
+		cmdMkfile:    MkfileCommand,
+		cmdRename:    RenameCommand,
+		cmdPaste:     PasteCommand,
+		cmdDuplicate: DuplicateCommand,
+		cmdNetmount:  NetmountCommand,
 	}
 )
 
@@ -79,6 +95,10 @@ var (
 	errNoFoundCmd  = errors.New("no found cmd")
 	ErrNoFoundVol  = errors.New("no found volume")
 	ErrValidTarget = errors.New("no valid target")
+	ErrOutsideVol  = errors.New("path outside of volume")
+
+	ErrTooManyFiles  = errors.New("too many files")
+	ErrChunkDisabled = errors.New("chunked upload disabled")
 )
 
 func parseCommand(req *http.Request) (string, error) {
@@ -104,10 +124,10 @@ func SendJson(w http.ResponseWriter, data interface{}) error {
 	return json.NewEncoder(w).Encode(data)
 }
 
-func StatFsVolFileByPath(id string, vol volumes.FsVolume, path string) (model.FileInfo, error) {
-	pathHash := EncodeTarget(id, path)
+func (c *Connector) StatFsVolFileByPath(ctx context.Context, id string, vol volumes.FsVolume, path string) (model.FileInfo, error) {
+	pathHash := c.EncodeTarget(id, path)
 	parentPath := filepath.Dir(path)
-	parentPathHash := EncodeTarget(id, parentPath)
+	parentPathHash := c.EncodeTarget(id, parentPath)
 	isRoot := 0
 	volRootPath := fmt.Sprintf("/%s", vol.Name())
 	if path == volRootPath {
@@ -141,17 +161,54 @@ func StatFsVolFileByPath(id string, vol volumes.FsVolume, path string) (model.Fi
 		Volumeid = id + "_"
 	}
 
-	var locked int
+	var base Access
 	r, w := utils.ParseFileMode(info.Mode())
-	if w == 0 {
+	if r == 1 {
+		base |= AccessRead
+	}
+	if w == 1 {
+		base |= AccessWrite
+	} else {
+		base |= AccessLocked
+	}
+	access := c.access(ctx, vol.Name(), relativePath, base)
+	if isReadOnlyVol(vol) {
+		access = access&^AccessWrite | AccessLocked
+	}
+	if access&AccessHidden != 0 {
+		return model.FileInfo{}, &fs.PathError{Op: "stat", Path: path, Err: errHidden}
+	}
+	r, w = 0, 0
+	var locked int
+	if access&AccessRead != 0 {
+		r = 1
+	}
+	if access&AccessWrite != 0 {
+		w = 1
+	}
+	if access&AccessLocked != 0 {
 		locked = 1
 	}
+	var ts int64
+	if !info.ModTime().IsZero() {
+		ts = info.ModTime().Unix()
+	}
+	var etag string
+	if etagger, ok := info.(volumes.ETagger); ok {
+		etag = etagger.ETag()
+	}
+	var netKey string
+	if isRoot == 1 {
+		if _, ok := c.netMount(ctx, id); ok {
+			netKey = id
+		}
+	}
 	return model.FileInfo{
 		Name:       name,
 		PathHash:   pathHash,
 		ParentHash: parentPathHash,
 		MimeType:   MimeType,
-		Timestamp:  info.ModTime().Unix(),
+		Timestamp:  ts,
 		Size:       info.Size(),
 		HasDirs:    HasDirs,
 		ReadAble:   r,
@@ -159,10 +216,60 @@ func StatFsVolFileByPath(id string, vol volumes.FsVolume, path string) (model.Fi
 		Locked:     locked,
 		Volumeid:   Volumeid,
 		Isroot:     isRoot,
+		ETag:       etag,
+		NetKey:     netKey,
 	}, nil
 }
 
-func ReadFsVolDir(id string, vol volumes.FsVolume, path string) ([]model.FileInfo, error) {
+// relativeVolPath converts a connector path "/<volume name>/a/b" to the
+// relative form "a/b" expected by the FsVolume methods.
+func relativeVolPath(vol volumes.FsVolume, path string) string {
+	volRootPath := fmt.Sprintf("/%s", vol.Name())
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(path, volRootPath), model.Separator)
+	if relativePath == "" {
+		relativePath = "."
+	}
+	return relativePath
+}
+
+// confineVolPath cleans the decoded connector path vPath and rejects it unless
+// it stays under the root of vol. Volumes implementing volumes.Confiner are
+// asked as well, so symlinks cannot lead out of the volume.
+func confineVolPath(vol volumes.FsVolume, vPath string) (string, error) {
+	volRootPath := fmt.Sprintf("/%s", vol.Name())
+	if !strings.HasPrefix(vPath, model.Separator) || strings.ContainsAny(vPath, "\\\x00") {
+		return "", fmt.Errorf("%w: %q", ErrOutsideVol, vPath)
+	}
+	cleanPath := path.Clean(vPath)
+	if cleanPath != volRootPath && !strings.HasPrefix(cleanPath, volRootPath+model.Separator) {
+		return "", fmt.Errorf("%w: %q", ErrOutsideVol, vPath)
+	}
+	relativePath := relativeVolPath(vol, cleanPath)
+	if !fs.ValidPath(relativePath) {
+		return "", fmt.Errorf("%w: %q", ErrOutsideVol, vPath)
+	}
+	if confiner, ok := vol.(volumes.Confiner); ok {
+		if err := confiner.Confine(relativePath); err != nil {
+			// e.g. an unreachable remote volume that could not be asked
+			var typed volumes.ErrTyper
+			if errors.As(err, &typed) {
+				return "", err
+			}
+			return "", fmt.Errorf("%w: %s", ErrOutsideVol, err)
+		}
+	}
+	return cleanPath, nil
+}
+
+// isValidName reports whether name can be used as a single path element.
+func isValidName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00")
+}
+
+func (c *Connector) ReadFsVolDir(ctx context.Context, id string, vol volumes.FsVolume, path string) ([]model.FileInfo, error) {
 	volRootPath := fmt.Sprintf("/%s", vol.Name())
 	dirPath := strings.TrimPrefix(strings.TrimPrefix(path, volRootPath), "/")
 	if dirPath == "" {
@@ -176,7 +283,10 @@ func ReadFsVolDir(id string, vol volumes.FsVolume, path string) ([]model.FileInf
 
 	for i := range files {
 		subPath := strings.Join([]string{path, files[i].Name()}, model.Separator)
-		info, err2 := StatFsVolFileByPath(id, vol, subPath)
+		info, err2 := c.StatFsVolFileByPath(ctx, id, vol, subPath)
+		if errors.Is(err2, errHidden) {
+			continue
+		}
 		if err2 != nil {
 			return nil, err2
 		}
@@ -197,10 +307,17 @@ type ErrResponse struct {
 	Errs []error
 }
 
+// MarshalJSON writes the error type followed by the messages of Errs. Errors
+// of a volume backend carrying their own type, see volumes.ErrTyper, add it
+// in front of their message.
 func (e ErrResponse) MarshalJSON() ([]byte, error) {
 	errs := make([]string, 0, len(e.Errs)+1)
 	errs = append(errs, string(e.Type))
 	for i := range e.Errs {
+		var typed volumes.ErrTyper
+		if errors.As(e.Errs[i], &typed) && typed.ErrType() != e.Type {
+			errs = append(errs, string(typed.ErrType()))
+		}
 		errs = append(errs, e.Errs[i].Error())
 	}
 	data := map[string]interface{}{
@@ -0,0 +1,119 @@
+package connection
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+var confineSeeds = []string{
+	"/files",
+	"/files/a/b.txt",
+	"/files/..",
+	"/files/../etc/passwd",
+	"/files/a/../../etc",
+	"/files/./a/./../../files2",
+	"/files2/a",
+	"/filesx",
+	"//files/a",
+	"/files/a%2f..%2f..%2fetc",
+	"/files/a\\..\\..\\etc",
+	"/files/a\x00/../../etc",
+	"files/a",
+	"/etc/passwd",
+	"/files/out",
+	"/files/out/secret.txt",
+	"/files/a/up/secret.txt",
+	"",
+}
+
+// newConfineVolume returns a volume "files" whose symlinks "out" and "a/up"
+// lead out of its root to a folder holding secret.txt.
+func newConfineVolume(t testing.TB) (*Connector, *volumes.LocalVolume, string) {
+	c, vol := newTestConnector(t, []string{"a/b.txt"})
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"out", "a/up"} {
+		if err := os.Symlink(outside, filepath.Join(vol.Root(), filepath.FromSlash(link))); err != nil {
+			t.Skip(err)
+		}
+	}
+	return c, vol, outside
+}
+
+// checkConfined fails unless vPath is a clean path of vol whose real path
+// stays below the root of vol.
+func checkConfined(t *testing.T, vol *volumes.LocalVolume, outside, vPath string) {
+	t.Helper()
+	if vPath != "/"+testVolName && !strings.HasPrefix(vPath, "/"+testVolName+"/") {
+		t.Fatalf("confined path %q outside of the volume", vPath)
+	}
+	if strings.Contains(vPath, "/../") || strings.HasSuffix(vPath, "/..") || strings.ContainsAny(vPath, "\\\x00") {
+		t.Fatalf("confined path %q is not clean", vPath)
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(vol.Root(), filepath.FromSlash(relativeVolPath(vol, vPath))))
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(realPath, outside) {
+		t.Fatalf("confined path %q resolves to %s", vPath, realPath)
+	}
+}
+
+func FuzzConfineVolPath(f *testing.F) {
+	for _, seed := range confineSeeds {
+		f.Add(seed)
+	}
+	_, vol, outside := newConfineVolume(f)
+	f.Fuzz(func(t *testing.T, vPath string) {
+		confined, err := confineVolPath(vol, vPath)
+		if err != nil {
+			return
+		}
+		checkConfined(t, vol, outside, confined)
+	})
+}
+
+func FuzzResolveTarget(f *testing.F) {
+	c, vol, outside := newConfineVolume(f)
+	vid := c.GetVolId(vol)
+	for _, seed := range confineSeeds {
+		f.Add(c.EncodeTarget(vid, seed))
+	}
+	f.Add(vid)
+	f.Add(vid + "_")
+	f.Add(vid + "_L2ZpbGVzLy4u")
+	f.Add("x" + vid + "_L2ZpbGVz")
+	f.Add("_L2ZpbGVz")
+	f.Fuzz(func(t *testing.T, target string) {
+		id, resolved, vPath, err := c.resolveTarget(context.Background(), target)
+		if err != nil {
+			return
+		}
+		if id != vid || resolved != volumes.FsVolume(vol) {
+			t.Fatalf("target %q resolved to volume %s", target, id)
+		}
+		checkConfined(t, vol, outside, vPath)
+	})
+}
+
+func TestConfineVolPathRejectsEscapes(t *testing.T) {
+	_, vol, _ := newConfineVolume(t)
+	for _, vPath := range []string{"/files/..", "/files/../etc/passwd", "/files2/a", "/filesx",
+		"/files/a\\..\\..\\etc", "/files/a\x00", "files/a", "/files/out/secret.txt", "/files/a/up"} {
+		if confined, err := confineVolPath(vol, vPath); err == nil {
+			t.Errorf("%q confined to %q", vPath, confined)
+		}
+	}
+	for vPath, want := range map[string]string{"/files": "/files", "/files/a/../a/b.txt": "/files/a/b.txt", "//files/a": "/files/a"} {
+		if confined, err := confineVolPath(vol, vPath); err != nil || confined != want {
+			t.Errorf("%q confined to %q, %v", vPath, confined, err)
+		}
+	}
+}
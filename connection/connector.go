@@ -1,60 +1,117 @@
 package connection
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/LeeEirc/elfinder/errs"
 	"github.com/LeeEirc/elfinder/log"
-	"github.com/LeeEirc/elfinder/utils"
 	"github.com/LeeEirc/elfinder/volumes"
 )
 
+// NewConnector logs and skips the volumes that cannot be mounted, use
+// NewConnectorE to fail on them instead.
 func NewConnector(opts ...Options) *Connector {
+	c, opt := newConnector(opts)
+	for i := range opt.Vols {
+		if _, err := c.mountLocked(opt.Vols[i].Volume, opt.Vols[i].Option); err != nil {
+			c.Logger.Errorf("mount volume errs: %s", err)
+		}
+	}
+	return c
+}
+
+// NewConnectorE returns an error when a volume cannot be mounted, e.g. when
+// two volumes get the same id or an id is invalid, see VolumeOption.ID.
+func NewConnectorE(opts ...Options) (*Connector, error) {
+	c, opt := newConnector(opts)
+	for i := range opt.Vols {
+		if _, err := c.mountLocked(opt.Vols[i].Volume, opt.Vols[i].Option); err != nil {
+			return nil, fmt.Errorf("mount volume %d: %w", i, err)
+		}
+	}
+	return c, nil
+}
+
+func newConnector(opts []Options) (*Connector, option) {
 	opt := option{
-		Logger: &log.GlobalLogger,
+		Logger:       &log.GlobalLogger,
+		UploadPolicy: defaultUploadPolicy(),
+		TargetCodec:  base64TargetCodec{},
 	}
 	for _, setter := range opts {
 		setter(&opt)
 	}
 
-	volsMap := make(map[string]volumes.FsVolume, len(opt.Vols))
-	for i := range opt.Vols {
-		vid := utils.MD5ID(opt.Vols[i].Name())
-		volsMap[vid] = opt.Vols[i]
-	}
-	var defaultVol volumes.FsVolume
-	if len(opt.Vols) > 0 {
-		defaultVol = opt.Vols[0]
-	}
 	return &Connector{
-		DefaultVol: defaultVol,
-		Vols:       volsMap,
-		Created:    time.Now(),
-		Logger:     opt.Logger,
-	}
+		Vols:    make(map[string]volumes.FsVolume, len(opt.Vols)),
+		volIds:  make(map[interface{}]string, len(opt.Vols)),
+		Created: time.Now(),
+		Logger:  opt.Logger,
+
+		forbiddenNames: opt.ForbiddenNames,
+		chunks:         newChunkStore(opt.UploadTempDir),
+		uploadPolicy:   opt.UploadPolicy,
+		targetCodec:    opt.TargetCodec,
+		authenticator:  opt.Authenticator,
+		accessControl:  opt.AccessControl,
+		volOptions:     make(map[string]VolumeOption, len(opt.Vols)),
+		netDrivers:     opt.NetDrivers,
+		netSessions:    make(map[string]*netSession),
+		volumeResolver: opt.VolumeResolver,
+	}, opt
 }
 
+// Connector serves the elFinder protocol. DefaultVol and Vols are maintained
+// by Mount and Unmount under mux, read them through List and GetFsById while
+// serving.
 type Connector struct {
 	DefaultVol volumes.FsVolume
 	Vols       map[string]volumes.FsVolume
 	Created    time.Time
 	Logger     log.Logger
 	mux        sync.Mutex
+	volOrder   []string
+	volIds     map[interface{}]string
+
+	forbiddenNames []*regexp.Regexp
+	chunks         *chunkStore
+	uploadPolicy   UploadPolicy
+	targetCodec    TargetCodec
+	authenticator  Authenticator
+	accessControl  *AccessControl
+	volOptions     map[string]VolumeOption
+	netDrivers     map[string]NetDriver
+	netSessions    map[string]*netSession
+	volumeResolver VolumeResolver
+	listeners      map[int]func(VolumeEvent)
+	nextListener   int
 }
 
+// GetVolId returns the id of the mounted volume v, or "" when v is not
+// mounted. Volumes of a VolumeResolver are not mounted, volumes of struct
+// types that are not comparable are not found.
 func (c *Connector) GetVolId(v volumes.FsVolume) string {
-	for id, vol := range c.Vols {
-		if vol.Name() == v.Name() {
-			return id
-		}
+	key, ok := volumeIdentity(v)
+	if !ok {
+		return ""
 	}
-	return ""
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.volIds[key]
 }
-func (c *Connector) GetFsById(id string) volumes.FsVolume {
-	return c.Vols[id]
+
+// GetFsById returns the volume id visible to the request of ctx, including
+// the network volumes of its session.
+func (c *Connector) GetFsById(ctx context.Context, id string) volumes.FsVolume {
+	if mounted, ok := c.mountedVolume(ctx, id); ok {
+		return mounted.Volume
+	}
+	return nil
 }
 
 func (c *Connector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -66,8 +123,29 @@ func (c *Connector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	r, err := c.authenticate(r)
+	if err != nil {
+		c.Logger.Errorf("authenticate %s errs: %s", r.RemoteAddr, err)
+		c.sendError(w, authErrType(err), err)
+		return
+	}
+	if r, err = c.resolveVolumes(r); err != nil {
+		c.Logger.Errorf("resolve volumes of %s errs: %s", r.RemoteAddr, err)
+		c.sendError(w, errs.ERRAccess, err)
+		return
+	}
+	r = c.withNetSession(r)
+	var body *limitedBody
+	if limit := c.requestBodyLimit(r.Context()); limit > 0 && r.Method == http.MethodPost {
+		body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+		r.Body = body
+	}
 	if err := formParseFunc(r); err != nil {
 		c.Logger.Errorf("HTTP form parse errs: %s", err)
+		if body != nil && body.exceeded() {
+			c.sendError(w, errs.ERRUploadFileSize, err)
+			return
+		}
 		if err := SendJson(w, NewErr(errs.ERRCmdParams, err)); err != nil {
 			c.Logger.Error(err)
 		}
@@ -90,23 +168,95 @@ func (c *Connector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		c.Logger.Debugf("user %s command %s", identity.User, cmd)
+	}
+	if volName, ok := c.disabledVolume(cmd, r); ok {
+		c.Logger.Errorf("Command `%s` disabled on volume %s", cmd, volName)
+		c.sendError(w, errs.ERRCmdNoSupport, fmt.Errorf("%s: %s", volName, cmd))
+		return
+	}
 	handleFunc(c, r, w)
 }
 
 func (c *Connector) ParseTarget(target string) (vid, vPath string, err error) {
-	return DecodeTarget(target)
+	return c.targetCodec.Decode(target)
+}
+
+func (c *Connector) EncodeTarget(vid, vPath string) string {
+	return c.targetCodec.Encode(vid, vPath)
+}
+
+// resolveTarget decodes target and confines its path to the volume, every
+// command must resolve client supplied hashes through it.
+func (c *Connector) resolveTarget(ctx context.Context, target string) (vid string, vol volumes.FsVolume, vPath string, err error) {
+	vid, vPath, err = c.ParseTarget(target)
+	if err != nil {
+		return "", nil, "", err
+	}
+	vol = c.GetFsById(ctx, vid)
+	if vol == nil {
+		return "", nil, "", fmt.Errorf("%w: %s", ErrNoFoundVol, vid)
+	}
+	if vPath, err = confineVolPath(vol, vPath); err != nil {
+		return "", nil, "", err
+	}
+	return vid, vol, vPath, nil
+}
+
+// isAllowedName reports whether name is a valid single path element that
+// matches none of the forbidden name patterns.
+func (c *Connector) isAllowedName(name string) bool {
+	if !isValidName(name) {
+		return false
+	}
+	for i := range c.forbiddenNames {
+		if c.forbiddenNames[i].MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Connector) sendError(rw http.ResponseWriter, errType errs.ErrType, errs ...error) {
+	c.sendErrResponse(rw, NewErr(errType, errs...))
+}
+
+func (c *Connector) sendErrResponse(rw http.ResponseWriter, errRes ErrResponse) {
+	if err := SendJson(rw, errRes); err != nil {
+		c.Logger.Errorf("send response json errs: %s", err)
+	}
 }
 
 type Options func(*option)
 
 type option struct {
-	Vols   []volumes.FsVolume
-	Logger log.Logger
+	Vols           []MountedVolume
+	Logger         log.Logger
+	ForbiddenNames []*regexp.Regexp
+	UploadTempDir  string
+	UploadPolicy   UploadPolicy
+	TargetCodec    TargetCodec
+	Authenticator  Authenticator
+	AccessControl  *AccessControl
+	NetDrivers     map[string]NetDriver
+	VolumeResolver VolumeResolver
 }
 
+// WithVolumes adds volumes using DefaultVolumeOption, the first volume added
+// is the default one.
 func WithVolumes(vols ...volumes.FsVolume) Options {
 	return func(o *option) {
-		o.Vols = vols
+		for i := range vols {
+			o.Vols = append(o.Vols, MountedVolume{Volume: vols[i], Option: DefaultVolumeOption()})
+		}
+	}
+}
+
+// WithVolume adds a volume with its own options.
+func WithVolume(vol volumes.FsVolume, volOpt VolumeOption) Options {
+	return func(o *option) {
+		o.Vols = append(o.Vols, MountedVolume{Volume: vol, Option: volOpt})
 	}
 }
 
@@ -115,3 +265,50 @@ func WithLogger(logger log.Logger) Options {
 		o.Logger = logger
 	}
 }
+
+// WithForbiddenNames rejects new names matching any of the patterns in
+// mkdir, mkfile and rename, e.g. regexp.MustCompile(`^\.`) for dot files.
+func WithForbiddenNames(patterns ...*regexp.Regexp) Options {
+	return func(o *option) {
+		o.ForbiddenNames = append(o.ForbiddenNames, patterns...)
+	}
+}
+
+// WithUploadTempDir sets the directory where chunked uploads are staged until
+// all chunks arrived. It defaults to a directory under os.TempDir.
+func WithUploadTempDir(dir string) Options {
+	return func(o *option) {
+		o.UploadTempDir = dir
+	}
+}
+
+// WithUploadPolicy sets the size, count and MIME type limits of uploads.
+func WithUploadPolicy(policy UploadPolicy) Options {
+	return func(o *option) {
+		o.UploadPolicy = policy
+	}
+}
+
+// WithTargetCodec replaces the default base64 target hashes, e.g. with
+// NewSealedTargetCodec to hide paths from the client.
+func WithTargetCodec(codec TargetCodec) Options {
+	return func(o *option) {
+		o.TargetCodec = codec
+	}
+}
+
+// WithAuthenticator rejects requests without a valid identity, handlers find
+// the identity with IdentityFromContext(req.Context()).
+func WithAuthenticator(auth Authenticator) Options {
+	return func(o *option) {
+		o.Authenticator = auth
+	}
+}
+
+// WithAccessControl applies access rules on top of the permissions read from
+// the volumes, see NewAccessControl.
+func WithAccessControl(ac *AccessControl) Options {
+	return func(o *option) {
+		o.AccessControl = ac
+	}
+}
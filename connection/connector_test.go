@@ -0,0 +1,144 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+const testVolName = "files"
+
+// newTestConnector serves a local volume named "files" on a temporary
+// directory holding the given files, folders end with "/".
+func newTestConnector(t testing.TB, files []string, opts ...Options) (*Connector, *volumes.LocalVolume) {
+	t.Helper()
+	vol := newTestVolume(t, testVolName, files)
+	return NewConnector(append([]Options{WithVolumes(vol)}, opts...)...), vol
+}
+
+// newTestVolume returns a local volume on a temporary directory holding the
+// given files, folders end with "/".
+func newTestVolume(t testing.TB, volName string, files []string) *volumes.LocalVolume {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		var err error
+		if name[len(name)-1] == '/' {
+			err = os.MkdirAll(p, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+			err = os.WriteFile(p, []byte(name), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	vol, err := volumes.NewLocal(root, volumes.WithLocalName(volName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vol
+}
+
+// testTarget returns the hash of p, a path from the root of the volume.
+func testTarget(c *Connector, vol volumes.FsVolume, p string) string {
+	return c.EncodeTarget(c.GetVolId(vol), "/"+vol.Name()+p)
+}
+
+type testResponse struct {
+	Error   []string          `json:"error"`
+	Added   []json.RawMessage `json:"added"`
+	Removed []string          `json:"removed"`
+	Warning []json.RawMessage `json:"warning"`
+	Files   []testFile        `json:"files"`
+	Hashes  map[string]string `json:"hashes"`
+
+	ChunkMerged string `json:"_chunkmerged"`
+	ChunkName   string `json:"_name"`
+}
+
+type testFile struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Read   int    `json:"read"`
+	Write  int    `json:"write"`
+	Locked int    `json:"locked"`
+}
+
+func (r testResponse) errType() string {
+	if len(r.Error) == 0 {
+		return ""
+	}
+	return r.Error[0]
+}
+
+func serveTest(t *testing.T, c *Connector, req *http.Request) testResponse {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+	var res testResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode %s: %s", rw.Body.String(), err)
+	}
+	return res
+}
+
+func getTest(t *testing.T, c *Connector, q url.Values) testResponse {
+	t.Helper()
+	return serveTest(t, c, httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil))
+}
+
+func TestRenameNeedsWritableParent(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"ro/a.txt", "rw/b.txt"})
+	roDir := filepath.Join(vol.Root(), "ro")
+	if err := os.Chmod(roDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(roDir, 0755) })
+
+	res := getTest(t, c, url.Values{"cmd": {"rename"}, "target": {testTarget(c, vol, "/ro/a.txt")}, "name": {"c.txt"}})
+	if res.errType() != "errPerm" {
+		t.Errorf("rename in read-only folder: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(roDir, "a.txt")); err != nil {
+		t.Error(err)
+	}
+	res = getTest(t, c, url.Values{"cmd": {"rename"}, "target": {testTarget(c, vol, "/rw/b.txt")}, "name": {"c.txt"}})
+	if res.Error != nil || len(res.Added) != 1 || len(res.Removed) != 1 {
+		t.Errorf("rename in writable folder: %+v", res)
+	}
+}
+
+// uploadTest posts fields with content as the single upload[] file.
+func uploadTest(t *testing.T, c *Connector, fields url.Values, fileName string, content []byte, header http.Header) testResponse {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k := range fields {
+		for _, v := range fields[k] {
+			_ = w.WriteField(k, v)
+		}
+	}
+	if fileName != "" {
+		fw, err := w.CreateFormFile("upload[]", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = fw.Write(content)
+	}
+	_ = w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	for k := range header {
+		req.Header[k] = header[k]
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return serveTest(t, c, req)
+}
@@ -0,0 +1,286 @@
+package connection
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+const (
+	netMountCookie = "elfinder_netmount"
+	// maxNetMounts is the number of volumes one session can mount.
+	maxNetMounts = 8
+	// netSessionIdle is how long the mounts of a session without requests
+	// are kept.
+	netSessionIdle = 12 * time.Hour
+)
+
+var (
+	ErrNetMountLimit   = errors.New("too many network volumes")
+	ErrHostNotAllowed  = errors.New("host not allowed")
+	ErrNoNetSession    = errors.New("no network mount session")
+	errInvalidHostPort = errors.New("invalid host or port")
+)
+
+// NetMountParams are the connection parameters a client sends with the
+// netmount command.
+type NetMountParams struct {
+	Protocol string
+	Host     string
+	// Port is empty for the default port of the protocol.
+	Port string
+	Path string
+	User string
+	Pass string
+}
+
+// NetDriver opens the volume of a netmount request. The volume is only
+// registered when the driver returns without error, drivers should check the
+// credentials before returning.
+type NetDriver func(ctx context.Context, params NetMountParams) (volumes.FsVolume, error)
+
+// WithNetDriver enables the netmount command for protocol, volumes mounted
+// by a client are only visible to its session.
+func WithNetDriver(protocol string, driver NetDriver) Options {
+	return func(o *option) {
+		if o.NetDrivers == nil {
+			o.NetDrivers = make(map[string]NetDriver)
+		}
+		o.NetDrivers[protocol] = driver
+	}
+}
+
+// FTPNetDriver mounts FTP volumes, and FTPS volumes for the protocol "ftps".
+// The server connects to the host the client names, only hosts allowHost
+// accepts are mounted and a nil allowHost rejects every host.
+func FTPNetDriver(allowHost func(host string) bool) NetDriver {
+	return func(ctx context.Context, params NetMountParams) (volumes.FsVolume, error) {
+		if strings.ContainsAny(params.Host, "/@[]") {
+			return nil, fmt.Errorf("%w: %s", errInvalidHostPort, params.Host)
+		}
+		if allowHost == nil || !allowHost(params.Host) {
+			return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, params.Host)
+		}
+		addr := params.Host
+		name := params.Host
+		if params.Port != "" {
+			if port, err := strconv.Atoi(params.Port); err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("%w: %s", errInvalidHostPort, params.Port)
+			}
+			addr = net.JoinHostPort(params.Host, params.Port)
+			name = fmt.Sprintf("%s:%s", params.Host, params.Port)
+		}
+		if params.User != "" {
+			name = fmt.Sprintf("%s@%s", params.User, name)
+		}
+		cfg := volumes.FTPConfig{
+			Addr:     addr,
+			User:     params.User,
+			Password: params.Pass,
+			Root:     params.Path,
+			Name:     name,
+		}
+		if params.Protocol == "ftps" {
+			cfg.TLS = &tls.Config{ServerName: params.Host}
+		}
+		vol, err := volumes.NewFTP(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fs.Stat(vol, "."); err != nil {
+			_ = vol.Close()
+			return nil, err
+		}
+		return vol, nil
+	}
+}
+
+// netSession holds the volumes mounted by one client session.
+type netSession struct {
+	mounts   map[string]volumes.FsVolume
+	lastUsed time.Time
+}
+
+type netSessionCtxKey struct{}
+
+// withNetSession stores the session key of req in its context when network
+// mounts are enabled. The key is the netmount cookie, scoped by the user when
+// the request is authenticated.
+func (c *Connector) withNetSession(req *http.Request) *http.Request {
+	if len(c.netDrivers) == 0 {
+		return req
+	}
+	cookie, err := req.Cookie(netMountCookie)
+	if err != nil || cookie.Value == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), netSessionCtxKey{}, netSessionKey(req.Context(), cookie.Value)))
+}
+
+func netSessionKey(ctx context.Context, cookie string) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.User + "\x00" + cookie
+	}
+	return cookie
+}
+
+// ensureNetSession returns the session key of req, a new session cookie is
+// set on rw when the client has none yet.
+func (c *Connector) ensureNetSession(req *http.Request, rw http.ResponseWriter) (string, error) {
+	if key, ok := req.Context().Value(netSessionCtxKey{}).(string); ok {
+		return key, nil
+	}
+	value, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     netMountCookie,
+		Value:    value,
+		Path:     req.URL.Path,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return netSessionKey(req.Context(), value), nil
+}
+
+// netMount returns the volume vid mounted by the session of ctx.
+func (c *Connector) netMount(ctx context.Context, vid string) (volumes.FsVolume, bool) {
+	key, ok := ctx.Value(netSessionCtxKey{}).(string)
+	if !ok {
+		return nil, false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	session, ok := c.netSessions[key]
+	if !ok {
+		return nil, false
+	}
+	session.lastUsed = time.Now()
+	vol, ok := session.mounts[vid]
+	return vol, ok
+}
+
+// netMountIds returns the ids of the volumes mounted by the session of ctx.
+func (c *Connector) netMountIds(ctx context.Context) []string {
+	key, ok := ctx.Value(netSessionCtxKey{}).(string)
+	if !ok {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	session, ok := c.netSessions[key]
+	if !ok {
+		return nil
+	}
+	ids := make([]string, 0, len(session.mounts))
+	for vid := range session.mounts {
+		ids = append(ids, vid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// addNetMount registers vol for the session key and returns its volume id.
+// Sessions idle for longer than netSessionIdle are unmounted on the way.
+func (c *Connector) addNetMount(key string, vol volumes.FsVolume) (string, error) {
+	suffix, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	vid := "n" + suffix
+	now := time.Now()
+	var expired []*netSession
+	c.mux.Lock()
+	for k, session := range c.netSessions {
+		if k != key && now.Sub(session.lastUsed) > netSessionIdle {
+			expired = append(expired, session)
+			delete(c.netSessions, k)
+		}
+	}
+	session, ok := c.netSessions[key]
+	if !ok {
+		session = &netSession{mounts: make(map[string]volumes.FsVolume)}
+		c.netSessions[key] = session
+	}
+	session.lastUsed = now
+	if len(session.mounts) >= maxNetMounts {
+		c.mux.Unlock()
+		closeNetSessions(expired...)
+		return "", ErrNetMountLimit
+	}
+	session.mounts[vid] = vol
+	c.mux.Unlock()
+	closeNetSessions(expired...)
+	return vid, nil
+}
+
+// removeNetMount unregisters the volume vid of the session key and closes it.
+func (c *Connector) removeNetMount(key, vid string) (volumes.FsVolume, bool) {
+	c.mux.Lock()
+	session, ok := c.netSessions[key]
+	if !ok {
+		c.mux.Unlock()
+		return nil, false
+	}
+	vol, ok := session.mounts[vid]
+	if ok {
+		delete(session.mounts, vid)
+		if len(session.mounts) == 0 {
+			delete(c.netSessions, key)
+		}
+	}
+	c.mux.Unlock()
+	if !ok {
+		return nil, false
+	}
+	closeVolume(vol)
+	return vol, true
+}
+
+func closeNetSessions(sessions ...*netSession) {
+	for i := range sessions {
+		for _, vol := range sessions[i].mounts {
+			closeVolume(vol)
+		}
+	}
+}
+
+// closeVolume releases the connections of volumes implementing io.Closer.
+func closeVolume(vol volumes.FsVolume) {
+	if closer, ok := vol.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// netDriverNames returns the protocols accepted by the netmount command.
+func (c *Connector) netDriverNames() []string {
+	names := make([]string, 0, len(c.netDrivers))
+	for name := range c.netDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
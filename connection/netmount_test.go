@@ -0,0 +1,135 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+// sessionClient keeps the netmount cookie of one browser session.
+type sessionClient struct {
+	t      *testing.T
+	srv    *httptest.Server
+	client *http.Client
+}
+
+func newSessionClient(t *testing.T, srv *httptest.Server) *sessionClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sessionClient{t: t, srv: srv, client: &http.Client{Jar: jar}}
+}
+
+func (s *sessionClient) get(q url.Values) testResponse {
+	s.t.Helper()
+	resp, err := s.client.Get(s.srv.URL + "/?" + q.Encode())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res testResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		s.t.Fatal(err)
+	}
+	return res
+}
+
+func (s *sessionClient) hasFile(hash string) bool {
+	s.t.Helper()
+	for _, f := range s.get(url.Values{"cmd": {"open"}, "init": {"1"}, "tree": {"1"}}).Files {
+		if f.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFTPNetDriverNeedsAllowList(t *testing.T) {
+	s := volumestest.StartFTP(t, t.TempDir())
+	host, port, _ := net.SplitHostPort(s.Addr)
+	params := NetMountParams{Protocol: "ftp", Host: host, Port: port, User: s.User, Pass: s.Password}
+	for _, allowHost := range []func(string) bool{nil, func(string) bool { return false }} {
+		if _, err := FTPNetDriver(allowHost)(context.Background(), params); !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("mount of a host not allowed: %v", err)
+		}
+	}
+	if n := s.Logins(); n != 0 {
+		t.Errorf("%d logins to hosts not allowed", n)
+	}
+	vol, err := FTPNetDriver(func(h string) bool { return h == host })(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeVolume(vol)
+}
+
+func TestNetmountIsScopedToSession(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "remote.txt"), []byte("remote"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := volumestest.StartFTP(t, root)
+	host, port, _ := net.SplitHostPort(s.Addr)
+	c, _ := newTestConnector(t, nil, WithNetDriver("ftp", FTPNetDriver(func(h string) bool { return h == host })))
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	alice, bob := newSessionClient(t, srv), newSessionClient(t, srv)
+	mount := url.Values{"cmd": {"netmount"}, "protocol": {"ftp"}, "host": {host}, "port": {port},
+		"user": {s.User}, "pass": {s.Password}}
+
+	if res := bob.get(url.Values{"cmd": {"netmount"}, "protocol": {"ftp"}, "host": {"localhost"}, "port": {port},
+		"user": {s.User}, "pass": {s.Password}}); res.errType() != "errNetMountFailed" {
+		t.Errorf("mount of a host not allowed: %+v", res)
+	}
+	res := alice.get(mount)
+	var mounted testFile
+	if res.Error != nil || len(res.Added) != 1 || json.Unmarshal(res.Added[0], &mounted) != nil {
+		t.Fatalf("netmount: %+v", res)
+	}
+	vid, _, err := c.ParseTarget(mounted.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := url.Values{"cmd": {"open"}, "target": {mounted.Hash}}
+	if res = alice.get(open); res.Error != nil || len(res.Files) != 2 || res.Files[1].Name != "remote.txt" {
+		t.Errorf("open of the mount: %+v", res)
+	}
+	if !alice.hasFile(mounted.Hash) {
+		t.Error("mount missing from the volumes of its session")
+	}
+
+	if res = bob.get(open); res.Error == nil {
+		t.Errorf("open of the mount of another session: %+v", res)
+	}
+	if bob.hasFile(mounted.Hash) {
+		t.Error("mount listed to another session")
+	}
+	unmount := url.Values{"cmd": {"netmount"}, "protocol": {protocolNetUnmount}, "host": {vid}, "user": {mounted.Hash}}
+	if res = bob.get(unmount); res.errType() != "errNetUnMount" {
+		t.Errorf("unmount by another session: %+v", res)
+	}
+
+	if res = alice.get(unmount); res.Error != nil || len(res.Removed) != 1 || res.Removed[0] != mounted.Hash {
+		t.Fatalf("unmount: %+v", res)
+	}
+	if res = alice.get(open); res.Error == nil {
+		t.Errorf("open after unmount: %+v", res)
+	}
+	if alice.hasFile(mounted.Hash) {
+		t.Error("mount listed after unmount")
+	}
+	if res = alice.get(unmount); res.errType() != "errNetUnMount" {
+		t.Errorf("second unmount: %+v", res)
+	}
+}
@@ -0,0 +1,172 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+var ErrVolumeExists = errors.New("volume already mounted")
+
+type VolumeEventType int
+
+const (
+	VolumeMounted VolumeEventType = iota + 1
+	VolumeUnmounted
+)
+
+func (t VolumeEventType) String() string {
+	switch t {
+	case VolumeMounted:
+		return "mounted"
+	case VolumeUnmounted:
+		return "unmounted"
+	}
+	return fmt.Sprintf("VolumeEventType(%d)", int(t))
+}
+
+// VolumeEvent reports a change of the volumes of a connector.
+type VolumeEvent struct {
+	Type   VolumeEventType
+	ID     string
+	Volume volumes.FsVolume
+}
+
+// MountedVolume is a volume of a connector with its id and options.
+type MountedVolume struct {
+	ID     string
+	Volume volumes.FsVolume
+	Option VolumeOption
+}
+
+// Mount adds vol while the connector is serving and returns its id, the
+// options are completed like the ones of WithVolume. It fails with
+// ErrVolumeExists when the id or vol is mounted already. The first mounted
+// volume becomes the default one.
+func (c *Connector) Mount(vol volumes.FsVolume, volOpt VolumeOption) (string, error) {
+	c.mux.Lock()
+	vid, err := c.mountLocked(vol, volOpt)
+	listeners := c.volumeListeners()
+	c.mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+	notifyVolumeEvent(listeners, VolumeEvent{Type: VolumeMounted, ID: vid, Volume: vol})
+	return vid, nil
+}
+
+// Unmount removes the volume id. Requests already running on it finish, the
+// volume is not closed. When the default volume is removed the next one in
+// mount order takes its place.
+func (c *Connector) Unmount(id string) error {
+	c.mux.Lock()
+	vol, ok := c.Vols[id]
+	if !ok {
+		c.mux.Unlock()
+		return fmt.Errorf("%w: %s", ErrNoFoundVol, id)
+	}
+	delete(c.Vols, id)
+	if key, ok := volumeIdentity(vol); ok {
+		delete(c.volIds, key)
+	}
+	delete(c.volOptions, id)
+	for i := range c.volOrder {
+		if c.volOrder[i] == id {
+			c.volOrder = append(c.volOrder[:i:i], c.volOrder[i+1:]...)
+			break
+		}
+	}
+	c.DefaultVol = nil
+	if len(c.volOrder) > 0 {
+		c.DefaultVol = c.Vols[c.volOrder[0]]
+	}
+	listeners := c.volumeListeners()
+	c.mux.Unlock()
+	notifyVolumeEvent(listeners, VolumeEvent{Type: VolumeUnmounted, ID: id, Volume: vol})
+	return nil
+}
+
+// List returns the volumes in mount order.
+func (c *Connector) List() []MountedVolume {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	list := make([]MountedVolume, 0, len(c.volOrder))
+	for _, vid := range c.volOrder {
+		list = append(list, MountedVolume{ID: vid, Volume: c.Vols[vid], Option: c.volOptions[vid]})
+	}
+	return list
+}
+
+// OnVolumeChange calls fn after every Mount and Unmount until the returned
+// cancel function is called. fn runs on the goroutine of the change and must
+// not block, events of concurrent changes may arrive out of order.
+func (c *Connector) OnVolumeChange(fn func(VolumeEvent)) (cancel func()) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.listeners == nil {
+		c.listeners = make(map[int]func(VolumeEvent))
+	}
+	id := c.nextListener
+	c.nextListener++
+	c.listeners[id] = fn
+	return func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		delete(c.listeners, id)
+	}
+}
+
+// mountLocked registers vol under volOpt.ID, c.mux must be held.
+func (c *Connector) mountLocked(vol volumes.FsVolume, volOpt VolumeOption) (string, error) {
+	if vol == nil {
+		return "", ErrNilVolume
+	}
+	vid, err := volumeID(volOpt.ID, vol)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := c.Vols[vid]; ok {
+		return "", fmt.Errorf("%w: id %s", ErrVolumeExists, vid)
+	}
+	key, hasKey := volumeIdentity(vol)
+	if _, ok := c.volIds[key]; hasKey && ok {
+		return "", fmt.Errorf("%w: %s", ErrVolumeExists, vol.Name())
+	}
+	volOpt.ID = vid
+	c.Vols[vid] = vol
+	if hasKey {
+		c.volIds[key] = vid
+	}
+	c.volOptions[vid] = volOpt.forVolume(vol)
+	c.volOrder = append(c.volOrder, vid)
+	if c.DefaultVol == nil {
+		c.DefaultVol = vol
+	}
+	return vid, nil
+}
+
+// defaultVolume returns the first volume visible to the request of ctx and its
+// id, vol is nil when there is none.
+func (c *Connector) defaultVolume(ctx context.Context) (vid string, vol volumes.FsVolume) {
+	list := c.volumeList(ctx)
+	if len(list) == 0 {
+		return "", nil
+	}
+	return list[0].ID, list[0].Volume
+}
+
+func (c *Connector) volumeListeners() []func(VolumeEvent) {
+	listeners := make([]func(VolumeEvent), 0, len(c.listeners))
+	for _, fn := range c.listeners {
+		listeners = append(listeners, fn)
+	}
+	return listeners
+}
+
+func notifyVolumeEvent(listeners []func(VolumeEvent), event VolumeEvent) {
+	for i := range listeners {
+		listeners[i](event)
+	}
+}
@@ -0,0 +1,112 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+func TestMountAndUnmount(t *testing.T) {
+	c := NewConnector()
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	cancel := c.OnVolumeChange(func(event VolumeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf("%s %s", event.Type, event.ID))
+	})
+	a, b := volumes.NewMemory("a"), volumes.NewMemory("b")
+	for _, m := range []MountedVolume{{ID: "a1", Volume: a}, {ID: "b1", Volume: b}} {
+		if vid, err := c.Mount(m.Volume, VolumeOption{ID: m.ID}); err != nil || vid != m.ID {
+			t.Fatalf("mount %s: %q %v", m.ID, vid, err)
+		}
+	}
+	if _, err := c.Mount(a, VolumeOption{ID: "a2"}); !errors.Is(err, ErrVolumeExists) {
+		t.Errorf("mount a volume twice: %v", err)
+	}
+	if _, err := c.Mount(volumes.NewMemory("c"), VolumeOption{ID: "a1"}); !errors.Is(err, ErrVolumeExists) {
+		t.Errorf("mount under a taken id: %v", err)
+	}
+	if list := c.List(); len(list) != 2 || list[0].ID != "a1" || list[1].ID != "b1" {
+		t.Errorf("list %+v", list)
+	}
+	if vid, vol := c.defaultVolume(context.Background()); vid != "a1" || vol != a {
+		t.Errorf("default volume %s", vid)
+	}
+
+	if err := c.Unmount("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if vid, vol := c.defaultVolume(context.Background()); vid != "b1" || vol != b || c.DefaultVol != b {
+		t.Errorf("default volume %s after unmounting the first one", vid)
+	}
+	if c.GetVolId(a) != "" || c.GetFsById(context.Background(), "a1") != nil {
+		t.Error("unmounted volume still resolves")
+	}
+	if err := c.Unmount("a1"); !errors.Is(err, ErrNoFoundVol) {
+		t.Errorf("unmount twice: %v", err)
+	}
+	if _, err := c.Mount(a, VolumeOption{ID: "a1"}); err != nil {
+		t.Errorf("mount again after unmount: %v", err)
+	}
+
+	cancel()
+	if err := c.Unmount("a1"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"mounted a1", "mounted b1", "unmounted a1", "mounted a1"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+func TestMountWhileServing(t *testing.T) {
+	c, vol := newTestConnector(t, []string{"a.txt"})
+	query := url.Values{"cmd": {"open"}, "target": {testTarget(c, vol, "")}, "tree": {"1"}}.Encode()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// getTest must not fail the test off its goroutine
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+				var res testResponse
+				if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil || res.Error != nil {
+					t.Errorf("open while mounting: %s", rw.Body.String())
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		vid, err := c.Mount(volumes.NewMemory(fmt.Sprintf("m%d", i)), DefaultVolumeOption())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Unmount(vid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,212 @@
+package connection
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TargetCodec converts between a volume id plus connector path and the hash
+// sent to the client. Hashes keep the elFinder layout "<volume id>_<payload>",
+// the payload may only use URL safe base64 characters.
+type TargetCodec interface {
+	Encode(vid, path string) string
+	Decode(target string) (vid, path string, err error)
+}
+
+// TargetKey is a secret of a signed or sealed TargetCodec. The ID is written
+// into every hash so hashes made with an older key still decode after a
+// rotation.
+type TargetKey struct {
+	ID     string
+	Secret []byte
+}
+
+const (
+	minTargetSecretSize = 16
+	targetMacSize       = 16
+	targetNonceSize     = 12
+)
+
+var (
+	ErrTargetKey = errors.New("invalid target key")
+
+	targetKeyIDPattern = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+
+	targetMacEncodedSize = base64.RawURLEncoding.EncodedLen(targetMacSize)
+)
+
+type base64TargetCodec struct{}
+
+func (base64TargetCodec) Encode(vid, path string) string {
+	return EncodeTarget(vid, path)
+}
+
+func (base64TargetCodec) Decode(target string) (vid, path string, err error) {
+	return DecodeTarget(target)
+}
+
+type targetKeySet struct {
+	current string
+	keys    map[string][]byte
+}
+
+func newTargetKeySet(keys []TargetKey) (targetKeySet, error) {
+	if len(keys) == 0 {
+		return targetKeySet{}, fmt.Errorf("%w: no key", ErrTargetKey)
+	}
+	set := targetKeySet{current: keys[0].ID, keys: make(map[string][]byte, len(keys))}
+	for i := range keys {
+		if !targetKeyIDPattern.MatchString(keys[i].ID) {
+			return targetKeySet{}, fmt.Errorf("%w: id %q", ErrTargetKey, keys[i].ID)
+		}
+		if len(keys[i].Secret) < minTargetSecretSize {
+			return targetKeySet{}, fmt.Errorf("%w: secret of %s shorter than %d bytes",
+				ErrTargetKey, keys[i].ID, minTargetSecretSize)
+		}
+		if _, ok := set.keys[keys[i].ID]; ok {
+			return targetKeySet{}, fmt.Errorf("%w: duplicate id %s", ErrTargetKey, keys[i].ID)
+		}
+		set.keys[keys[i].ID] = keys[i].Secret
+	}
+	return set, nil
+}
+
+// split parses "<volume id>_<key id>-<data>" and checks the key is known.
+func (s targetKeySet) split(target string) (vid, keyID, data string, err error) {
+	ret := strings.SplitN(target, "_", 2)
+	if len(ret) != 2 {
+		return "", "", "", ErrValidTarget
+	}
+	payload := strings.SplitN(ret[1], "-", 2)
+	if len(payload) != 2 {
+		return "", "", "", ErrValidTarget
+	}
+	if _, ok := s.keys[payload[0]]; !ok {
+		return "", "", "", fmt.Errorf("%w: unknown key %s", ErrValidTarget, payload[0])
+	}
+	return ret[0], payload[0], payload[1], nil
+}
+
+func deriveTargetKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// targetMac binds path to its volume, the zero byte cannot occur in either.
+func targetMac(key []byte, vid, path string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(vid))
+	mac.Write([]byte{0})
+	mac.Write([]byte(path))
+	return mac.Sum(nil)
+}
+
+// NewSignedTargetCodec returns a codec appending an HMAC-SHA256 to the base64
+// path, so hashes cannot be forged but still reveal the path. The first key
+// signs new hashes, all keys are accepted when decoding.
+func NewSignedTargetCodec(keys ...TargetKey) (TargetCodec, error) {
+	set, err := newTargetKeySet(keys)
+	if err != nil {
+		return nil, err
+	}
+	return signedTargetCodec{keys: set}, nil
+}
+
+type signedTargetCodec struct {
+	keys targetKeySet
+}
+
+func (c signedTargetCodec) Encode(vid, path string) string {
+	secret := deriveTargetKey(c.keys.keys[c.keys.current], "elfinder target sign")
+	sum := targetMac(secret, vid, path)[:targetMacSize]
+	return strings.Join([]string{vid, c.keys.current + "-" +
+		base64.RawURLEncoding.EncodeToString(sum) + base64Encode(path)}, "_")
+}
+
+func (c signedTargetCodec) Decode(target string) (vid, path string, err error) {
+	vid, keyID, data, err := c.keys.split(target)
+	if err != nil {
+		return "", "", err
+	}
+	if len(data) < targetMacEncodedSize {
+		return "", "", ErrValidTarget
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(data[:targetMacEncodedSize])
+	if err != nil {
+		return "", "", ErrValidTarget
+	}
+	if path, err = base64Decode(data[targetMacEncodedSize:]); err != nil {
+		return "", "", ErrValidTarget
+	}
+	expected := targetMac(deriveTargetKey(c.keys.keys[keyID], "elfinder target sign"), vid, path)[:targetMacSize]
+	if !hmac.Equal(sum, expected) {
+		return "", "", fmt.Errorf("%w: bad signature", ErrValidTarget)
+	}
+	return vid, path, nil
+}
+
+// NewSealedTargetCodec returns a codec encrypting paths with AES-256-GCM, so
+// hashes are opaque and cannot be forged. The nonce is derived from the path,
+// the same file always gets the same hash as elFinder clients expect. The
+// first key seals new hashes, all keys are accepted when decoding.
+func NewSealedTargetCodec(keys ...TargetKey) (TargetCodec, error) {
+	set, err := newTargetKeySet(keys)
+	if err != nil {
+		return nil, err
+	}
+	c := sealedTargetCodec{
+		aeads:  make(map[string]cipher.AEAD, len(set.keys)),
+		nonces: make(map[string][]byte, len(set.keys)),
+		keys:   set,
+	}
+	for id, secret := range set.keys {
+		block, err := aes.NewCipher(deriveTargetKey(secret, "elfinder target seal"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads[id] = aead
+		c.nonces[id] = deriveTargetKey(secret, "elfinder target nonce")
+	}
+	return c, nil
+}
+
+type sealedTargetCodec struct {
+	aeads  map[string]cipher.AEAD
+	nonces map[string][]byte
+	keys   targetKeySet
+}
+
+func (c sealedTargetCodec) Encode(vid, path string) string {
+	nonce := targetMac(c.nonces[c.keys.current], vid, path)[:targetNonceSize]
+	sealed := c.aeads[c.keys.current].Seal(nonce, nonce, []byte(path), []byte(vid))
+	return strings.Join([]string{vid, c.keys.current + "-" +
+		base64.RawURLEncoding.EncodeToString(sealed)}, "_")
+}
+
+func (c sealedTargetCodec) Decode(target string) (vid, path string, err error) {
+	vid, keyID, data, err := c.keys.split(target)
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(sealed) < targetNonceSize {
+		return "", "", ErrValidTarget
+	}
+	plain, err := c.aeads[keyID].Open(nil, sealed[:targetNonceSize], sealed[targetNonceSize:], []byte(vid))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrValidTarget, err)
+	}
+	return vid, string(plain), nil
+}
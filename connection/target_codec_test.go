@@ -0,0 +1,109 @@
+package connection
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var targetShape = regexp.MustCompile(`^[a-zA-Z][0-9a-zA-Z]*_[0-9a-zA-Z_-]+$`)
+
+func testTargetKey(id string) TargetKey {
+	return TargetKey{ID: id, Secret: []byte(strings.Repeat(id, 32))[:32]}
+}
+
+func newTestCodecs(t *testing.T, keys ...TargetKey) map[string]TargetCodec {
+	t.Helper()
+	signed, err := NewSignedTargetCodec(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := NewSealedTargetCodec(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]TargetCodec{"signed": signed, "sealed": sealed}
+}
+
+func TestTargetCodecRoundTrip(t *testing.T) {
+	for name, codec := range newTestCodecs(t, testTargetKey("k1")) {
+		for _, p := range []string{"/files", "/files/a b/ü.txt", "/files/-_"} {
+			hash := codec.Encode("l1", p)
+			if !targetShape.MatchString(hash) || !strings.HasPrefix(hash, "l1_k1-") {
+				t.Errorf("%s: hash %q of %s", name, hash, p)
+			}
+			if hash != codec.Encode("l1", p) {
+				t.Errorf("%s: hash of %s changes", name, p)
+			}
+			vid, got, err := codec.Decode(hash)
+			if err != nil || vid != "l1" || got != p {
+				t.Errorf("%s: decode %q: %s %s %v", name, hash, vid, got, err)
+			}
+		}
+	}
+}
+
+func TestTargetCodecRejectsTampering(t *testing.T) {
+	for name, codec := range newTestCodecs(t, testTargetKey("k1")) {
+		hash := codec.Encode("l1", "/files/a.txt")
+		flipped := []byte(hash)
+		i := len("l1_k1-") + 2
+		if flipped[i] == 'A' {
+			flipped[i] = 'B'
+		} else {
+			flipped[i] = 'A'
+		}
+		for _, tampered := range []string{
+			string(flipped),
+			hash[:len(hash)-2],
+			"l2" + strings.TrimPrefix(hash, "l1"),
+			hash + "AA",
+			EncodeTarget("l1", "/files/a.txt"),
+		} {
+			if _, _, err := codec.Decode(tampered); !errors.Is(err, ErrValidTarget) {
+				t.Errorf("%s: decode %q: %v", name, tampered, err)
+			}
+		}
+	}
+	signed, _ := NewSignedTargetCodec(testTargetKey("k1"))
+	hash := signed.Encode("l1", "/files/a.txt")
+	forged := hash[:len("l1_k1-")+targetMacEncodedSize] + base64Encode("/files/b.txt")
+	if _, _, err := signed.Decode(forged); !errors.Is(err, ErrValidTarget) {
+		t.Errorf("signed: decode of a swapped path: %v", err)
+	}
+}
+
+func TestTargetCodecKeyRotation(t *testing.T) {
+	old := newTestCodecs(t, testTargetKey("k1"))
+	rotated := newTestCodecs(t, testTargetKey("k2"), testTargetKey("k1"))
+	dropped := newTestCodecs(t, testTargetKey("k2"))
+	for name, codec := range rotated {
+		oldHash := old[name].Encode("l1", "/files/a.txt")
+		if vid, p, err := codec.Decode(oldHash); err != nil || vid != "l1" || p != "/files/a.txt" {
+			t.Errorf("%s: old hash after rotation: %s %s %v", name, vid, p, err)
+		}
+		if hash := codec.Encode("l1", "/files/a.txt"); !strings.HasPrefix(hash, "l1_k2-") {
+			t.Errorf("%s: new hash %q not made with the current key", name, hash)
+		}
+		if _, _, err := dropped[name].Decode(oldHash); !errors.Is(err, ErrValidTarget) {
+			t.Errorf("%s: hash of a dropped key: %v", name, err)
+		}
+	}
+}
+
+func TestTargetKeyValidation(t *testing.T) {
+	for _, keys := range [][]TargetKey{
+		nil,
+		{{ID: "k-1", Secret: make([]byte, 32)}},
+		{{ID: "k1", Secret: make([]byte, 8)}},
+		{testTargetKey("k1"), testTargetKey("k1")},
+	} {
+		if _, err := NewSignedTargetCodec(keys...); !errors.Is(err, ErrTargetKey) {
+			t.Errorf("signed codec with keys %v: %v", keys, err)
+		}
+		if _, err := NewSealedTargetCodec(keys...); !errors.Is(err, ErrTargetKey) {
+			t.Errorf("sealed codec with keys %v: %v", keys, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// copyFsVolEntry copies the file or directory tree at srcPath of srcVol to
+// dstPath of dstVol. Both paths are relative volume paths. Entries below
+// srcPath for which skip returns true are left out, skip may be nil.
+func copyFsVolEntry(srcVol volumes.FsVolume, srcPath string, dstVol volumes.FsVolume, dstPath string, skip func(string) bool) error {
+	info, err := fs.Stat(srcVol, srcPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFsVolFile(srcVol, srcPath, dstVol, dstPath)
+	}
+	if err = dstVol.Mkdir(dstPath); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	entries, err := srcVol.ReadDir(srcPath)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		name := entries[i].Name()
+		if skip != nil && skip(path.Join(srcPath, name)) {
+			continue
+		}
+		if err = copyFsVolEntry(srcVol, path.Join(srcPath, name), dstVol, path.Join(dstPath, name), skip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFsVolFile(srcVol volumes.FsVolume, srcPath string, dstVol volumes.FsVolume, dstPath string) error {
+	reader, err := srcVol.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return writeFsVolFile(dstVol, dstPath, reader)
+}
+
+// writeFsVolFile creates or truncates the file at the relative path p and
+// fills it with the content of reader.
+func writeFsVolFile(vol volumes.FsVolume, p string, reader io.Reader) error {
+	writer, err := vol.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// removeFsVolAll removes path and any children it contains. It does not rely
+// on the volume's Remove being recursive.
+func removeFsVolAll(vol volumes.FsVolume, p string) error {
+	info, err := fs.Stat(vol, p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := vol.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if err = removeFsVolAll(vol, path.Join(p, entries[i].Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return vol.Remove(p)
+}
+
+// uniqueFsVolName returns a name based on name that does not exist in the
+// directory dirPath, e.g. "a.txt" becomes "a~1.txt" with suffix "~".
+func uniqueFsVolName(vol volumes.FsVolume, dirPath, name, suffix string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base, ext = name, ""
+	}
+	for i := 1; i < 10000; i++ {
+		newName := fmt.Sprintf("%s%s%d%s", base, suffix, i, ext)
+		_, err := fs.Stat(vol, path.Join(dirPath, newName))
+		if errors.Is(err, fs.ErrNotExist) {
+			return newName, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", fs.ErrExist, name)
+}
+
+// isSubPath reports whether target is the same as or nested under parent.
+func isSubPath(parent, target string) bool {
+	if parent == target {
+		return true
+	}
+	return strings.HasPrefix(target, strings.TrimSuffix(parent, "/")+"/")
+}
@@ -0,0 +1,193 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/model"
+)
+
+const (
+	defaultUploadRequestMaxSize = 32 << 20
+	// uploadRequestOverhead leaves room for the multipart headers and form
+	// fields next to the file content of an upload request.
+	uploadRequestOverhead = 1 << 20
+)
+
+// UploadPolicy limits what the upload command accepts. The limits are also
+// advertised to the client by the open command. Zero values mean unlimited.
+type UploadPolicy struct {
+	// MaxFileSize is the maximum size of one file, chunked or not.
+	MaxFileSize int64
+	// RequestMaxSize is the maximum size of one upload request. The client
+	// splits larger files into chunks of this size.
+	RequestMaxSize int64
+	// MaxFiles is the maximum number of files of one upload request.
+	MaxFiles int
+	// MaxConn is the number of parallel chunk connections, -1 disables
+	// chunked uploads.
+	MaxConn int
+	// Mime holds allow and deny lists of MIME types or top level types such
+	// as "image"; "all" matches every type.
+	Mime model.UploadMimeOption
+}
+
+func defaultUploadPolicy() UploadPolicy {
+	return UploadPolicy{RequestMaxSize: defaultUploadRequestMaxSize}
+}
+
+func (p UploadPolicy) advertise(opt *model.Option) {
+	opt.UploadMaxSize = int(p.MaxFileSize)
+	opt.UploadMaxConn = p.MaxConn
+	opt.UploadMime = p.Mime
+}
+
+// uplMaxSize formats RequestMaxSize the way the client expects, e.g. "32M".
+func (p UploadPolicy) uplMaxSize() string {
+	size := p.RequestMaxSize
+	if size <= 0 {
+		size = defaultUploadRequestMaxSize
+	}
+	switch {
+	case size%(1<<30) == 0:
+		return fmt.Sprintf("%dG", size>>30)
+	case size%(1<<20) == 0:
+		return fmt.Sprintf("%dM", size>>20)
+	case size%(1<<10) == 0:
+		return fmt.Sprintf("%dK", size>>10)
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+// requestBodyLimit returns the largest body a request of ctx may send, 0
+// when a volume has no limit. The target is only known once the body is
+// parsed, so the most permissive volume decides and the upload command
+// checks the limit of the target afterwards.
+func (c *Connector) requestBodyLimit(ctx context.Context) int64 {
+	limit := c.uploadPolicy.RequestMaxSize
+	if limit <= 0 {
+		return 0
+	}
+	for _, mounted := range c.volumeList(ctx) {
+		policy := mounted.Option.UploadPolicy
+		if policy == nil {
+			continue
+		}
+		if policy.RequestMaxSize <= 0 {
+			return 0
+		}
+		if policy.RequestMaxSize > limit {
+			limit = policy.RequestMaxSize
+		}
+	}
+	return limit + uploadRequestOverhead
+}
+
+// limitedBody counts what is read through http.MaxBytesReader to tell a body
+// over the limit from a malformed one, http.MaxBytesError needs Go 1.19.
+type limitedBody struct {
+	io.ReadCloser
+	read  int64
+	limit int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+func (b *limitedBody) exceeded() bool {
+	return b.read >= b.limit
+}
+
+func (p UploadPolicy) checkFileSize(name string, size int64) *ErrResponse {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		errRes := NewErr(errs.ERRUploadFileSize, errors.New(name))
+		return &errRes
+	}
+	return nil
+}
+
+// checkChunk bounds a chunk of a file the client split into count chunks of
+// at most the advertised request size, so the announced total cannot exceed
+// what the chunks carry.
+func (p UploadPolicy) checkChunk(name string, count int, length, total int64) *ErrResponse {
+	if errRes := p.checkFileSize(name, total); errRes != nil {
+		return errRes
+	}
+	chunkSize := p.RequestMaxSize
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadRequestMaxSize
+	}
+	if length > chunkSize || total > 0 && (total-1)/int64(count) >= chunkSize {
+		errRes := NewErr(errs.ERRUploadTotalSize, errors.New(name))
+		return &errRes
+	}
+	return nil
+}
+
+// checkMime validates both the type derived from the name extension and the
+// type sniffed from the content of reader, then rewinds reader.
+func (p UploadPolicy) checkMime(name string, reader io.ReadSeeker) *ErrResponse {
+	if len(p.Mime.Allow) == 0 && len(p.Mime.Deny) == 0 {
+		return nil
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		errRes := NewErr(errs.ERRUploadTransfer, errors.New(name), err)
+		return &errRes
+	}
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		errRes := NewErr(errs.ERRUploadTransfer, errors.New(name), err)
+		return &errRes
+	}
+	mimeTypes := []string{http.DetectContentType(head[:n])}
+	if extType := mime.TypeByExtension(filepath.Ext(name)); extType != "" {
+		mimeTypes = append(mimeTypes, extType)
+	}
+	for _, mimeType := range mimeTypes {
+		if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+			mimeType = mediaType
+		}
+		if !p.mimeAccepted(mimeType) {
+			errRes := NewErr(errs.ERRUploadMime, errors.New(name))
+			return &errRes
+		}
+	}
+	return nil
+}
+
+// mimeAccepted follows the elFinder PHP connector: with firstOrder "allow"
+// a type must match only the allow list, otherwise a type is accepted
+// unless it matches only the deny list.
+func (p UploadPolicy) mimeAccepted(mimeType string) bool {
+	allow := mimeMatch(mimeType, p.Mime.Allow)
+	deny := mimeMatch(mimeType, p.Mime.Deny)
+	if strings.EqualFold(p.Mime.FirstOrder, "allow") {
+		return allow && !deny
+	}
+	return !deny || allow
+}
+
+func mimeMatch(mimeType string, patterns []string) bool {
+	topType := mimeType
+	if i := strings.Index(mimeType, "/"); i >= 0 {
+		topType = mimeType[:i]
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == "all" || pattern == mimeType || pattern == topType {
+			return true
+		}
+	}
+	return false
+}
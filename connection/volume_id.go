@@ -0,0 +1,83 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/LeeEirc/elfinder/utils"
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+var (
+	ErrInvalidVolumeID = errors.New("invalid volume id")
+	ErrNilVolume       = errors.New("nil volume")
+)
+
+// volumeIDPattern is the form of volume ids, the client requires a letter
+// first and the ids end at the first "_" of a hash.
+var volumeIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{0,31}$`)
+
+// volumeID returns the explicit id when set, otherwise an id derived from the
+// name of vol that stays the same across restarts, e.g. "v3b9c8f2a".
+func volumeID(id string, vol volumes.FsVolume) (string, error) {
+	if id == "" {
+		return "v" + utils.MD5ID(vol.Name())[:8], nil
+	}
+	if !volumeIDPattern.MatchString(id) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidVolumeID, id)
+	}
+	return id, nil
+}
+
+// volumeKey identifies a volume of a type that cannot be a map key by its
+// pointer.
+type volumeKey struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// volumeIdentity returns the key of vol in the reverse lookup. Volumes that
+// can be hashed are their own key, maps, slices and funcs are keyed by
+// pointer. Other volumes, e.g. structs holding a slice directly or in an
+// interface field, have no identity and ok is false.
+func volumeIdentity(vol volumes.FsVolume) (key interface{}, ok bool) {
+	if vol == nil {
+		return nil, false
+	}
+	val := reflect.ValueOf(vol)
+	if hashable(val) {
+		return vol, true
+	}
+	switch val.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return volumeKey{typ: val.Type(), ptr: val.Pointer()}, true
+	}
+	return nil, false
+}
+
+// hashable reports whether v can be a map key. Unlike Type.Comparable it
+// looks at the dynamic values of interfaces, which panic as keys when they
+// hold a slice, map or func.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
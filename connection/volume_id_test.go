@@ -0,0 +1,78 @@
+package connection
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// taggedVolume is a volume of a struct type that cannot be a map key.
+type taggedVolume struct {
+	*volumes.MemVolume
+	tags []string
+}
+
+func TestNewConnectorE(t *testing.T) {
+	a, b := volumes.NewMemory("a"), volumes.NewMemory("b")
+	for _, tc := range []struct {
+		name string
+		opts []Options
+		want error
+	}{
+		{"duplicate id", []Options{WithVolume(a, VolumeOption{ID: "l1"}), WithVolume(b, VolumeOption{ID: "l1"})}, ErrVolumeExists},
+		{"same volume", []Options{WithVolumes(a, a)}, ErrVolumeExists},
+		{"invalid id", []Options{WithVolume(a, VolumeOption{ID: "1a"})}, ErrInvalidVolumeID},
+		{"nil volume", []Options{WithVolumes(nil)}, ErrNilVolume},
+	} {
+		if c, err := NewConnectorE(tc.opts...); !errors.Is(err, tc.want) || c != nil {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+		if c := NewConnector(tc.opts...); len(c.List()) > 1 {
+			t.Errorf("%s: %d volumes mounted", tc.name, len(c.List()))
+		}
+	}
+	c, err := NewConnectorE(WithVolume(a, VolumeOption{ID: "l1"}), WithVolumes(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetVolId(a) != "l1" || c.GetVolId(b) == "" || c.GetVolId(volumes.NewMemory("a")) != "" {
+		t.Errorf("volume ids %q %q", c.GetVolId(a), c.GetVolId(b))
+	}
+}
+
+func TestNonComparableVolume(t *testing.T) {
+	for _, vol := range []volumes.FsVolume{
+		taggedVolume{MemVolume: volumes.NewMemory("tagged"), tags: []string{"team"}},
+		volumes.ReadOnly(taggedVolume{MemVolume: volumes.NewMemory("tagged"), tags: []string{"team"}}),
+	} {
+		c, err := NewConnectorE(WithVolume(vol, VolumeOption{ID: "t1"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := c.GetVolId(vol); id != "" {
+			t.Errorf("id %q of a volume without identity", id)
+		}
+		if res := getTest(t, c, url.Values{"cmd": {"ls"}, "target": {c.EncodeTarget("t1", "/tagged")}}); res.Error != nil {
+			t.Errorf("ls: %+v", res)
+		}
+		if _, err = c.Mount(vol, VolumeOption{ID: "t2"}); err != nil {
+			t.Errorf("mount under another id: %v", err)
+		}
+		if err = c.Unmount("t1"); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReadOnlyVolumeIdentity(t *testing.T) {
+	vol := volumes.ReadOnly(volumes.NewMemory("ro"))
+	c, err := NewConnectorE(WithVolume(vol, VolumeOption{ID: "r1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := c.GetVolId(vol); id != "r1" {
+		t.Errorf("id %q of a read-only volume", id)
+	}
+}
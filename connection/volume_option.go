@@ -0,0 +1,197 @@
+package connection
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/LeeEirc/elfinder/model"
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// VolumeOption is the configuration of a single volume. It is advertised to
+// the client in the options of open and enforced by the commands. Start from
+// DefaultVolumeOption, the zero value does not overwrite uploads.
+type VolumeOption struct {
+	// ID is the volume id the client sees in front of every hash, a letter
+	// followed by up to 31 letters and digits, e.g. "l1". It defaults to an
+	// id derived from the volume name, set it when names repeat or change.
+	ID string
+	// Disabled lists the commands rejected with errCmdNoSupport on this
+	// volume, e.g. "rm" or "upload". open cannot be disabled.
+	Disabled []string
+	// UiCmdMap maps client commands to connector commands, e.g.
+	// {"chmod": "perm"}.
+	UiCmdMap map[string]string
+	// CopyOverwrite replaces existing entries on paste, otherwise the pasted
+	// entry gets a new name.
+	CopyOverwrite bool
+	// UploadOverwrite replaces existing files on upload, otherwise the
+	// uploaded file gets a new name. A client may override it per request.
+	UploadOverwrite bool
+	// UploadPolicy replaces the connector wide upload limits when set.
+	UploadPolicy *UploadPolicy
+	// Archivers replaces the default archive MIME types when set.
+	Archivers *model.ArchiverOption
+	// DispInline replaces the default MIME types the file command lets the
+	// browser display, ^(?:image|text/plain$), when set. Other files are
+	// sent as attachment.
+	DispInline *regexp.Regexp
+	// URL and TmbURL are the public base URLs of files and thumbnails.
+	URL    string
+	TmbURL string
+}
+
+var defaultDispInline = regexp.MustCompile(`^(?:image|text/plain$)`)
+
+func DefaultVolumeOption() VolumeOption {
+	return VolumeOption{UploadOverwrite: true}
+}
+
+// inline reports whether a file of mimeType may be displayed by the browser.
+func (o VolumeOption) inline(mimeType string) bool {
+	dispInline := o.DispInline
+	if dispInline == nil {
+		dispInline = defaultDispInline
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err == nil && dispInline.MatchString(mediaType)
+}
+
+func (o VolumeOption) disabled(cmd string) bool {
+	if cmd == cmdOpen {
+		return false
+	}
+	for i := range o.Disabled {
+		if o.Disabled[i] == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// mutatingCmds are disabled on read-only volumes, including client side
+// commands so that the client greys them out.
+var mutatingCmds = []string{
+	"archive", "chmod", "cut", "duplicate", "edit", "empty", "extract", cmdMkdir,
+	cmdMkfile, cmdPaste, "put", cmdRename, "resize", cmdRm, cmdUpload,
+}
+
+func isReadOnlyVol(vol volumes.FsVolume) bool {
+	checker, ok := vol.(volumes.ReadOnlyChecker)
+	return ok && checker.ReadOnly()
+}
+
+// forVolume completes o with the restrictions vol imposes itself.
+func (o VolumeOption) forVolume(vol volumes.FsVolume) VolumeOption {
+	if !isReadOnlyVol(vol) {
+		return o
+	}
+	disabled := append([]string(nil), o.Disabled...)
+	for _, cmd := range mutatingCmds {
+		if !o.disabled(cmd) {
+			disabled = append(disabled, cmd)
+		}
+	}
+	o.Disabled = disabled
+	o.CopyOverwrite = false
+	o.UploadOverwrite = false
+	return o
+}
+
+// volOption returns the options of the volume vid.
+func (c *Connector) volOption(ctx context.Context, vid string) VolumeOption {
+	if mounted, ok := c.mountedVolume(ctx, vid); ok {
+		return mounted.Option
+	}
+	return DefaultVolumeOption()
+}
+
+// uploadPolicyOf returns the upload limits of the volume vid.
+func (c *Connector) uploadPolicyOf(ctx context.Context, vid string) UploadPolicy {
+	if policy := c.volOption(ctx, vid).UploadPolicy; policy != nil {
+		return *policy
+	}
+	return c.uploadPolicy
+}
+
+// clientOption builds the options sent to the client for the folder cwdPath
+// of the volume vid.
+func (c *Connector) clientOption(ctx context.Context, vid, cwdPath string) model.Option {
+	volOpt := c.volOption(ctx, vid)
+	opt := model.NewDefaultOption()
+	opt.Path = cwdPath
+	opt.URL = volOpt.URL
+	opt.TmbURL = volOpt.TmbURL
+	opt.Disabled = []string{}
+	for i := range volOpt.Disabled {
+		if volOpt.disabled(volOpt.Disabled[i]) {
+			opt.Disabled = append(opt.Disabled, volOpt.Disabled[i])
+		}
+	}
+	opt.UiCmdMap = volOpt.UiCmdMap
+	if volOpt.CopyOverwrite {
+		opt.CopyOverwrite = 1
+	}
+	if volOpt.UploadOverwrite {
+		opt.UploadOverwrite = 1
+	}
+	if volOpt.Archivers != nil {
+		opt.Archivers = *volOpt.Archivers
+	}
+	opt.DispInlineRegex = defaultDispInline.String()
+	if volOpt.DispInline != nil {
+		opt.DispInlineRegex = volOpt.DispInline.String()
+	}
+	c.uploadPolicyOf(ctx, vid).advertise(&opt)
+	return opt
+}
+
+// disabledVolume returns the name of a volume of the request targets on which
+// cmd is disabled. Only dst counts when present, so paste can copy out of a
+// volume with paste disabled, but a paste with cut=1 removes its targets and
+// so needs rm and cut on their volumes. The upload_path[] folders of an
+// upload count like its target. Requests without a target address the
+// default volume, targets that do not decode are left to the command to
+// reject.
+func (c *Connector) disabledVolume(cmd string, req *http.Request) (string, bool) {
+	hashes := req.Form["dst"]
+	if len(hashes) == 0 {
+		hashes = append(append([]string(nil), req.Form["target"]...), req.Form["targets[]"]...)
+		hashes = append(hashes, req.Form["upload_path[]"]...)
+	} else if cut, _ := strconv.ParseBool(req.Form.Get("cut")); cut {
+		if volName, ok := c.disabledOn(req, req.Form["targets[]"], cmdRm, "cut"); ok {
+			return volName, true
+		}
+	}
+	if len(hashes) == 0 {
+		if vid, vol := c.defaultVolume(req.Context()); vol != nil && c.volOption(req.Context(), vid).disabled(cmd) {
+			return vol.Name(), true
+		}
+		return "", false
+	}
+	return c.disabledOn(req, hashes, cmd)
+}
+
+// disabledOn returns the name of a volume of hashes on which one of cmds is
+// disabled.
+func (c *Connector) disabledOn(req *http.Request, hashes []string, cmds ...string) (string, bool) {
+	for i := range hashes {
+		vid, _, err := c.ParseTarget(hashes[i])
+		if err != nil {
+			continue
+		}
+		mounted, ok := c.mountedVolume(req.Context(), vid)
+		if !ok {
+			continue
+		}
+		for _, cmd := range cmds {
+			if mounted.Option.disabled(cmd) {
+				return mounted.Volume.Name(), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,51 @@
+package connection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+func TestReadOnlyVolume(t *testing.T) {
+	mem, err := volumes.NewMemoryFrom("ro", fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := volumes.ReadOnly(mem)
+	c := NewConnector(WithVolume(vol, VolumeOption{ID: "r1", Disabled: []string{"archive"}}))
+	q := url.Values{"cmd": {"open"}, "target": {testTarget(c, vol, "")}, "init": {"1"}}
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil))
+	var res struct {
+		testResponse
+		Options struct {
+			Disabled []string `json:"disabled"`
+		} `json:"options"`
+	}
+	if err = json.Unmarshal(rw.Body.Bytes(), &res); err != nil || res.Error != nil || len(res.Files) != 2 {
+		t.Fatalf("open: %s", rw.Body.String())
+	}
+	for _, file := range res.Files {
+		if file.Write != 0 || file.Locked != 1 {
+			t.Errorf("%s: write %d locked %d", file.Name, file.Write, file.Locked)
+		}
+	}
+	disabled := make(map[string]int)
+	for _, cmd := range res.Options.Disabled {
+		disabled[cmd]++
+	}
+	for _, cmd := range mutatingCmds {
+		if disabled[cmd] != 1 {
+			t.Errorf("%s listed %d times in disabled %v", cmd, disabled[cmd], res.Options.Disabled)
+		}
+	}
+	res2 := getTest(t, c, url.Values{"cmd": {"rm"}, "targets[]": {testTarget(c, vol, "/a.txt")}})
+	if res2.errType() != "errCmdNoSupport" {
+		t.Errorf("rm on a read-only volume: %+v", res2)
+	}
+}
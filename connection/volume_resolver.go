@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+)
+
+// VolumeResolver returns the volumes of the caller of req, e.g. the home
+// directory of the user and the team volumes of its groups. The identity set
+// by the Authenticator is in req.Context(). The returned volumes replace the
+// ones of the connector for the request, List gives access to them for
+// resolvers sharing some of them.
+//
+// An empty ID is taken from the options or derived from the volume name
+// like for WithVolume, invalid and duplicate ids are skipped. Options are
+// completed like the ones of WithVolume. The resolver runs on every request,
+// it should cache volumes that are expensive to build.
+type VolumeResolver interface {
+	ResolveVolumes(req *http.Request) ([]MountedVolume, error)
+}
+
+type VolumeResolverFunc func(req *http.Request) ([]MountedVolume, error)
+
+func (f VolumeResolverFunc) ResolveVolumes(req *http.Request) ([]MountedVolume, error) {
+	return f(req)
+}
+
+// WithVolumeResolver resolves the volumes per request, volumes added with
+// WithVolumes or Mount are only visible through the resolver.
+func WithVolumeResolver(resolver VolumeResolver) Options {
+	return func(o *option) {
+		o.VolumeResolver = resolver
+	}
+}
+
+// volumeSet holds the volumes resolved for one request.
+type volumeSet struct {
+	list []MountedVolume
+	byID map[string]int
+}
+
+type volumeSetCtxKey struct{}
+
+// resolveVolumes stores the volumes of the caller of req in its context.
+func (c *Connector) resolveVolumes(req *http.Request) (*http.Request, error) {
+	if c.volumeResolver == nil {
+		return req, nil
+	}
+	resolved, err := c.volumeResolver.ResolveVolumes(req)
+	if err != nil {
+		return req, err
+	}
+	set := &volumeSet{
+		list: make([]MountedVolume, 0, len(resolved)),
+		byID: make(map[string]int, len(resolved)),
+	}
+	for i := range resolved {
+		mounted := resolved[i]
+		if mounted.Volume == nil {
+			c.Logger.Errorf("resolve volumes errs: %s: %s", ErrNilVolume, mounted.ID)
+			continue
+		}
+		if mounted.ID == "" {
+			mounted.ID = mounted.Option.ID
+		}
+		if mounted.ID, err = volumeID(mounted.ID, mounted.Volume); err != nil {
+			c.Logger.Errorf("resolve volumes errs: %s: %s", mounted.Volume.Name(), err)
+			continue
+		}
+		mounted.Option.ID = mounted.ID
+		if _, ok := set.byID[mounted.ID]; ok {
+			c.Logger.Errorf("resolve volumes errs: %s: %s", ErrVolumeExists, mounted.Volume.Name())
+			continue
+		}
+		mounted.Option = mounted.Option.forVolume(mounted.Volume)
+		set.byID[mounted.ID] = len(set.list)
+		set.list = append(set.list, mounted)
+	}
+	return req.WithContext(context.WithValue(req.Context(), volumeSetCtxKey{}, set)), nil
+}
+
+func volumeSetFromContext(ctx context.Context) (*volumeSet, bool) {
+	set, ok := ctx.Value(volumeSetCtxKey{}).(*volumeSet)
+	return set, ok
+}
+
+// volumeList returns the volumes visible to the request of ctx, without the
+// network volumes of its session.
+func (c *Connector) volumeList(ctx context.Context) []MountedVolume {
+	if set, ok := volumeSetFromContext(ctx); ok {
+		return set.list
+	}
+	return c.List()
+}
+
+// mountedVolume returns the volume vid of the request of ctx with its options.
+func (c *Connector) mountedVolume(ctx context.Context, vid string) (MountedVolume, bool) {
+	if set, ok := volumeSetFromContext(ctx); ok {
+		if i, ok := set.byID[vid]; ok {
+			return set.list[i], true
+		}
+	} else {
+		c.mux.Lock()
+		vol, ok := c.Vols[vid]
+		opt := c.volOptions[vid]
+		c.mux.Unlock()
+		if ok {
+			return MountedVolume{ID: vid, Volume: vol, Option: opt}, true
+		}
+	}
+	if vol, ok := c.netMount(ctx, vid); ok {
+		return MountedVolume{ID: vid, Volume: vol, Option: DefaultVolumeOption().forVolume(vol)}, true
+	}
+	return MountedVolume{}, false
+}
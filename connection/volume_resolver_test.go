@@ -0,0 +1,83 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// newResolverConnector serves a home volume to alice and bob each, a shared
+// volume to both and a nil volume the connector must skip.
+func newResolverConnector(t *testing.T) *Connector {
+	t.Helper()
+	homes := map[string]volumes.FsVolume{
+		"alice": volumes.NewMemory("alice"),
+		"bob":   volumes.NewMemory("bob"),
+	}
+	shared := volumes.NewMemory("shared")
+	resolver := VolumeResolverFunc(func(req *http.Request) ([]MountedVolume, error) {
+		identity, _ := IdentityFromContext(req.Context())
+		return []MountedVolume{
+			{ID: "h" + identity.User, Volume: homes[identity.User], Option: DefaultVolumeOption()},
+			{ID: "s1", Volume: shared, Option: DefaultVolumeOption()},
+			{ID: "n1"},
+		}, nil
+	})
+	return NewConnector(WithAuthenticator(HeaderAuthenticator("X-Remote-User")), WithVolumeResolver(resolver))
+}
+
+func TestResolverVolumesPerIdentity(t *testing.T) {
+	c := newResolverConnector(t)
+	ls := func(user, target string) testResponse {
+		req := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"cmd": {"ls"}, "target": {target}}.Encode(), nil)
+		req.Header = userHeader(user)
+		return serveTest(t, c, req)
+	}
+	for _, tc := range []struct {
+		user, target string
+		ok           bool
+	}{
+		{"alice", c.EncodeTarget("halice", "/alice"), true},
+		{"alice", c.EncodeTarget("s1", "/shared"), true},
+		{"bob", c.EncodeTarget("hbob", "/bob"), true},
+		{"bob", c.EncodeTarget("halice", "/alice"), false},
+		{"bob", c.EncodeTarget("n1", "/"), false},
+	} {
+		if res := ls(tc.user, tc.target); (res.Error == nil) != tc.ok {
+			t.Errorf("ls %s as %s: %+v", tc.target, tc.user, res)
+		}
+	}
+}
+
+func TestResolverGetFsByIdIsolation(t *testing.T) {
+	c := newResolverConnector(t)
+	ctxOf := func(user string) context.Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(WithIdentity(req.Context(), &Identity{User: user}))
+		req, err := c.resolveVolumes(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req.Context()
+	}
+	alice, bob := ctxOf("alice"), ctxOf("bob")
+	if vol := c.GetFsById(alice, "halice"); vol == nil || vol.Name() != "alice" {
+		t.Errorf("home of alice: %v", vol)
+	}
+	if vol := c.GetFsById(bob, "halice"); vol != nil {
+		t.Errorf("bob sees the home of alice: %s", vol.Name())
+	}
+	if c.GetFsById(alice, "s1") != c.GetFsById(bob, "s1") || c.GetFsById(bob, "s1") == nil {
+		t.Error("shared volume differs between the users")
+	}
+	if vol := c.GetFsById(context.Background(), "halice"); vol != nil {
+		t.Errorf("resolved volume outside the request: %s", vol.Name())
+	}
+	if list := c.volumeList(alice); len(list) != 2 {
+		t.Errorf("%d volumes, want the nil volume skipped", len(list))
+	}
+}
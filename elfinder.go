@@ -33,12 +33,16 @@ const (
 
 type Volumes []Volume
 
+// formDecoder caches struct metadata and is safe for concurrent use.
+var formDecoder = form.NewDecoder()
+
 func NewElFinderConnector(vs Volumes) *ElFinderConnector {
 	var volumeMap = make(map[string]Volume)
 	for _, vol := range vs {
 		volumeMap[vol.ID()] = vol
 	}
-	return &ElFinderConnector{Volumes: volumeMap, defaultV: vs[0], req: &ELFRequest{}, res: &ElfResponse{}}
+	return &ElFinderConnector{Volumes: volumeMap, defaultV: vs[0],
+		zipTmpPath: defaultTmpPath, zipMaxSize: int64(defaultZipMaxSize)}
 }
 
 func NewElFinderConnectorWithOption(vs Volumes, option map[string]string) *ElFinderConnector {
@@ -69,23 +73,26 @@ func NewElFinderConnectorWithOption(vs Volumes, option map[string]string) *ElFin
 	if zipTmpPath == "" {
 		zipTmpPath = defaultTmpPath
 	}
-	return &ElFinderConnector{Volumes: volumeMap, defaultV: vs[0], req: &ELFRequest{}, res: &ElfResponse{},
+	return &ElFinderConnector{Volumes: volumeMap, defaultV: vs[0],
 		zipTmpPath: zipTmpPath, zipMaxSize: zipMaxSize}
 }
 
+// ElFinderConnector holds only configuration shared by all requests. Every
+// command receives its own ELFRequest and returns its own ElfResponse, so a
+// single connector may serve concurrent requests.
 type ElFinderConnector struct {
 	Volumes  map[string]Volume
 	defaultV Volume
-	req      *ELFRequest
-	res      *ElfResponse
 
 	zipMaxSize int64
 	zipTmpPath string
 }
 
 func (elf *ElFinderConnector) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	var err error
-	decoder := form.NewDecoder()
+	var (
+		err    error
+		elfReq ELFRequest
+	)
 	switch req.Method {
 	case "GET":
 		if err := req.ParseForm(); err != nil {
@@ -104,52 +111,45 @@ func (elf *ElFinderConnector) ServeHTTP(rw http.ResponseWriter, req *http.Reques
 		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	err = decoder.Decode(elf.req, req.Form)
+	err = formDecoder.Decode(&elfReq, req.Form)
 	if err != nil {
 		log.Println(err)
 	}
-	elf.dispatch(rw, req)
+	elf.dispatch(rw, req, &elfReq)
 }
 
-func (elf *ElFinderConnector) open() {
+func (elf *ElFinderConnector) open(req *ELFRequest) (ret ElfResponse) {
 	// client: reload, back, forward, home , open
 	// open dir
-	var ret ElfResponse
 	var path string
 	var v Volume
 	var err error
 
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	if len(IDAndTarget) == 1 {
-		path = "/"
-	} else {
-		path, err = elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
-		if err != nil {
-			elf.res.Error = []string{errAccess, err.Error()}
-			return
-		}
+	v, path, err = elf.resolveTarget(req.Target)
+	if err != nil {
+		ret.Error = []string{errAccess, err.Error()}
+		return
 	}
 	if path == "" || path == "/" {
 		v = elf.defaultV
 		ret.Cwd = v.RootFileDir()
 		ret.Files = v.List(path)
 	} else {
-		v = elf.getVolume(IDAndTarget[0])
 		ret.Cwd, err = v.Info(path)
 		if err != nil {
-			elf.res.Error = []string{errAccess, err.Error()}
+			ret.Error = []string{errAccess, err.Error()}
 			return
 		}
 		ret.Files = v.List(path)
 	}
 	ret.Files = append(ret.Files, ret.Cwd)
-	if elf.req.Init {
+	if req.Init {
 		ret.Api = APIVERSION
 		ret.UplMaxSize = UPLOADMAXSIZE
 		ret.Options = defaultOptions
 	}
 
-	if elf.req.Tree {
+	if req.Tree {
 		ret.Tree = make([]FileDir, 0, len(elf.Volumes))
 		for _, item := range elf.Volumes {
 			ret.Files = append(ret.Files, item.RootFileDir())
@@ -158,13 +158,11 @@ func (elf *ElFinderConnector) open() {
 			ret.Files = append(ret.Files, item)
 		}
 	}
-	elf.res = &ret
+	return
 }
 
-func (elf *ElFinderConnector) file() (read io.ReadCloser, filename string, err error) {
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) file(req *ELFRequest) (read io.ReadCloser, filename string, err error) {
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
 		return
 	}
@@ -173,22 +171,19 @@ func (elf *ElFinderConnector) file() (read io.ReadCloser, filename string, err e
 	return reader, filename, err
 }
 
-func (elf *ElFinderConnector) ls() {
-	var path string
-	elf.res.List = make([]string, 0)
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	if len(IDAndTarget) == 1 {
-		path = "/"
-	} else {
-		path, _ = elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) ls(req *ELFRequest) (ret ElfResponse) {
+	ret.List = make([]string, 0)
+	v, path, err := elf.resolveTarget(req.Target)
+	if err != nil {
+		ret.Error = []string{errAccess, err.Error()}
+		return
 	}
 	dirs := v.List(path)
 	resultFiles := make([]string, 0, len(dirs))
-	if elf.req.Intersect != nil {
+	if req.Intersect != nil {
 
 		for _, item := range dirs {
-			for _, jitem := range elf.req.Intersect {
+			for _, jitem := range req.Intersect {
 				if item.Name == jitem {
 					resultFiles = append(resultFiles,
 						fmt.Sprintf(`"%s";"%s"`, item.Hash, item.Name))
@@ -200,86 +195,79 @@ func (elf *ElFinderConnector) ls() {
 			resultFiles = append(resultFiles, fmt.Sprintf(`"%s";"%s"`, item.Hash, item.Name))
 		}
 	}
-	elf.res.List = resultFiles
-
+	ret.List = resultFiles
+	return
 }
 
-func (elf *ElFinderConnector) parents() {
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) parents(req *ELFRequest) (ret ElfResponse) {
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
-		elf.res.Error = err
+		ret.Error = err
 		return
 	}
-	elf.res.Tree = v.Parents(path, 0)
+	ret.Tree = v.Parents(path, 0)
+	return
 }
 
-func (elf *ElFinderConnector) mkDir() {
+func (elf *ElFinderConnector) mkDir(req *ELFRequest) (ret ElfResponse) {
 	added := make([]FileDir, 0)
 	hashs := make(map[string]string)
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
-		elf.res.Error = []string{errMkdir, elf.req.Name, err.Error()}
+		ret.Error = []string{errMkdir, req.Name, err.Error()}
 		return
 	}
-	if elf.req.Name != "" {
-		fileDir, err := v.MakeDir(path, elf.req.Name)
+	if req.Name != "" {
+		fileDir, err := v.MakeDir(path, req.Name)
 		if err != nil {
-			elf.res.Error = []string{errMkdir, elf.req.Name, err.Error()}
+			ret.Error = []string{errMkdir, req.Name, err.Error()}
 			return
 		}
 		added = append(added, fileDir)
 	}
-	if len(elf.req.Dirs) != 0 {
-		for _, name := range elf.req.Dirs {
+	if len(req.Dirs) != 0 {
+		for _, name := range req.Dirs {
 			fileDir, err := v.MakeDir(path, name)
 			if err != nil {
-				elf.res.Error = []string{errMkdir, elf.req.Name, err.Error()}
+				ret.Error = []string{errMkdir, req.Name, err.Error()}
 				break
 			}
 			added = append(added, fileDir)
 			hashs[name] = name
 		}
 	}
-	elf.res.Added = added
-	elf.res.Hashes = hashs
+	ret.Added = added
+	ret.Hashes = hashs
+	return
 }
 
-func (elf *ElFinderConnector) mkFile() {
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) mkFile(req *ELFRequest) (ret ElfResponse) {
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
-		elf.res.Error = []string{errMkfile, elf.req.Name, err.Error()}
+		ret.Error = []string{errMkfile, req.Name, err.Error()}
 		return
 	}
-	fileDir, err := v.MakeFile(path, elf.req.Name)
+	fileDir, err := v.MakeFile(path, req.Name)
 	if err != nil {
-		elf.res.Error = []string{errMkfile, elf.req.Name, err.Error()}
+		ret.Error = []string{errMkfile, req.Name, err.Error()}
 		return
 	}
-	elf.res.Added = []FileDir{fileDir}
+	ret.Added = []FileDir{fileDir}
+	return
 }
 
-func (elf *ElFinderConnector) paste() {
+func (elf *ElFinderConnector) paste(req *ELFRequest) (ret ElfResponse) {
 	//cut, copy, paste
-	added := make([]FileDir, 0, len(elf.req.Targets))
-	removed := make([]string, 0, len(elf.req.Targets))
+	added := make([]FileDir, 0, len(req.Targets))
+	removed := make([]string, 0, len(req.Targets))
 
-	dstIDAndTarget := strings.Split(elf.req.Dst, "_")
-	dstPath, err := elf.parseTarget(strings.Join(dstIDAndTarget[1:], "_"))
+	dstVol, dstPath, err := elf.resolveTarget(req.Dst)
 	if err != nil {
-		elf.res.Error = err
+		ret.Error = err
 		return
 	}
-	dstVol := elf.getVolume(dstIDAndTarget[0])
-	for i, target := range elf.req.Targets {
-		srcIDAndTarget := strings.Split(target, "_")
-		srcVol := elf.getVolume(srcIDAndTarget[0])
-		srcPath, err := elf.parseTarget(strings.Join(srcIDAndTarget[1:], "_"))
+	for i, target := range req.Targets {
+		srcVol, srcPath, err := elf.resolveTarget(target)
 		if err != nil {
 			log.Println("parse path errs: ", err)
 			continue
@@ -294,48 +282,49 @@ func (elf *ElFinderConnector) paste() {
 			dstFolderFiles := dstVol.List(dstPath)
 			for _, item := range dstFolderFiles {
 				if item.Dirs == 1 && item.Name == srcFileDir.Name {
-					newDirName = newDirName + elf.req.Suffix
+					newDirName = newDirName + req.Suffix
 				}
 			}
 			newDstDirFile, err := dstVol.MakeDir(dstPath, newDirName)
 			if err != nil {
 				log.Printf("Make Dir errs: %s", err.Error())
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
 			added = append(added, newDstDirFile)
-			newAddFiles := elf.copyFolder(filepath.Join(dstPath, newDstDirFile.Name), srcPath, dstVol, srcVol)
+			newAddFiles := elf.copyFolder(filepath.Join(dstPath, newDstDirFile.Name), srcPath, req.Suffix, dstVol, srcVol)
 			added = append(added, newAddFiles...)
 		} else {
 			srcFd, err := srcVol.GetFile(srcPath)
 			if err != nil {
 				log.Println("Get File errs: ", err.Error())
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
-			newFileDir, err := dstVol.Paste(dstPath, srcFileDir.Name, elf.req.Suffix, srcFd)
+			newFileDir, err := dstVol.Paste(dstPath, srcFileDir.Name, req.Suffix, srcFd)
 			if err != nil {
 				log.Println("parse path errs: ", err)
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
 			added = append(added, newFileDir)
 		}
-		if elf.req.Cut {
+		if req.Cut {
 			err = srcVol.Remove(srcPath)
 			if err == nil {
-				removed = append(removed, elf.req.Targets[i])
+				removed = append(removed, req.Targets[i])
 			} else {
 				log.Println("cut file failed")
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 			}
 		}
 	}
-	elf.res.Added = added
-	elf.res.Removed = removed
+	ret.Added = added
+	ret.Removed = removed
+	return
 }
 
-func (elf *ElFinderConnector) copyFolder(dstPath, srcDir string, dstVol, srcVol Volume) (added []FileDir) {
+func (elf *ElFinderConnector) copyFolder(dstPath, srcDir, suffix string, dstVol, srcVol Volume) (added []FileDir) {
 	srcFiles := srcVol.List(srcDir)
 	added = make([]FileDir, 0, len(srcFiles))
 	for i := 0; i < len(srcFiles); i++ {
@@ -348,7 +337,7 @@ func (elf *ElFinderConnector) copyFolder(dstPath, srcDir string, dstVol, srcVol
 			}
 			added = append(added, subDirFile)
 			newDstPath := filepath.Join(dstPath, subDirFile.Name)
-			subAdded := elf.copyFolder(newDstPath, srcPath, dstVol, srcVol)
+			subAdded := elf.copyFolder(newDstPath, srcPath, suffix, dstVol, srcVol)
 			added = append(added, subAdded...)
 		} else {
 			srcFd, err := srcVol.GetFile(srcPath)
@@ -356,7 +345,7 @@ func (elf *ElFinderConnector) copyFolder(dstPath, srcDir string, dstVol, srcVol
 				log.Println("Get File errs: ", err)
 				continue
 			}
-			newFileDir, err := dstVol.Paste(dstPath, srcFiles[i].Name, elf.req.Suffix, srcFd)
+			newFileDir, err := dstVol.Paste(dstPath, srcFiles[i].Name, suffix, srcFd)
 			if err != nil {
 				log.Println("parse path errs: ", err)
 				continue
@@ -367,39 +356,35 @@ func (elf *ElFinderConnector) copyFolder(dstPath, srcDir string, dstVol, srcVol
 	return
 }
 
-func (elf *ElFinderConnector) ping() {
-
+func (elf *ElFinderConnector) ping(req *ELFRequest) (ret ElfResponse) {
+	return
 }
 
-func (elf *ElFinderConnector) rename() {
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) rename(req *ELFRequest) (ret ElfResponse) {
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
-		elf.res.Error = []string{"errRename", elf.req.Name}
+		ret.Error = []string{"errRename", req.Name}
 		return
 	}
-	fileDir, err := v.Rename(path, elf.req.Name)
+	fileDir, err := v.Rename(path, req.Name)
 	if err != nil {
-		elf.res.Error = []string{"errRename", elf.req.Name}
+		ret.Error = []string{"errRename", req.Name}
 		return
 	}
-	elf.res.Added = []FileDir{fileDir}
-	elf.res.Removed = []string{elf.req.Target}
-
+	ret.Added = []FileDir{fileDir}
+	ret.Removed = []string{req.Target}
+	return
 }
 
-func (elf *ElFinderConnector) resize() {
-
+func (elf *ElFinderConnector) resize(req *ELFRequest) (ret ElfResponse) {
+	return
 }
 
-func (elf *ElFinderConnector) rm() {
-	removed := make([]string, 0, len(elf.req.Targets))
-	errs := make([]string, 0, len(elf.req.Target))
-	for _, target := range elf.req.Targets {
-		IDAndTarget := strings.Split(target, "_")
-		v := elf.getVolume(IDAndTarget[0])
-		path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) rm(req *ELFRequest) (ret ElfResponse) {
+	removed := make([]string, 0, len(req.Targets))
+	errs := make([]string, 0, len(req.Target))
+	for _, target := range req.Targets {
+		v, path, err := elf.resolveTarget(target)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -411,34 +396,35 @@ func (elf *ElFinderConnector) rm() {
 		}
 		removed = append(removed, target)
 	}
-	elf.res.Removed = removed
+	ret.Removed = removed
 	if len(errs) > 0 {
-		elf.res.Error = errs
+		ret.Error = errs
 	}
+	return
 }
 
-func (elf *ElFinderConnector) search() {
-	var ret = ElfResponse{Files: []FileDir{}}
+func (elf *ElFinderConnector) search(req *ELFRequest) (ret ElfResponse) {
+	ret = ElfResponse{Files: []FileDir{}}
 	var err error
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, _ := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
-	ret.Files, err = v.Search(path, elf.req.QueryKey, elf.req.Mimes...)
+	v, path, err := elf.resolveTarget(req.Target)
+	if err != nil {
+		ret.Error = err
+		return
+	}
+	ret.Files, err = v.Search(path, req.QueryKey, req.Mimes...)
 	if err != nil {
 		ret.Error = err
 	}
 	if ret.Files == nil {
 		ret.Files = make([]FileDir, 0)
 	}
-	elf.res = &ret
+	return
 }
 
-func (elf *ElFinderConnector) duplicate() {
-	added := make([]FileDir, 0, len(elf.req.Targets))
-	for _, target := range elf.req.Targets {
-		srcIDAndTarget := strings.Split(target, "_")
-		srcVol := elf.getVolume(srcIDAndTarget[0])
-		srcPath, err := elf.parseTarget(strings.Join(srcIDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) duplicate(req *ELFRequest) (ret ElfResponse) {
+	added := make([]FileDir, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		srcVol, srcPath, err := elf.resolveTarget(target)
 		if err != nil {
 			log.Println("parse path errs: ", err)
 			continue
@@ -454,37 +440,36 @@ func (elf *ElFinderConnector) duplicate() {
 			newDstDirFile, err := srcVol.MakeDir(dstPath, newName)
 			if err != nil {
 				log.Printf("Make Dir errs: %s", err.Error())
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
 			added = append(added, newDstDirFile)
-			newAddFiles := elf.copyFolder(filepath.Join(dstPath, newDstDirFile.Name), srcPath, srcVol, srcVol)
+			newAddFiles := elf.copyFolder(filepath.Join(dstPath, newDstDirFile.Name), srcPath, "_duplicate_", srcVol, srcVol)
 			added = append(added, newAddFiles...)
 		} else {
 			srcFd, err := srcVol.GetFile(srcPath)
 			if err != nil {
 				log.Println("Get File errs: ", err.Error())
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
 			dstFdInfo, err := srcVol.Paste(dstPath, newName, "_duplicate_", srcFd)
 			if err != nil {
 				log.Println("Duplicate path errs: ", err)
-				elf.res.Error = []string{errMsg, err.Error()}
+				ret.Error = []string{errMsg, err.Error()}
 				break
 			}
 			added = append(added, dstFdInfo)
 		}
 	}
-	elf.res.Added = added
+	ret.Added = added
+	return
 }
 
-func (elf *ElFinderConnector) size() {
+func (elf *ElFinderConnector) size(req *ELFRequest) (ret ElfResponse) {
 	var totalSize int64
-	for _, target := range elf.req.Targets {
-		IDAndTarget := strings.Split(target, "_")
-		v := elf.getVolume(IDAndTarget[0])
-		path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+	for _, target := range req.Targets {
+		v, path, err := elf.resolveTarget(target)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -501,16 +486,15 @@ func (elf *ElFinderConnector) size() {
 			totalSize += tmpInfo.Size
 		}
 	}
-	elf.res.Size = totalSize
+	ret.Size = totalSize
+	return
 }
 
-func (elf *ElFinderConnector) tree() {
-	var ret = ElfResponse{Tree: []FileDir{}}
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+func (elf *ElFinderConnector) tree(req *ELFRequest) (ret ElfResponse) {
+	ret = ElfResponse{Tree: []FileDir{}}
+	v, path, err := elf.resolveTarget(req.Target)
 	if err != nil {
-		elf.res.Error = err
+		ret.Error = err
 		return
 	}
 	dirs := v.List(path)
@@ -519,38 +503,155 @@ func (elf *ElFinderConnector) tree() {
 			ret.Tree = append(ret.Tree, dirs[i])
 		}
 	}
-	elf.res = &ret
+	return
 }
 
-func (elf *ElFinderConnector) upload() (Volume, string) {
-	IDAndTarget := strings.Split(elf.req.Target, "_")
-	v := elf.getVolume(IDAndTarget[0])
-	path, _ := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
-	return v, path
-}
+func (elf *ElFinderConnector) upload(r *http.Request, req *ELFRequest) (ret ElfResponse) {
+	files := r.MultipartForm.File["upload[]"]
+	added := make([]FileDir, 0, len(files))
+	errs := make([]string, 0, len(files))
+	if len(errs) == 0 {
+		errs = append(errs, errUploadFile)
+	}
+	v, dirpath, err := elf.resolveTarget(req.Target)
+	if err != nil {
+		errs = append(errs, err.Error())
+		return ElfResponse{Warning: errs}
+	}
+	if req.Cid != 0 && req.Chunk != "" {
+		re, err := regexp.Compile(`(.*?)\.([0-9][0-9]*?_[0-9][0-9]*?)(\.part)`)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		ch := re.FindStringSubmatch(req.Chunk)
+		if len(ch) != 4 {
+			errs = append(errs, "errs chunk name")
+			return ElfResponse{Warning: errs}
+		}
+		t := strings.Split(ch[2], "_")
+		currentPart, err := strconv.Atoi(t[0])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		totalPart, err := strconv.Atoi(t[1])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		rangeData := strings.Split(req.Range, ",")
+		if len(rangeData) != 3 {
+			errs = append(errs, "errs range data")
+			return ElfResponse{Warning: errs}
+		}
+		offSet, err := strconv.Atoi(rangeData[0])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		chunkLength, err := strconv.Atoi(rangeData[1])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		totalSize, err := strconv.Atoi(rangeData[2])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		filename := ch[1]
+		for i, uploadFile := range files {
+			f, err := uploadFile.Open()
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			data := ChunkRange{Offset: int64(offSet), Length: int64(chunkLength), TotalSize: int64(totalSize)}
+			uploadPath := ""
+			if len(req.UploadPath) == len(files) && req.UploadPath[i] != req.Target {
+				uploadPath = req.UploadPath[i]
+			}
+			err = v.UploadChunk(req.Cid, dirpath, uploadPath, filename, data, f)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			_ = f.Close()
+		}
+		if currentPart == totalPart {
+			ret.Chunkmerged = fmt.Sprintf("%d_%d_%s", req.Cid, totalPart, filename)
+			ret.Name = filename
+		}
+	} else if req.Chunk != "" {
+		// Chunk merge request
+		re, err := regexp.Compile(`([0-9]*)_([0-9]*)_(.*)`)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		ch := re.FindStringSubmatch(req.Chunk)
+		if len(ch) != 4 {
+			errs = append(errs, "errs chunk name")
+			return ElfResponse{Warning: errs}
+		}
+		var uploadPath string
+		if len(req.UploadPath) == 1 && req.UploadPath[0] != req.Target {
+			uploadPath = req.UploadPath[0]
+		}
 
-func (elf *ElFinderConnector) dispatch(rw http.ResponseWriter, req *http.Request) {
+		cid, _ := strconv.Atoi(ch[1])
+		total, _ := strconv.Atoi(ch[2])
+		result, err := v.MergeChunk(cid, total, dirpath, uploadPath, ch[3])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ElfResponse{Warning: errs}
+		}
+		added = append(added, result)
+	} else {
+		for i, uploadFile := range files {
+			f, err := uploadFile.Open()
+			uploadPath := ""
+			if len(req.UploadPath) == len(files) && req.UploadPath[i] != req.Target {
+				uploadPath = req.UploadPath[i]
+			}
+			result, err := v.UploadFile(dirpath, uploadPath, uploadFile.Filename, f)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			added = append(added, result)
+		}
 
-	switch elf.req.Cmd {
+	}
+	if len(errs) > 1 {
+		ret.Warning = errs
+	}
+	ret.Added = added
+	return
+}
+
+func (elf *ElFinderConnector) dispatch(rw http.ResponseWriter, r *http.Request, req *ELFRequest) {
+	var ret ElfResponse
+	switch req.Cmd {
 	case "open":
-		elf.open()
+		ret = elf.open(req)
 	case "tree":
-		elf.tree()
+		ret = elf.tree(req)
 	case "file":
-		readFile, filename, err := elf.file()
-		if req.Form.Get("cpath") != "" {
-			http.SetCookie(rw, &http.Cookie{Path: req.Form.Get("cpath"), Name: "elfdl" + req.Form.Get("reqid"), Value: "1"})
+		readFile, filename, err := elf.file(req)
+		if r.Form.Get("cpath") != "" {
+			http.SetCookie(rw, &http.Cookie{Path: r.Form.Get("cpath"), Name: "elfdl" + r.Form.Get("reqid"), Value: "1"})
 		}
 		if err != nil {
 			log.Printf("Download file errs: %s", err)
-			elf.res.Error = err.Error()
+			ret.Error = err.Error()
 			rw.WriteHeader(403)
 			_, _ = rw.Write([]byte(err.Error()))
 			return
 		} else {
 			mimeType := mime.TypeByExtension(filepath.Ext(filename))
 			rw.Header().Set("Content-Type", mimeType)
-			if req.Form["download"] != nil {
+			if r.Form["download"] != nil {
 				rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
 			} else {
 				rw.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename=="%s"`, filename))
@@ -561,166 +662,50 @@ func (elf *ElFinderConnector) dispatch(rw http.ResponseWriter, req *http.Request
 				log.Printf("download file %s successful", filename)
 				return
 			} else {
-				elf.res.Error = err.Error()
+				ret.Error = err.Error()
 				log.Printf("download file %s errs: %s", filename, err.Error())
 			}
 		}
 	case "ls":
-		elf.ls()
+		ret = elf.ls(req)
 	case "parents":
-		elf.parents()
+		ret = elf.parents(req)
 	case "mkdir":
-		elf.mkDir()
+		ret = elf.mkDir(req)
 	case "mkfile":
-		elf.mkFile()
+		ret = elf.mkFile(req)
 	case "paste":
-		elf.paste()
+		ret = elf.paste(req)
 	case "rename":
-		elf.rename()
+		ret = elf.rename(req)
 	case "rm":
-		elf.rm()
+		ret = elf.rm(req)
 	case "size":
-		if len(elf.req.Targets) == 0 {
+		if len(req.Targets) == 0 {
 			targets := make([]string, 0, 5)
 			for i := 0; i < 100; i++ {
-				value := req.Form.Get(fmt.Sprintf("targets[%d]", i))
+				value := r.Form.Get(fmt.Sprintf("targets[%d]", i))
 				if value == "" {
 					break
 				}
 				targets = append(targets, value)
 			}
-			elf.req.Targets = targets
+			req.Targets = targets
 		}
-		elf.size()
+		ret = elf.size(req)
 	case "upload":
-		v, dirpath := elf.upload()
-		files := req.MultipartForm.File["upload[]"]
-		added := make([]FileDir, 0, len(files))
-		errs := make([]string, 0, len(files))
-		if len(errs) == 0 {
-			errs = append(errs, errUploadFile)
-		}
-		if elf.req.Cid != 0 && elf.req.Chunk != "" {
-			re, err := regexp.Compile(`(.*?)\.([0-9][0-9]*?_[0-9][0-9]*?)(\.part)`)
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			ch := re.FindStringSubmatch(elf.req.Chunk)
-			if len(ch) != 4 {
-				errs = append(errs, err.Error())
-				break
-			}
-			t := strings.Split(ch[2], "_")
-			currentPart, err := strconv.Atoi(t[0])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			totalPart, err := strconv.Atoi(t[1])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			rangeData := strings.Split(elf.req.Range, ",")
-			if len(rangeData) != 3 {
-				errs = append(errs, "errs range data")
-				break
-			}
-			offSet, err := strconv.Atoi(rangeData[0])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			chunkLength, err := strconv.Atoi(rangeData[1])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			totalSize, err := strconv.Atoi(rangeData[2])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			filename := ch[1]
-			for i, uploadFile := range files {
-				f, err := uploadFile.Open()
-				if err != nil {
-					errs = append(errs, err.Error())
-					continue
-				}
-				data := ChunkRange{Offset: int64(offSet), Length: int64(chunkLength), TotalSize: int64(totalSize)}
-				uploadPath := ""
-				if len(elf.req.UploadPath) == len(files) && elf.req.UploadPath[i] != elf.req.Target {
-					uploadPath = elf.req.UploadPath[i]
-				}
-				err = v.UploadChunk(elf.req.Cid, dirpath, uploadPath, filename, data, f)
-				if err != nil {
-					errs = append(errs, err.Error())
-				}
-				_ = f.Close()
-			}
-			if currentPart == totalPart {
-				elf.res.Chunkmerged = fmt.Sprintf("%d_%d_%s", elf.req.Cid, totalPart, filename)
-				elf.res.Name = filename
-			}
-		} else if elf.req.Chunk != "" {
-			// Chunk merge request
-			re, err := regexp.Compile(`([0-9]*)_([0-9]*)_(.*)`)
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			ch := re.FindStringSubmatch(elf.req.Chunk)
-			if len(ch) != 4 {
-				errs = append(errs, err.Error())
-				break
-			}
-			var uploadPath string
-			if len(elf.req.UploadPath) == 1 && elf.req.UploadPath[0] != elf.req.Target {
-				uploadPath = elf.req.UploadPath[0]
-			}
-
-			cid, _ := strconv.Atoi(ch[1])
-			total, _ := strconv.Atoi(ch[2])
-			result, err := v.MergeChunk(cid, total, dirpath, uploadPath, ch[3])
-			if err != nil {
-				errs = append(errs, err.Error())
-				break
-			}
-			added = append(added, result)
-		} else {
-			for i, uploadFile := range files {
-				f, err := uploadFile.Open()
-				uploadPath := ""
-				if len(elf.req.UploadPath) == len(files) && elf.req.UploadPath[i] != elf.req.Target {
-					uploadPath = elf.req.UploadPath[i]
-				}
-				result, err := v.UploadFile(dirpath, uploadPath, uploadFile.Filename, f)
-				if err != nil {
-					errs = append(errs, err.Error())
-					continue
-				}
-				added = append(added, result)
-			}
-
-		}
-		if len(errs) > 1 {
-			elf.res.Warning = errs
-		}
-		elf.res.Added = added
+		ret = elf.upload(r, req)
 	case "zipdl":
-		switch elf.req.Download {
+		switch req.Download {
 		case "1":
 			var fileKey string
 			var filename string
 			var mimetype string
-			if len(elf.req.Targets) == 4 {
-				fileKey = elf.req.Targets[1]
-				filename = elf.req.Targets[2]
-				mimetype = elf.req.Targets[3]
+			if len(req.Targets) == 4 {
+				fileKey = req.Targets[1]
+				filename = req.Targets[2]
+				mimetype = req.Targets[3]
 			}
-			var ret ElfResponse
 			if zipTmpPath, ok := getTmpFilePath(fileKey); ok {
 				zipFd, err := os.Open(zipTmpPath)
 				if err == nil {
@@ -739,22 +724,21 @@ func (elf *ElFinderConnector) dispatch(rw http.ResponseWriter, req *http.Request
 				log.Println("zip download errs: ", err.Error())
 				ret.Error = err
 			}
-			elf.res = &ret
 		default:
-			elf.zipdl()
+			ret = elf.zipdl(req)
 		}
 	case "abort":
 		rw.WriteHeader(http.StatusNoContent)
 		return
 	case "search":
-		elf.search()
+		ret = elf.search(req)
 	case "duplicate":
-		elf.duplicate()
+		ret = elf.duplicate(req)
 	default:
-		elf.res.Error = errUnknownCmd
+		ret.Error = errUnknownCmd
 	}
 	rw.Header().Set("Content-Type", "application/json")
-	data, err := json.Marshal(elf.res)
+	data, err := json.Marshal(ret)
 	if err != nil {
 		log.Println("elf Marshal errs:", err.Error())
 	}
@@ -776,6 +760,18 @@ func (elf *ElFinderConnector) getVolume(vid string) Volume {
 
 }
 
+// resolveTarget is the single entry for client supplied hashes: it picks the
+// volume and returns the cleaned path, which the volume confines to its root.
+func (elf *ElFinderConnector) resolveTarget(target string) (Volume, string, error) {
+	IDAndTarget := strings.SplitN(target, "_", 2)
+	v := elf.getVolume(IDAndTarget[0])
+	if len(IDAndTarget) == 1 {
+		return v, "/", nil
+	}
+	path, err := elf.parseTarget(IDAndTarget[1])
+	return v, path, err
+}
+
 func (elf *ElFinderConnector) parseTarget(target string) (path string, err error) {
 	if target == "" || target == "/" {
 		return "/", nil
@@ -784,20 +780,20 @@ func (elf *ElFinderConnector) parseTarget(target string) (path string, err error
 	if err != nil {
 		return "", err
 	}
-	return path, nil
+	if !filepath.IsAbs(path) || strings.ContainsRune(path, 0) {
+		return "", utils.ErrOutsideRoot
+	}
+	return filepath.Clean(path), nil
 }
 
-func (elf *ElFinderConnector) zipdl() {
-	var ret ElfResponse
+func (elf *ElFinderConnector) zipdl(req *ELFRequest) (ret ElfResponse) {
 	var zipWriter *zip.Writer
 	var totalZipSize int64
 
-	zipVs := make([]Volume, 0, len(elf.req.Targets))
-	zipPaths := make([]string, 0, len(elf.req.Targets))
-	for _, target := range elf.req.Targets {
-		IDAndTarget := strings.Split(target, "_")
-		v := elf.getVolume(IDAndTarget[0])
-		path, err := elf.parseTarget(strings.Join(IDAndTarget[1:], "_"))
+	zipVs := make([]Volume, 0, len(req.Targets))
+	zipPaths := make([]string, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		v, path, err := elf.resolveTarget(target)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -819,28 +815,28 @@ func (elf *ElFinderConnector) zipdl() {
 		}
 	}
 
-	if elf.zipMaxSize == 0 {
-		elf.zipMaxSize = int64(defaultZipMaxSize)
+	zipMaxSize := elf.zipMaxSize
+	if zipMaxSize == 0 {
+		zipMaxSize = int64(defaultZipMaxSize)
 	}
-	if totalZipSize >= elf.zipMaxSize {
+	if totalZipSize >= zipMaxSize {
 		ret.Error = errArcMaxSize
-		elf.res = &ret
 		return
 	}
 
 	zipRes := make(map[string]string)
-	zipFileKey := GenerateTargetsMD5Key(elf.req.Targets...)
-	if elf.zipTmpPath == "" {
-		elf.zipTmpPath = defaultTmpPath
+	zipFileKey := GenerateTargetsMD5Key(req.Targets...)
+	zipTmpDir := elf.zipTmpPath
+	if zipTmpDir == "" {
+		zipTmpDir = defaultTmpPath
 	}
 	filename := fmt.Sprintf("%s%s.zip",
 		time.Now().UTC().Format("20060102150405"), zipFileKey)
-	zipTmpPath := filepath.Join(elf.zipTmpPath, filename)
+	zipTmpPath := filepath.Join(zipTmpDir, filename)
 	dstFd, err := os.Create(zipTmpPath)
 	if err != nil {
 		log.Println("create tmp zip file errs: ", err)
 		ret.Error = err.Error()
-		elf.res = &ret
 		return
 	}
 
@@ -899,8 +895,7 @@ func (elf *ElFinderConnector) zipdl() {
 	zipRes["name"] = filename
 	ret.Zipdl = zipRes
 endErr:
-	elf.res = &ret
-
+	return
 }
 
 func GenerateTargetsMD5Key(targets ...string) string {
@@ -0,0 +1,121 @@
+package elfinder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/utils"
+)
+
+func newTestConnector(t *testing.T) (*ElFinderConnector, *LocalFileVolume, string) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "readme.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vol := NewLocalVolume(root)
+	return NewElFinderConnector(Volumes{vol}), vol, root
+}
+
+func serve(t *testing.T, elf *ElFinderConnector, req *http.Request) ElfResponse {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	elf.ServeHTTP(rw, req)
+	var res ElfResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Errorf("decode %s: %s", rw.Body.String(), err)
+	}
+	return res
+}
+
+func get(t *testing.T, elf *ElFinderConnector, q url.Values) ElfResponse {
+	t.Helper()
+	return serve(t, elf, httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil))
+}
+
+func upload(t *testing.T, elf *ElFinderConnector, target, name string, content []byte) ElfResponse {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	_ = w.WriteField("cmd", "upload")
+	_ = w.WriteField("target", target)
+	fw, err := w.CreateFormFile("upload[]", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fw.Write(content)
+	_ = w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return serve(t, elf, req)
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	elf, vol, root := newTestConnector(t)
+	rootHash := vol.RootFileDir().Hash
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("file%d.txt", i)
+			content := []byte(fmt.Sprintf("content %d", i))
+
+			res := get(t, elf, url.Values{"cmd": {"open"}, "target": {rootHash}, "init": {"1"}})
+			if res.Error != nil || res.Cwd.Hash == "" || res.Added != nil {
+				t.Errorf("open: %+v", res)
+			}
+			res = upload(t, elf, rootHash, name, content)
+			if res.Error != nil || len(res.Added) != 1 || res.Added[0].Name != name {
+				t.Errorf("upload %s: %+v", name, res)
+				return
+			}
+			fileHash := res.Added[0].Hash
+			res = get(t, elf, url.Values{"cmd": {"ls"}, "target": {rootHash}})
+			if res.Error != nil || res.Added != nil || len(res.List) == 0 {
+				t.Errorf("ls: %+v", res)
+			}
+			res = get(t, elf, url.Values{"cmd": {"rm"}, "targets[]": {fileHash}})
+			if res.Error != nil || len(res.Removed) != 1 || res.Removed[0] != fileHash || res.Added != nil {
+				t.Errorf("rm %s: %+v", name, res)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "readme.txt" {
+		t.Errorf("left over entries: %v", entries)
+	}
+}
+
+func TestResponsesDoNotLeak(t *testing.T) {
+	elf, vol, _ := newTestConnector(t)
+	rootHash := vol.RootFileDir().Hash
+
+	res := get(t, elf, url.Values{"cmd": {"mkfile"}, "target": {rootHash}, "name": {"new.txt"}})
+	if res.Error != nil || len(res.Added) != 1 {
+		t.Fatalf("mkfile: %+v", res)
+	}
+	res = get(t, elf, url.Values{"cmd": {"open"}, "target": {utils.CreateHash(vol.ID(), "/etc")}})
+	if res.Error == nil {
+		t.Fatalf("open outside of the volume: %+v", res)
+	}
+	res = get(t, elf, url.Values{"cmd": {"ls"}, "target": {rootHash}})
+	if res.Error != nil || res.Added != nil {
+		t.Errorf("ls after mkfile and a failed open: %+v", res)
+	}
+}
@@ -1,7 +1,19 @@
 module github.com/LeeEirc/elfinder
 
-go 1.17
+go 1.18
 
-require github.com/go-playground/form v3.1.4+incompatible
+require (
+	github.com/go-playground/form v3.1.4+incompatible
+	github.com/jlaffaye/ftp v0.2.0
+	github.com/pkg/sftp v1.13.7
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.10.0
+)
 
-require gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
+)
@@ -20,6 +20,8 @@ type FileInfo struct {
 	Volumeid   string  `json:"volumeid"` // Volume id. For directory only.
 	Options    *Option `json:"options,omitempty"`
 	Isroot     int     `json:"isroot"`
+	ETag       string  `json:"etag,omitempty"`   // entity tag of object storages. Optionally.
+	NetKey     string  `json:"netkey,omitempty"` // key of a network volume root for netunmount. Optionally.
 }
 
 /*
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrOutsideRoot = errors.New("path outside of root")
+
+// ConfinePath returns the cleaned absolute form of name, which is either an
+// absolute path or a path relative to root. It fails with ErrOutsideRoot if
+// the result leaves root, lexically or through symlinks of its existing
+// parts.
+func ConfinePath(root, name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", ErrOutsideRoot
+	}
+	root = filepath.Clean(root)
+	absPath := name
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(root, absPath)
+	}
+	absPath = filepath.Clean(absPath)
+	if !isSubPath(root, absPath) {
+		return "", ErrOutsideRoot
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	// the last elements may not exist yet, e.g. for mkdir, check the
+	// longest existing prefix
+	existPath := absPath
+	for {
+		realPath, err := filepath.EvalSymlinks(existPath)
+		if err == nil {
+			if !isSubPath(realRoot, realPath) {
+				return "", ErrOutsideRoot
+			}
+			return absPath, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(existPath); err == nil {
+			// a dangling symlink, its target cannot be checked
+			return "", ErrOutsideRoot
+		}
+		parent := filepath.Dir(existPath)
+		if parent == existPath {
+			return "", err
+		}
+		existPath = parent
+	}
+}
+
+func isSubPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,11 +1,13 @@
 package elfinder
 
 import (
+	"fmt"
 	"github.com/LeeEirc/elfinder/utils"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -47,10 +49,19 @@ func (f *LocalFileVolume) ID() string {
 	return f.Id
 }
 
+// realPath confines path to the volume, "" and "/" mean the volume root.
+func (f *LocalFileVolume) realPath(path string) (string, error) {
+	if path == "" || path == "/" {
+		return f.basePath, nil
+	}
+	return utils.ConfinePath(f.basePath, path)
+}
+
 func (f *LocalFileVolume) Info(path string) (FileDir, error) {
 	var resFDir FileDir
-	if path == "" || path == "/" {
-		path = f.basePath
+	path, err := f.realPath(path)
+	if err != nil {
+		return resFDir, err
 	}
 	dirPath := filepath.Dir(path)
 	if path != f.basePath {
@@ -79,8 +90,9 @@ func (f *LocalFileVolume) Info(path string) (FileDir, error) {
 }
 
 func (f *LocalFileVolume) List(path string) []FileDir {
-	if path == "" || path == "/" {
-		path = f.basePath
+	path, err := f.realPath(path)
+	if err != nil {
+		return []FileDir{}
 	}
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
@@ -89,6 +101,9 @@ func (f *LocalFileVolume) List(path string) []FileDir {
 	fileDir := make([]FileDir, 0, len(files))
 
 	for _, item := range files {
+		if isChunkTmpName(item.Name()) {
+			continue
+		}
 		fileD, err := f.Info(filepath.Join(path, item.Name()))
 		if err != nil {
 			continue
@@ -100,6 +115,10 @@ func (f *LocalFileVolume) List(path string) []FileDir {
 }
 
 func (f *LocalFileVolume) Parents(path string, dep int) []FileDir {
+	path, err := f.realPath(path)
+	if err != nil {
+		return []FileDir{}
+	}
 	relativepath := strings.TrimPrefix(strings.TrimPrefix(path, f.basePath), "/")
 	relativePaths := strings.Split(relativepath, "/")
 	dirs := make([]FileDir, 0, len(relativePaths))
@@ -121,6 +140,10 @@ func (f *LocalFileVolume) Parents(path string, dep int) []FileDir {
 }
 
 func (f *LocalFileVolume) GetFile(path string) (reader io.ReadCloser, err error) {
+	path, err = f.realPath(path)
+	if err != nil {
+		return nil, err
+	}
 	freader, err := os.Open(path)
 	return freader, err
 }
@@ -136,6 +159,10 @@ func (f *LocalFileVolume) UploadFile(dirPath, uploadPath, filename string, reade
 		realPath = filepath.Join(dirPath, filename)
 
 	}
+	realPath, err := f.realPath(realPath)
+	if err != nil {
+		return FileDir{}, err
+	}
 	fwriter, err := os.OpenFile(realPath, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return FileDir{}, err
@@ -157,7 +184,13 @@ func (f *LocalFileVolume) UploadChunk(cid int, dirPath, uploadPath, filename str
 	default:
 		chunkpath = filepath.Join(dirPath, filename)
 	}
-	fd, err := os.OpenFile(chunkpath, os.O_WRONLY|os.O_CREATE, 0666)
+	chunkpath, err := f.realPath(chunkpath)
+	if err != nil {
+		return err
+	}
+	// chunks are staged in a hidden file so an interrupted upload never
+	// leaves a partial file under the real name
+	fd, err := os.OpenFile(chunkTmpPath(chunkpath, cid), os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
@@ -180,16 +213,45 @@ func (f *LocalFileVolume) MergeChunk(cid, total int, dirPath, uploadPath, filena
 	default:
 		realPath = filepath.Join(dirPath, filename)
 	}
+	realPath, err := f.realPath(realPath)
+	if err != nil {
+		return FileDir{}, err
+	}
+	if err := os.Rename(chunkTmpPath(realPath, cid), realPath); err != nil {
+		return FileDir{}, err
+	}
 	return f.Info(realPath)
 }
 
+func chunkTmpPath(realPath string, cid int) string {
+	return filepath.Join(filepath.Dir(realPath), fmt.Sprintf(".%s.%d.part", filepath.Base(realPath), cid))
+}
+
+// isChunkTmpName reports whether name is a file chunkTmpPath stages an upload
+// in, List and Search leave them out.
+func isChunkTmpName(name string) bool {
+	if !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".part") {
+		return false
+	}
+	rest := strings.TrimSuffix(name[1:], ".part")
+	i := strings.LastIndexByte(rest, '.')
+	if i <= 0 {
+		return false
+	}
+	_, err := strconv.Atoi(rest[i+1:])
+	return err == nil
+}
+
 func (f *LocalFileVolume) hash(path string) string {
 	return utils.CreateHash(f.Id, path)
 }
 
 func (f *LocalFileVolume) MakeDir(dir, newDirname string) (FileDir, error) {
-	realPath := filepath.Join(dir, newDirname)
-	err := os.Mkdir(realPath, os.ModePerm)
+	realPath, err := f.realPath(filepath.Join(dir, newDirname))
+	if err != nil {
+		return FileDir{}, err
+	}
+	err = os.Mkdir(realPath, os.ModePerm)
 	if err != nil {
 		return FileDir{}, err
 	}
@@ -198,7 +260,10 @@ func (f *LocalFileVolume) MakeDir(dir, newDirname string) (FileDir, error) {
 
 func (f *LocalFileVolume) MakeFile(dir, newFilename string) (FileDir, error) {
 	var res FileDir
-	realPath := filepath.Join(dir, newFilename)
+	realPath, err := f.realPath(filepath.Join(dir, newFilename))
+	if err != nil {
+		return res, err
+	}
 	fd, err := os.Create(realPath)
 	if err != nil {
 		return res, err
@@ -221,9 +286,19 @@ func (f *LocalFileVolume) MakeFile(dir, newFilename string) (FileDir, error) {
 
 func (f *LocalFileVolume) Rename(oldNamePath, newName string) (FileDir, error) {
 	var res FileDir
+	oldNamePath, err := f.realPath(oldNamePath)
+	if err != nil {
+		return res, err
+	}
+	if oldNamePath == f.basePath {
+		return res, os.ErrPermission
+	}
 	dirname := filepath.Dir(oldNamePath)
-	realNewNamePath := filepath.Join(dirname, newName)
-	err := os.Rename(oldNamePath, realNewNamePath)
+	realNewNamePath, err := f.realPath(filepath.Join(dirname, newName))
+	if err != nil {
+		return res, err
+	}
+	err = os.Rename(oldNamePath, realNewNamePath)
 	if err != nil {
 		return res, err
 	}
@@ -231,16 +306,27 @@ func (f *LocalFileVolume) Rename(oldNamePath, newName string) (FileDir, error) {
 }
 
 func (f *LocalFileVolume) Remove(path string) error {
+	path, err := f.realPath(path)
+	if err != nil {
+		return err
+	}
+	if path == f.basePath {
+		return os.ErrPermission
+	}
 	return os.RemoveAll(path)
 }
 
 func (f *LocalFileVolume) Paste(dir, filename, suffix string, reader io.ReadCloser) (FileDir, error) {
 	defer reader.Close()
 	res := FileDir{}
-	realpath := filepath.Join(dir, filename)
-	_, err := f.Info(realpath)
-	if err == nil {
-		realpath += suffix
+	realpath, err := f.realPath(filepath.Join(dir, filename))
+	if err != nil {
+		return res, err
+	}
+	if _, err = f.Info(realpath); err == nil {
+		if realpath, err = f.realPath(realpath + suffix); err != nil {
+			return res, err
+		}
 	}
 	dstFd, err := os.Create(realpath)
 	if err != nil {
@@ -268,8 +354,11 @@ func (f *LocalFileVolume) RootFileDir() FileDir {
 }
 
 func (f *LocalFileVolume) Search(path, key string, mimes ...string) (files []FileDir, err error) {
+	if path, err = f.realPath(path); err != nil {
+		return nil, err
+	}
 	err = filepath.Walk(path, func(dirPath string, info os.FileInfo, err error) error {
-		if strings.Contains(info.Name(), key) {
+		if strings.Contains(info.Name(), key) && !isChunkTmpName(info.Name()) {
 			resFDir := FileDir{}
 			resFDir.Name = info.Name()
 			resFDir.Hash = f.hash(dirPath)
@@ -0,0 +1,709 @@
+package elfinder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeEirc/elfinder/utils"
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// ErrChunkIncomplete is returned by MergeChunk of FromFsVolume volumes when a
+// chunk of the upload is missing.
+var ErrChunkIncomplete = errors.New("chunk upload incomplete")
+
+// FromFsVolume serves vol through the Volume interface, e.g. to mount a
+// volumes.FsVolume in ElFinderConnector. Paths are absolute with "/" as the
+// root of vol.
+func FromFsVolume(vol volumes.FsVolume) Volume {
+	return &fsVolumeAdapter{vol: vol, id: utils.GenerateID(vol.Name())}
+}
+
+type fsVolumeAdapter struct {
+	vol volumes.FsVolume
+	id  string
+}
+
+func (a *fsVolumeAdapter) ID() string {
+	return a.id
+}
+
+// relPath converts a Volume path to the name used by the FsVolume.
+func (a *fsVolumeAdapter) relPath(p string) (string, error) {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	rel := strings.TrimPrefix(path.Clean(p), "/")
+	if rel == "" {
+		return ".", nil
+	}
+	if !fs.ValidPath(rel) {
+		return "", utils.ErrOutsideRoot
+	}
+	return rel, nil
+}
+
+func (a *fsVolumeAdapter) hash(rel string) string {
+	if rel == "." {
+		return utils.CreateHash(a.id, "/")
+	}
+	return utils.CreateHash(a.id, "/"+rel)
+}
+
+func (a *fsVolumeAdapter) info(rel string) (FileDir, error) {
+	var resFDir FileDir
+	info, err := fs.Stat(a.vol, rel)
+	if err != nil {
+		return resFDir, err
+	}
+	resFDir.Name = info.Name()
+	if rel == "." {
+		resFDir.Name = a.vol.Name()
+	} else {
+		resFDir.Phash = a.hash(path.Dir(rel))
+	}
+	resFDir.Hash = a.hash(rel)
+	resFDir.Ts = info.ModTime().Unix()
+	resFDir.Size = info.Size()
+	resFDir.Read, resFDir.Write = utils.ReadWritePem(info.Mode())
+	if info.IsDir() {
+		resFDir.Mime = "directory"
+		resFDir.Dirs = 1
+	} else {
+		resFDir.Mime = "file"
+	}
+	return resFDir, nil
+}
+
+func (a *fsVolumeAdapter) Info(p string) (FileDir, error) {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return FileDir{}, err
+	}
+	return a.info(rel)
+}
+
+func (a *fsVolumeAdapter) list(rel string) []FileDir {
+	entries, err := a.vol.ReadDir(rel)
+	if err != nil {
+		return []FileDir{}
+	}
+	fileDir := make([]FileDir, 0, len(entries))
+	for i := range entries {
+		fileD, err := a.info(path.Join(rel, entries[i].Name()))
+		if err != nil {
+			continue
+		}
+		fileDir = append(fileDir, fileD)
+	}
+	return fileDir
+}
+
+func (a *fsVolumeAdapter) List(p string) []FileDir {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return []FileDir{}
+	}
+	return a.list(rel)
+}
+
+func (a *fsVolumeAdapter) Parents(p string, dep int) []FileDir {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return []FileDir{}
+	}
+	dirRels := []string{"."}
+	if rel != "." {
+		elems := strings.Split(rel, "/")
+		for i := 1; i < len(elems); i++ {
+			dirRels = append(dirRels, path.Join(elems[:i]...))
+		}
+	}
+	dirs := make([]FileDir, 0, len(dirRels))
+	for _, dirRel := range dirRels {
+		result, err := a.info(dirRel)
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, result)
+		for _, item := range a.list(dirRel) {
+			if item.Dirs == 1 {
+				dirs = append(dirs, item)
+			}
+		}
+	}
+	return dirs
+}
+
+func (a *fsVolumeAdapter) GetFile(p string) (io.ReadCloser, error) {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return a.vol.Open(rel)
+}
+
+// uploadTarget joins the upload destination the same way LocalFileVolume
+// does, uploadPath is set for folder uploads.
+func uploadTarget(dirPath, uploadPath, filename string) string {
+	switch {
+	case strings.Contains(uploadPath, filename):
+		return filepath.Join(dirPath, strings.TrimPrefix(uploadPath, "/"))
+	case uploadPath != "":
+		return filepath.Join(dirPath, strings.TrimPrefix(uploadPath, "/"), filename)
+	}
+	return filepath.Join(dirPath, filename)
+}
+
+func (a *fsVolumeAdapter) write(rel string, reader io.Reader) error {
+	writer, err := a.vol.Create(rel)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (a *fsVolumeAdapter) UploadFile(dirPath, uploadPath, filename string, reader io.Reader) (FileDir, error) {
+	rel, err := a.relPath(uploadTarget(dirPath, uploadPath, filename))
+	if err != nil {
+		return FileDir{}, err
+	}
+	if err = a.write(rel, reader); err != nil {
+		return FileDir{}, err
+	}
+	return a.info(rel)
+}
+
+// chunkPartPrefix is the name prefix of the chunks of rel, every chunk is
+// staged in its own hidden file named after its offset.
+func chunkPartPrefix(rel string, cid int) string {
+	return fmt.Sprintf(".%s.%d.", path.Base(rel), cid)
+}
+
+func (a *fsVolumeAdapter) UploadChunk(cid int, dirPath, uploadPath, filename string, rangeData ChunkRange, reader io.Reader) error {
+	rel, err := a.relPath(uploadTarget(dirPath, uploadPath, filename))
+	if err != nil {
+		return err
+	}
+	partName := fmt.Sprintf("%s%d.part", chunkPartPrefix(rel, cid), rangeData.Offset)
+	return a.write(path.Join(path.Dir(rel), partName), reader)
+}
+
+func (a *fsVolumeAdapter) MergeChunk(cid, total int, dirPath, uploadPath, filename string) (FileDir, error) {
+	rel, err := a.relPath(uploadTarget(dirPath, uploadPath, filename))
+	if err != nil {
+		return FileDir{}, err
+	}
+	dirRel := path.Dir(rel)
+	entries, err := a.vol.ReadDir(dirRel)
+	if err != nil {
+		return FileDir{}, err
+	}
+	prefix := chunkPartPrefix(rel, cid)
+	offsets := make(map[int64]string)
+	for i := range entries {
+		name := entries[i].Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".part") {
+			continue
+		}
+		offset, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".part"), 10, 64)
+		if err == nil {
+			offsets[offset] = path.Join(dirRel, name)
+		}
+	}
+	if len(offsets) == 0 {
+		return FileDir{}, fmt.Errorf("%w: chunks of %s", fs.ErrNotExist, filename)
+	}
+	// total is the index of the last chunk, "name.N_M.part" carries M
+	if len(offsets) != total+1 {
+		return FileDir{}, fmt.Errorf("%w: %d of %d chunks of %s", ErrChunkIncomplete, len(offsets), total+1, filename)
+	}
+	parts := make([]int64, 0, len(offsets))
+	for offset := range offsets {
+		parts = append(parts, offset)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+	readers := make([]io.Reader, 0, len(parts))
+	var end int64
+	for _, offset := range parts {
+		if offset != end {
+			return FileDir{}, fmt.Errorf("%w: %s misses bytes %d-%d", ErrChunkIncomplete, filename, end, offset)
+		}
+		f, err := a.vol.Open(offsets[offset])
+		if err != nil {
+			return FileDir{}, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return FileDir{}, err
+		}
+		end = offset + info.Size()
+		readers = append(readers, f)
+	}
+	if err = a.write(rel, io.MultiReader(readers...)); err != nil {
+		return FileDir{}, err
+	}
+	for _, offset := range parts {
+		_ = a.vol.Remove(offsets[offset])
+	}
+	return a.info(rel)
+}
+
+func (a *fsVolumeAdapter) MakeDir(dir, newDirname string) (FileDir, error) {
+	rel, err := a.relPath(filepath.Join(dir, newDirname))
+	if err != nil {
+		return FileDir{}, err
+	}
+	if err = a.vol.Mkdir(rel); err != nil {
+		return FileDir{}, err
+	}
+	return a.info(rel)
+}
+
+func (a *fsVolumeAdapter) MakeFile(dir, newFilename string) (FileDir, error) {
+	rel, err := a.relPath(filepath.Join(dir, newFilename))
+	if err != nil {
+		return FileDir{}, err
+	}
+	if err = a.write(rel, bytes.NewReader(nil)); err != nil {
+		return FileDir{}, err
+	}
+	return a.info(rel)
+}
+
+func (a *fsVolumeAdapter) Rename(oldNamePath, newName string) (FileDir, error) {
+	rel, err := a.relPath(oldNamePath)
+	if err != nil {
+		return FileDir{}, err
+	}
+	if rel == "." {
+		return FileDir{}, fs.ErrPermission
+	}
+	newRel, err := a.relPath(filepath.Join(path.Dir("/"+rel), newName))
+	if err != nil {
+		return FileDir{}, err
+	}
+	if err = a.vol.Rename(rel, newRel); err != nil {
+		return FileDir{}, err
+	}
+	return a.info(newRel)
+}
+
+func (a *fsVolumeAdapter) Remove(p string) error {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return fs.ErrPermission
+	}
+	return removeAll(a.vol, rel)
+}
+
+// removeAll removes rel and its children, Volume.Remove is recursive while
+// FsVolume.Remove is not.
+func removeAll(vol volumes.FsVolume, rel string) error {
+	info, err := fs.Stat(vol, rel)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := vol.ReadDir(rel)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if err = removeAll(vol, path.Join(rel, entries[i].Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return vol.Remove(rel)
+}
+
+func (a *fsVolumeAdapter) Paste(dir, filename, suffix string, reader io.ReadCloser) (FileDir, error) {
+	defer reader.Close()
+	rel, err := a.relPath(filepath.Join(dir, filename))
+	if err != nil {
+		return FileDir{}, err
+	}
+	if _, err = fs.Stat(a.vol, rel); err == nil {
+		rel += suffix
+	}
+	if err = a.write(rel, reader); err != nil {
+		return FileDir{}, err
+	}
+	return a.info(rel)
+}
+
+func (a *fsVolumeAdapter) RootFileDir() FileDir {
+	resFDir, _ := a.info(".")
+	resFDir.Mime = "directory"
+	resFDir.Volumeid = a.id
+	resFDir.Dirs = 1
+	resFDir.Locked = 1
+	return resFDir
+}
+
+func (a *fsVolumeAdapter) Search(p, key string, mimes ...string) (files []FileDir, err error) {
+	rel, err := a.relPath(p)
+	if err != nil {
+		return nil, err
+	}
+	err = fs.WalkDir(a.vol, rel, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !strings.Contains(d.Name(), key) {
+			return nil
+		}
+		resFDir, err := a.info(name)
+		if err != nil {
+			return nil
+		}
+		resFDir.Volumeid = a.id
+		files = append(files, resFDir)
+		return nil
+	})
+	return files, err
+}
+
+// ToFsVolume serves v through volumes.FsVolume, e.g. to mount a custom Volume
+// in connection.Connector. The root of the returned volume is the root folder
+// of v, its name the name of that folder.
+func ToFsVolume(v Volume) (volumes.FsVolume, error) {
+	root := v.RootFileDir()
+	ret := strings.SplitN(root.Hash, "_", 2)
+	if len(ret) != 2 {
+		return nil, fmt.Errorf("%w: root hash %q of volume %s", fs.ErrInvalid, root.Hash, v.ID())
+	}
+	rootPath, err := utils.Decode64(ret[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: root hash %q of volume %s", fs.ErrInvalid, root.Hash, v.ID())
+	}
+	return &volumeFsAdapter{v: v, name: root.Name, root: rootPath}, nil
+}
+
+type volumeFsAdapter struct {
+	v    Volume
+	name string
+	root string
+}
+
+var _ fs.StatFS = (*volumeFsAdapter)(nil)
+
+func (a *volumeFsAdapter) Name() string {
+	return a.name
+}
+
+// volPath converts an FsVolume name to the path used by the Volume.
+func (a *volumeFsAdapter) volPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return a.root, nil
+	}
+	return filepath.Join(a.root, filepath.FromSlash(name)), nil
+}
+
+func (a *volumeFsAdapter) stat(op, name string) (string, FileDir, error) {
+	p, err := a.volPath(op, name)
+	if err != nil {
+		return "", FileDir{}, err
+	}
+	info, err := a.v.Info(p)
+	if err != nil {
+		return "", FileDir{}, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return p, info, nil
+}
+
+func (a *volumeFsAdapter) Stat(name string) (fs.FileInfo, error) {
+	_, info, err := a.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileDirInfo{info}, nil
+}
+
+func (a *volumeFsAdapter) Open(name string) (fs.File, error) {
+	p, info, err := a.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mime == "directory" {
+		return &fileDirDir{info: fileDirInfo{info}, entries: a.entries(p)}, nil
+	}
+	reader, err := a.v.GetFile(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fileDirFile{info: fileDirInfo{info}, ReadCloser: reader}, nil
+}
+
+func (a *volumeFsAdapter) entries(p string) []fs.DirEntry {
+	list := a.v.List(p)
+	entries := make([]fs.DirEntry, 0, len(list))
+	for i := range list {
+		entries = append(entries, fs.FileInfoToDirEntry(fileDirInfo{list[i]}))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+func (a *volumeFsAdapter) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, info, err := a.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mime != "directory" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return a.entries(p), nil
+}
+
+// Create streams the content to Volume.UploadFile under a temporary name,
+// which replaces name only when Close succeeds. Volume.UploadFile may not
+// truncate an existing file, so it is never written in place.
+func (a *volumeFsAdapter) Create(name string) (io.ReadWriteCloser, error) {
+	p, err := a.volPath("create", name)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := filepath.Dir(p)
+	if dirInfo, err := a.v.Info(dirPath); err != nil || dirInfo.Mime != "directory" {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+	if info, err := a.v.Info(p); err == nil && info.Mime == "directory" {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	tmpBase := fmt.Sprintf(".%s.%s.upload", filepath.Base(p), strconv.FormatInt(time.Now().UnixNano(), 36))
+	tmpName := path.Join(path.Dir(name), tmpBase)
+	pr, pw := io.Pipe()
+	w := &fileDirWriter{PipeWriter: pw, done: make(chan error, 1)}
+	w.finish = func(err error) error {
+		if err == nil {
+			err = a.Rename(tmpName, name)
+		}
+		if err != nil {
+			_ = a.v.Remove(filepath.Join(dirPath, tmpBase))
+		}
+		return err
+	}
+	go func() {
+		_, err := a.v.UploadFile(dirPath, "", tmpBase, pr)
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (a *volumeFsAdapter) Mkdir(name string) error {
+	p, err := a.volPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err = a.v.Info(p); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if dirInfo, err := a.v.Info(filepath.Dir(p)); err != nil || dirInfo.Mime != "directory" {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if _, err = a.v.MakeDir(filepath.Dir(p), filepath.Base(p)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Remove refuses non-empty folders, Volume.Remove would remove them with
+// their content.
+func (a *volumeFsAdapter) Remove(name string) error {
+	p, info, err := a.stat("remove", name)
+	if err != nil {
+		return err
+	}
+	if p == a.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if info.Mime == "directory" && len(a.v.List(p)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: volumes.ErrDirNotEmpty}
+	}
+	if err = a.v.Remove(p); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename uses Volume.Rename within a folder, moves into another folder copy
+// the entry and remove the source since Volume cannot move.
+func (a *volumeFsAdapter) Rename(old, new string) error {
+	oldPath, info, err := a.stat("rename", old)
+	if err != nil {
+		return err
+	}
+	newPath, err := a.volPath("rename", new)
+	if err != nil {
+		return err
+	}
+	if oldPath == a.root || newPath == a.root {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if info.Mime == "directory" && strings.HasPrefix(newPath, oldPath+string(filepath.Separator)) {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if existing, err := a.v.Info(newPath); err == nil {
+		switch {
+		case existing.Mime == "directory" && info.Mime != "directory":
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrExist}
+		case existing.Mime != "directory" && info.Mime == "directory":
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrInvalid}
+		case existing.Mime == "directory" && len(a.v.List(newPath)) > 0:
+			return &fs.PathError{Op: "rename", Path: new, Err: volumes.ErrDirNotEmpty}
+		}
+		if err = a.v.Remove(newPath); err != nil {
+			return &fs.PathError{Op: "rename", Path: new, Err: err}
+		}
+	}
+	if dirInfo, err := a.v.Info(filepath.Dir(newPath)); err != nil || dirInfo.Mime != "directory" {
+		return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrNotExist}
+	}
+	if filepath.Dir(oldPath) == filepath.Dir(newPath) {
+		_, err = a.v.Rename(oldPath, filepath.Base(newPath))
+	} else if err = a.copy(oldPath, info, filepath.Dir(newPath), filepath.Base(newPath)); err == nil {
+		err = a.v.Remove(oldPath)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: old, Err: err}
+	}
+	return nil
+}
+
+// copy copies the entry at src described by info into the folder dstDir
+// under name.
+func (a *volumeFsAdapter) copy(src string, info FileDir, dstDir, name string) error {
+	if info.Mime != "directory" {
+		reader, err := a.v.GetFile(src)
+		if err != nil {
+			return err
+		}
+		_, err = a.v.Paste(dstDir, name, "", reader)
+		return err
+	}
+	if _, err := a.v.MakeDir(dstDir, name); err != nil {
+		return err
+	}
+	dst := filepath.Join(dstDir, name)
+	for _, child := range a.v.List(src) {
+		if err := a.copy(filepath.Join(src, child.Name), child, dst, child.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileDirInfo is the fs.FileInfo of a FileDir.
+type fileDirInfo struct {
+	fd FileDir
+}
+
+func (i fileDirInfo) Name() string       { return i.fd.Name }
+func (i fileDirInfo) Size() int64        { return i.fd.Size }
+func (i fileDirInfo) ModTime() time.Time { return time.Unix(i.fd.Ts, 0) }
+func (i fileDirInfo) IsDir() bool        { return i.fd.Mime == "directory" }
+func (i fileDirInfo) Sys() interface{}   { return i.fd }
+
+func (i fileDirInfo) Mode() fs.FileMode {
+	var mode fs.FileMode
+	if i.fd.Read == 1 {
+		mode |= 0444
+	}
+	if i.fd.Write == 1 {
+		mode |= 0200
+	}
+	if i.IsDir() {
+		mode |= fs.ModeDir
+		if i.fd.Read == 1 {
+			mode |= 0111
+		}
+	}
+	return mode
+}
+
+type fileDirFile struct {
+	info fileDirInfo
+	io.ReadCloser
+}
+
+func (f *fileDirFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+type fileDirDir struct {
+	info    fileDirInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fileDirDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fileDirDir) Close() error               { return nil }
+
+func (d *fileDirDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *fileDirDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// fileDirWriter feeds Volume.UploadFile, Close waits for the upload to end
+// and passes its result to finish.
+type fileDirWriter struct {
+	*io.PipeWriter
+	done   chan error
+	finish func(err error) error
+	closed bool
+}
+
+func (w *fileDirWriter) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *fileDirWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	_ = w.PipeWriter.Close()
+	return w.finish(<-w.done)
+}
@@ -0,0 +1,86 @@
+package elfinder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+func TestToFsVolume(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		vol, err := ToFsVolume(NewLocalVolume(t.TempDir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vol
+	})
+}
+
+func TestToFsVolumeCreateReplacesOnClose(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vol, err := ToFsVolume(NewLocalVolume(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := vol.Create("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("new content")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(vol, "a.txt"); string(data) != "old" {
+		t.Errorf("content before Close: %q", data)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(vol, "a.txt"); string(data) != "new content" {
+		t.Errorf("content after Close: %q", data)
+	}
+	entries, err := fs.ReadDir(vol, ".")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("entries after Close: %v %v", entries, err)
+	}
+}
+
+func TestFromFsVolumeMergeChunk(t *testing.T) {
+	v := FromFsVolume(volumes.NewMemory("test"))
+	upload := func(offset int64, data string) {
+		t.Helper()
+		rangeData := ChunkRange{Offset: offset, Length: int64(len(data)), TotalSize: 9}
+		if err := v.UploadChunk(1, "/", "", "a.txt", rangeData, bytes.NewReader([]byte(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload(0, "abc")
+	upload(6, "ghi")
+	if _, err := v.MergeChunk(1, 1, "/", "", "a.txt"); !errors.Is(err, ErrChunkIncomplete) {
+		t.Fatalf("merge with a gap: %v", err)
+	}
+	upload(3, "def")
+	if _, err := v.MergeChunk(1, 3, "/", "", "a.txt"); !errors.Is(err, ErrChunkIncomplete) {
+		t.Fatalf("merge with fewer chunks than announced: %v", err)
+	}
+	if _, err := v.MergeChunk(1, 2, "/", "", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := v.GetFile("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "abcdefghi" {
+		t.Errorf("merged content: %q", data)
+	}
+}
@@ -0,0 +1,53 @@
+package elfinder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fileDirNames(dirs []FileDir) string {
+	names := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		names = append(names, dir.Name)
+	}
+	return fmt.Sprint(names)
+}
+
+func TestLocalVolumeHidesChunkParts(t *testing.T) {
+	root := t.TempDir()
+	vol := NewLocalVolume(root)
+	if err := vol.UploadChunk(7, root, "", "big.bin", ChunkRange{Offset: 0, Length: 5, TotalSize: 5},
+		strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".big.bin.7.part")); err != nil {
+		t.Fatal(err)
+	}
+	if names := fileDirNames(vol.List(root)); names != "[]" {
+		t.Errorf("list during the upload: %s", names)
+	}
+	if files, err := vol.Search(root, "big"); err != nil || len(files) != 0 {
+		t.Errorf("search during the upload: %s %v", fileDirNames(files), err)
+	}
+	if _, err := vol.MergeChunk(7, 0, root, "", "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if names := fileDirNames(vol.List(root)); names != "[big.bin]" {
+		t.Errorf("list after the upload: %s", names)
+	}
+
+	for name, want := range map[string]bool{
+		".a.txt.1.part": true,
+		".a.1.part":     true,
+		".a.txt.part":   false,
+		"a.txt.1.part":  false,
+		"..1.part":      false,
+	} {
+		if got := isChunkTmpName(name); got != want {
+			t.Errorf("isChunkTmpName(%q) = %v", name, got)
+		}
+	}
+}
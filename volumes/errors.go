@@ -0,0 +1,39 @@
+package volumes
+
+import (
+	"errors"
+	"net"
+
+	"github.com/LeeEirc/elfinder/errs"
+)
+
+// typedError is a backend failure with the elFinder error it corresponds to,
+// see ErrTyper.
+type typedError struct {
+	err     error
+	errType errs.ErrType
+}
+
+func (e *typedError) Error() string         { return e.err.Error() }
+func (e *typedError) Unwrap() error         { return e.err }
+func (e *typedError) ErrType() errs.ErrType { return e.errType }
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// mapNetError types the failures to reach a remote server.
+func mapNetError(err error) error {
+	var typed *typedError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.As(err, &typed):
+		return err
+	case isTimeout(err):
+		return &typedError{err: err, errType: errs.ERRTimeout}
+	case errors.As(err, &netErr):
+		return &typedError{err: err, errType: errs.ERRConnect}
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+package volumes
+
+// SetS3CopyLimits lowers the size above which v copies objects part by
+// part, so tests need not upload 5 GiB.
+func SetS3CopyLimits(v *S3Volume, maxCopySize, copyPartSize int64) {
+	v.client.maxCopySize, v.client.copyPartSize = maxCopySize, copyPartSize
+}
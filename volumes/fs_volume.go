@@ -3,6 +3,8 @@ package volumes
 import (
 	"io"
 	"io/fs"
+
+	"github.com/LeeEirc/elfinder/errs"
 )
 
 /*
@@ -10,6 +12,13 @@ import (
 
 */
 
+// FsVolume is the storage of a volume of the connector. All paths are
+// relative fs.ValidPath names with "." as the root, invalid names fail with
+// fs.ErrInvalid and missing entries with an error matching fs.ErrNotExist.
+// Create replaces the content of an existing file, Mkdir and Remove work on a
+// single entry, Remove fails on a non-empty folder, Rename replaces an
+// existing file at new. The volumestest package checks a backend against
+// this contract.
 type FsVolume interface {
 	Name() string
 	fs.ReadDirFS
@@ -18,3 +27,23 @@ type FsVolume interface {
 	Remove(path string) error
 	Rename(old, new string) error
 }
+
+// Confiner is implemented by volumes whose backing storage may hold symlinks.
+// Confine returns an error when the relative path resolves outside of the
+// volume root.
+type Confiner interface {
+	Confine(path string) error
+}
+
+// ETagger is implemented by the fs.FileInfo of volumes that know the entity
+// tag of a file, e.g. object storages. The connector passes it to the client.
+type ETagger interface {
+	ETag() string
+}
+
+// ErrTyper is implemented by backend errors that correspond to an elFinder
+// error, e.g. errConnect for an unreachable server. The connector reports the
+// type together with the error.
+type ErrTyper interface {
+	ErrType() errs.ErrType
+}
@@ -0,0 +1,636 @@
+package volumes
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+
+	"github.com/LeeEirc/elfinder/errs"
+)
+
+const (
+	defaultFTPMaxConns    = 4
+	defaultFTPIdleTimeout = time.Minute
+	defaultFTPDialTimeout = 10 * time.Second
+	ftpDirMode            = fs.ModeDir | 0755
+	ftpFileMode           = 0644
+)
+
+var (
+	_ FsVolume  = (*FTPVolume)(nil)
+	_ fs.StatFS = (*FTPVolume)(nil)
+)
+
+// FTPConfig addresses a directory on an FTP server.
+type FTPConfig struct {
+	// Addr is the "host:port" of the server, the port defaults to 21.
+	Addr     string
+	User     string
+	Password string
+	// Root is the remote directory of the volume root, it defaults to the
+	// login directory.
+	Root string
+	// Name is the volume name, it defaults to the base name of Root.
+	Name string
+	// TLS switches to FTPS with AUTH TLS on the control connection, the
+	// data connections are protected as well.
+	TLS *tls.Config
+	// MaxConns is the number of concurrent connections, 4 by default. An
+	// FTP connection serves one transfer at a time, an open file holds its
+	// connection until it is closed.
+	MaxConns    int
+	IdleTimeout time.Duration
+	DialTimeout time.Duration
+}
+
+// FTPVolume is an FsVolume on a directory of an FTP or FTPS server. Idle
+// connections are reused until IdleTimeout, an operation failing with a lost
+// connection is retried once on a new one. Symlinks are not listed.
+type FTPVolume struct {
+	cfg  FTPConfig
+	name string
+	root string
+	sem  chan struct{}
+
+	mu   sync.Mutex
+	idle []*ftpConn
+}
+
+type ftpConn struct {
+	*ftp.ServerConn
+	lastUsed time.Time
+}
+
+func NewFTP(cfg FTPConfig) (*FTPVolume, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("%w: ftp address not set", fs.ErrInvalid)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		cfg.Addr = net.JoinHostPort(cfg.Addr, "21")
+	}
+	if cfg.User == "" {
+		cfg.User = "anonymous"
+	}
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = defaultFTPMaxConns
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultFTPIdleTimeout
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultFTPDialTimeout
+	}
+	root := path.Clean(cfg.Root)
+	name := cfg.Name
+	if name == "" {
+		name = path.Base(root)
+		if name == "." || name == "/" {
+			host, _, _ := net.SplitHostPort(cfg.Addr)
+			name = host
+		}
+	}
+	return &FTPVolume{cfg: cfg, name: name, root: root, sem: make(chan struct{}, cfg.MaxConns)}, nil
+}
+
+func (f *FTPVolume) Name() string {
+	return f.name
+}
+
+// Close logs out the idle connections, later operations reconnect.
+func (f *FTPVolume) Close() error {
+	f.mu.Lock()
+	idle := f.idle
+	f.idle = nil
+	f.mu.Unlock()
+	for i := range idle {
+		_ = idle[i].Quit()
+	}
+	return nil
+}
+
+// get waits for a free connection slot and returns an idle connection or a
+// new one. The slot is released by put.
+func (f *FTPVolume) get() (*ftpConn, error) {
+	f.sem <- struct{}{}
+	f.mu.Lock()
+	for len(f.idle) > 0 {
+		conn := f.idle[len(f.idle)-1]
+		f.idle = f.idle[:len(f.idle)-1]
+		if time.Since(conn.lastUsed) < f.cfg.IdleTimeout {
+			f.mu.Unlock()
+			return conn, nil
+		}
+		_ = conn.Quit()
+	}
+	f.mu.Unlock()
+	conn, err := f.dial()
+	if err != nil {
+		<-f.sem
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (f *FTPVolume) dial() (*ftpConn, error) {
+	opts := []ftp.DialOption{ftp.DialWithTimeout(f.cfg.DialTimeout)}
+	if f.cfg.TLS != nil {
+		opts = append(opts, ftp.DialWithExplicitTLS(f.cfg.TLS))
+	}
+	conn, err := ftp.Dial(f.cfg.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Login(f.cfg.User, f.cfg.Password); err != nil {
+		_ = conn.Quit()
+		return nil, err
+	}
+	return &ftpConn{ServerConn: conn}, nil
+}
+
+// put releases the slot of conn, a failed connection is closed instead of
+// kept for reuse.
+func (f *FTPVolume) put(conn *ftpConn, err error) {
+	defer func() { <-f.sem }()
+	if isFTPConnErr(err) {
+		_ = conn.Quit()
+		return
+	}
+	conn.lastUsed = time.Now()
+	f.mu.Lock()
+	f.idle = append(f.idle, conn)
+	f.mu.Unlock()
+}
+
+// do runs fn on a connection, it is retried once on a new connection when
+// the connection fails.
+func (f *FTPVolume) do(fn func(conn *ftpConn) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := f.get()
+		if err == nil {
+			err = fn(conn)
+			f.put(conn, err)
+		}
+		if attempt > 0 || !isFTPConnErr(err) {
+			return err
+		}
+	}
+}
+
+func (f *FTPVolume) remotePath(name string) string {
+	return path.Join(f.root, name)
+}
+
+func (f *FTPVolume) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	var info fs.FileInfo
+	err := f.do(func(conn *ftpConn) (err error) {
+		info, err = f.stat(conn, name)
+		return err
+	})
+	if err != nil {
+		return nil, ftpPathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// stat uses MLST when the server has it and looks for name in the listing of
+// its parent otherwise.
+func (f *FTPVolume) stat(conn *ftpConn, name string) (fs.FileInfo, error) {
+	if name == "." {
+		return &ftpFileInfo{name: ".", mode: ftpDirMode}, nil
+	}
+	entry, err := conn.GetEntry(f.remotePath(name))
+	var protoErr *textproto.Error
+	switch {
+	case err == nil:
+		if entry.Type == ftp.EntryTypeLink {
+			return nil, fs.ErrNotExist
+		}
+		return newFTPFileInfo(path.Base(name), entry), nil
+	case errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotImplemented:
+	case errors.As(err, &protoErr) && protoErr.Code/100 == 5:
+		return nil, fs.ErrNotExist
+	default:
+		return nil, err
+	}
+	entries, err := f.list(conn, path.Dir(name))
+	if errors.As(err, &protoErr) && protoErr.Code/100 == 5 {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Name() == path.Base(name) {
+			return entries[i].Info()
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// list returns the entries of name without symlinks, sorted by name.
+func (f *FTPVolume) list(conn *ftpConn, name string) ([]fs.DirEntry, error) {
+	ftpEntries, err := conn.List(f.remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(ftpEntries))
+	for _, entry := range ftpEntries {
+		if entry.Name == "." || entry.Name == ".." || entry.Type == ftp.EntryTypeLink {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(newFTPFileInfo(path.Base(entry.Name), entry)))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (f *FTPVolume) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	err := f.do(func(conn *ftpConn) error {
+		info, err := f.stat(conn, name)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fs.ErrInvalid
+		}
+		entries, err = f.list(conn, name)
+		return err
+	})
+	if err != nil {
+		return nil, ftpPathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// Open of a file holds a connection until the file is closed.
+func (f *FTPVolume) Open(name string) (fs.File, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &ftpDir{info: info, entries: entries}, nil
+	}
+	file, err := newRangeFile(info, func(offset int64) (io.ReadCloser, error) {
+		return f.retr(name, offset, info.Size()-offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// retr streams the remaining bytes of name from offset with REST and RETR,
+// the connection is held until the returned body is closed.
+func (f *FTPVolume) retr(name string, offset, remaining int64) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := f.get()
+		if err == nil {
+			var resp *ftp.Response
+			if resp, err = conn.RetrFrom(f.remotePath(name), uint64(offset)); err == nil {
+				release := func(err error) { f.put(conn, err) }
+				return &ftpBody{Response: resp, release: release, remaining: remaining, eof: remaining <= 0}, nil
+			}
+			f.put(conn, err)
+		}
+		if attempt > 0 || !isFTPConnErr(err) {
+			return nil, ftpPathError("open", name, ftpTyped(err, errs.ERRFtpDownloadFile))
+		}
+	}
+}
+
+// Create streams the written content with STOR on one connection, Close
+// returns the result of the transfer.
+func (f *FTPVolume) Create(name string) (io.ReadWriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	conn, err := f.get()
+	if err == nil {
+		err = f.checkCreate(conn, name)
+		if err != nil {
+			f.put(conn, err)
+		}
+	}
+	if err != nil {
+		return nil, ftpPathError("create", name, err)
+	}
+	pr, pw := io.Pipe()
+	w := &ftpWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := conn.Stor(f.remotePath(name), pr)
+		f.put(conn, err)
+		if err != nil {
+			err = ftpPathError("create", name, ftpTyped(err, errs.ERRFtpUploadFile))
+		}
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// checkCreate fails unless the parent of name is a folder and name is not.
+func (f *FTPVolume) checkCreate(conn *ftpConn, name string) error {
+	parent, err := f.stat(conn, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return fs.ErrInvalid
+	}
+	if info, err := f.stat(conn, name); err == nil && info.IsDir() {
+		return fs.ErrExist
+	}
+	return nil
+}
+
+func (f *FTPVolume) Mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	// replies rarely tell why MKD failed and some servers create parents
+	err := f.do(func(conn *ftpConn) error {
+		parent, err := f.stat(conn, path.Dir(name))
+		if err != nil {
+			return err
+		}
+		if !parent.IsDir() {
+			return fs.ErrInvalid
+		}
+		if _, err = f.stat(conn, name); err == nil {
+			return fs.ErrExist
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return ftpTyped(conn.MakeDir(f.remotePath(name)), errs.ERRFtpMkdir)
+	})
+	if err != nil {
+		return ftpPathError("mkdir", name, err)
+	}
+	return nil
+}
+
+func (f *FTPVolume) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	err := f.do(func(conn *ftpConn) error {
+		info, err := f.stat(conn, name)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return conn.Delete(f.remotePath(name))
+		}
+		if entries, err := f.list(conn, name); err == nil && len(entries) > 0 {
+			return ErrDirNotEmpty
+		}
+		return conn.RemoveDir(f.remotePath(name))
+	})
+	if err != nil {
+		return ftpPathError("remove", name, err)
+	}
+	return nil
+}
+
+// Rename removes an existing file or empty folder at new first, FTP servers
+// differ in whether RNTO replaces it.
+func (f *FTPVolume) Rename(old, new string) error {
+	if !fs.ValidPath(old) || !fs.ValidPath(new) {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if old == "." || new == "." {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	err := f.do(func(conn *ftpConn) error {
+		info, err := f.stat(conn, old)
+		if err != nil {
+			return err
+		}
+		parent, err := f.stat(conn, path.Dir(new))
+		if err != nil {
+			return err
+		}
+		if !parent.IsDir() || info.IsDir() && strings.HasPrefix(new, old+"/") {
+			return fs.ErrInvalid
+		}
+		if old == new {
+			return nil
+		}
+		if existing, err := f.stat(conn, new); err == nil {
+			switch {
+			case existing.IsDir() && !info.IsDir():
+				return fs.ErrExist
+			case !existing.IsDir() && info.IsDir():
+				return fs.ErrInvalid
+			case existing.IsDir():
+				if entries, err := f.list(conn, new); err != nil || len(entries) > 0 {
+					return ErrDirNotEmpty
+				}
+				err = conn.RemoveDir(f.remotePath(new))
+			default:
+				err = conn.Delete(f.remotePath(new))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return conn.Rename(f.remotePath(old), f.remotePath(new))
+	})
+	if err != nil {
+		return ftpPathError("rename", old, err)
+	}
+	return nil
+}
+
+func ftpPathError(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: mapFTPError(err)}
+}
+
+// ftpTyped types a failed transfer or folder creation unless the error
+// has a more precise type.
+func ftpTyped(err error, errType errs.ErrType) error {
+	if err == nil || isFTPConnErr(err) || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotLoggedIn {
+		return err
+	}
+	return &typedError{err: err, errType: errType}
+}
+
+func mapFTPError(err error) error {
+	var protoErr *textproto.Error
+	switch {
+	case errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotLoggedIn:
+		return &typedError{err: err, errType: errs.ERRAccess}
+	case errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotAvailable:
+		return &typedError{err: err, errType: errs.ERRConnect}
+	case isFTPConnErr(err):
+		if typed := mapNetError(err); typed != err {
+			return typed
+		}
+		return &typedError{err: err, errType: errs.ERRConnect}
+	}
+	return err
+}
+
+// isFTPConnErr reports whether err means the connection is unusable.
+func isFTPConnErr(err error) bool {
+	var netErr net.Error
+	var protoErr *textproto.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &protoErr):
+		return protoErr.Code == ftp.StatusNotAvailable
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed),
+		errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+type ftpFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newFTPFileInfo(name string, entry *ftp.Entry) *ftpFileInfo {
+	info := &ftpFileInfo{name: name, size: int64(entry.Size), mode: ftpFileMode, modTime: entry.Time}
+	if entry.Type == ftp.EntryTypeFolder {
+		info.mode, info.size = ftpDirMode, 0
+	}
+	return info
+}
+
+func (i *ftpFileInfo) Name() string       { return i.name }
+func (i *ftpFileInfo) Size() int64        { return i.size }
+func (i *ftpFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *ftpFileInfo) ModTime() time.Time { return i.modTime }
+func (i *ftpFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *ftpFileInfo) Sys() interface{}   { return nil }
+
+// ftpBody is the data connection of a RETR, Close returns the control
+// connection to the pool. A transfer closed before its end leaves replies
+// of the server behind, the connection is dropped then.
+type ftpBody struct {
+	*ftp.Response
+	release   func(err error)
+	remaining int64
+	eof       bool
+	closed    bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.Response.Read(p)
+	b.remaining -= int64(n)
+	if err == io.EOF || b.remaining <= 0 {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *ftpBody) Close() error {
+	if b.closed {
+		return fs.ErrClosed
+	}
+	b.closed = true
+	err := b.Response.Close()
+	if !b.eof {
+		b.release(io.ErrUnexpectedEOF)
+		return err
+	}
+	b.release(err)
+	return err
+}
+
+type ftpDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *ftpDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *ftpDir) Close() error               { return nil }
+
+func (d *ftpDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *ftpDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+type ftpWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+}
+
+func (w *ftpWriter) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *ftpWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.pw.Write(p)
+}
+
+func (w *ftpWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.Close()
+	return <-w.done
+}
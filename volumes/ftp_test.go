@@ -0,0 +1,103 @@
+package volumes_test
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+func newFTPVolume(t *testing.T, s *volumestest.FTPServer, root string) *volumes.FTPVolume {
+	t.Helper()
+	vol, err := volumes.NewFTP(volumes.FTPConfig{Addr: s.Addr, User: s.User, Password: s.Password, Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = vol.Close() })
+	return vol
+}
+
+func TestFTP(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		root := t.TempDir()
+		if err := os.Mkdir(filepath.Join(root, "home"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return newFTPVolume(t, volumestest.StartFTP(t, root), "/home")
+	})
+}
+
+func TestFTPOpenRange(t *testing.T) {
+	s := volumestest.StartFTP(t, t.TempDir())
+	vol := newFTPVolume(t, s, "")
+	writeFile(t, vol, "a.txt", []byte("0123456789"))
+	f, err := vol.Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeker, ok := f.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("file cannot seek")
+	}
+	if _, err = seeker.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(seeker); err != nil || string(data) != "6789" {
+		t.Errorf("read after seek: %q %v", data, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a transfer closed before its end drops the connection
+	if f, err = vol.Open("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if data, err := fs.ReadFile(vol, "a.txt"); err != nil || string(data) != "0123456789" {
+		t.Errorf("read after a closed transfer: %q %v", data, err)
+	}
+}
+
+func TestFTPReusesConns(t *testing.T) {
+	s := volumestest.StartFTP(t, t.TempDir())
+	vol := newFTPVolume(t, s, "")
+	for i := 0; i < 5; i++ {
+		if _, err := vol.ReadDir("."); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.Logins(); n != 1 {
+		t.Errorf("%d logins for sequential requests", n)
+	}
+	s.CloseConns()
+	if _, err := vol.ReadDir("."); err != nil {
+		t.Fatalf("readdir after the connection dropped: %v", err)
+	}
+	if n := s.Logins(); n != 2 {
+		t.Errorf("%d logins, want a second one", n)
+	}
+}
+
+func TestFTPErrTypes(t *testing.T) {
+	s := volumestest.StartFTP(t, t.TempDir())
+	vol, err := volumes.NewFTP(volumes.FTPConfig{Addr: s.Addr, User: s.User, Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vol.Stat(".")
+	checkErrType(t, err, errs.ERRAccess)
+
+	s.Close()
+	vol = newFTPVolume(t, s, "")
+	_, err = vol.ReadDir(".")
+	checkErrType(t, err, errs.ERRConnect)
+}
@@ -0,0 +1,381 @@
+package volumes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/LeeEirc/elfinder/utils"
+)
+
+var ErrSymlink = errors.New("symlink not allowed")
+
+// SymlinkPolicy decides which symlinks below the root of a local volume are
+// followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinksInRoot follows symlinks resolving inside the root and hides
+	// all others. It is the default.
+	SymlinksInRoot SymlinkPolicy = iota
+	// SymlinksFollow follows every symlink, even out of the root. Only use
+	// it when nobody but the operator can create links in the root.
+	SymlinksFollow
+	// SymlinksDeny hides every symlink.
+	SymlinksDeny
+)
+
+const (
+	defaultLocalFileMode = 0644
+	defaultLocalDirMode  = 0755
+	localTempPattern     = ".elfinder-*.tmp"
+)
+
+var (
+	_ FsVolume      = (*LocalVolume)(nil)
+	_ Confiner      = (*LocalVolume)(nil)
+	_ fs.StatFS     = (*LocalVolume)(nil)
+	_ fs.ReadFileFS = (*LocalVolume)(nil)
+)
+
+type LocalOption func(*localOption)
+
+type localOption struct {
+	name        string
+	symlinks    SymlinkPolicy
+	fileMode    fs.FileMode
+	dirMode     fs.FileMode
+	umask       fs.FileMode
+	hiddenNames []*regexp.Regexp
+}
+
+// WithLocalName sets the volume name, it defaults to the base name of root.
+func WithLocalName(name string) LocalOption {
+	return func(o *localOption) {
+		o.name = name
+	}
+}
+
+func WithSymlinkPolicy(policy SymlinkPolicy) LocalOption {
+	return func(o *localOption) {
+		o.symlinks = policy
+	}
+}
+
+// WithPerm sets the permissions of new files and folders, 0644 and 0755 by
+// default. The umask of the process does not apply.
+func WithPerm(fileMode, dirMode fs.FileMode) LocalOption {
+	return func(o *localOption) {
+		o.fileMode = fileMode.Perm()
+		o.dirMode = dirMode.Perm()
+	}
+}
+
+// WithUmask clears the bits of mask from the permissions of new files and
+// folders.
+func WithUmask(mask fs.FileMode) LocalOption {
+	return func(o *localOption) {
+		o.umask = mask.Perm()
+	}
+}
+
+// WithHiddenNames hides the entries whose name matches any of the patterns,
+// together with everything below them, e.g. regexp.MustCompile(`^\.`) for
+// dot files.
+func WithHiddenNames(patterns ...*regexp.Regexp) LocalOption {
+	return func(o *localOption) {
+		o.hiddenNames = append(o.hiddenNames, patterns...)
+	}
+}
+
+// LocalVolume is an FsVolume on a directory of the local disk. Create writes
+// to a temporary file that replaces the target only when it is closed, so
+// readers never see partial content.
+type LocalVolume struct {
+	root string
+	opt  localOption
+}
+
+// NewLocal returns a volume on the directory root.
+func NewLocal(root string, opts ...LocalOption) (*LocalVolume, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", fs.ErrInvalid, root)
+	}
+	opt := localOption{
+		name:     filepath.Base(root),
+		fileMode: defaultLocalFileMode,
+		dirMode:  defaultLocalDirMode,
+	}
+	for _, setter := range opts {
+		setter(&opt)
+	}
+	return &LocalVolume{root: root, opt: opt}, nil
+}
+
+func (l *LocalVolume) Name() string {
+	return l.opt.name
+}
+
+// Root returns the absolute path of the directory of the volume.
+func (l *LocalVolume) Root() string {
+	return l.root
+}
+
+func (l *LocalVolume) Open(name string) (fs.File, error) {
+	absPath, err := l.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return &localDir{File: f, vol: l, name: name}, nil
+	}
+	return f, nil
+}
+
+func (l *LocalVolume) Stat(name string) (fs.FileInfo, error) {
+	absPath, err := l.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(absPath)
+}
+
+func (l *LocalVolume) ReadFile(name string) ([]byte, error) {
+	absPath, err := l.resolve("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(absPath)
+}
+
+// ReadDir lists name without hidden entries, symlinks the policy does not
+// follow and the temporary files of unfinished writes.
+func (l *LocalVolume) ReadDir(name string) ([]fs.DirEntry, error) {
+	absPath, err := l.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return nil, err
+	}
+	res := entries[:0]
+	for i := range entries {
+		childName := entries[i].Name()
+		if l.isHidden(childName) || isLocalTemp(childName) {
+			continue
+		}
+		if entries[i].Type()&fs.ModeSymlink != 0 {
+			childPath, err := l.resolve("readdir", path.Join(name, childName))
+			if err != nil {
+				continue
+			}
+			if _, err = os.Stat(childPath); err != nil {
+				continue
+			}
+		}
+		res = append(res, entries[i])
+	}
+	return res, nil
+}
+
+func (l *LocalVolume) Confine(name string) error {
+	_, err := l.resolve("open", name)
+	return err
+}
+
+// Create returns a writer to a temporary file next to name, closing it moves
+// the file into place. An existing file keeps its permissions.
+func (l *LocalVolume) Create(name string) (io.ReadWriteCloser, error) {
+	absPath, err := l.resolve("create", name)
+	if err != nil {
+		return nil, err
+	}
+	mode := l.opt.fileMode &^ l.opt.umask
+	if info, err := os.Stat(absPath); err == nil {
+		if info.IsDir() {
+			return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+		}
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(absPath), localTempPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: f, target: absPath, mode: mode}, nil
+}
+
+func (l *LocalVolume) Mkdir(name string) error {
+	absPath, err := l.resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	mode := l.opt.dirMode &^ l.opt.umask
+	if err = os.Mkdir(absPath, mode); err != nil {
+		return err
+	}
+	return os.Chmod(absPath, mode)
+}
+
+func (l *LocalVolume) Remove(name string) error {
+	absPath, err := l.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	if absPath == l.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return os.Remove(absPath)
+}
+
+func (l *LocalVolume) Rename(old, new string) error {
+	oldAbsPath, err := l.resolve("rename", old)
+	if err != nil {
+		return err
+	}
+	newAbsPath, err := l.resolve("rename", new)
+	if err != nil {
+		return err
+	}
+	if oldAbsPath == l.root || newAbsPath == l.root {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	return os.Rename(oldAbsPath, newAbsPath)
+}
+
+// resolve returns the absolute path of the relative path name after checking
+// it against the hidden names and the symlink policy.
+func (l *LocalVolume) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		for _, elem := range strings.Split(name, "/") {
+			if l.isHidden(elem) {
+				return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+	absPath := filepath.Join(l.root, filepath.FromSlash(name))
+	switch l.opt.symlinks {
+	case SymlinksFollow:
+		return absPath, nil
+	case SymlinksDeny:
+		if err := l.checkNoSymlink(name); err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		return absPath, nil
+	}
+	confined, err := utils.ConfinePath(l.root, filepath.FromSlash(name))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return confined, nil
+}
+
+// checkNoSymlink fails if an existing element of name is a symlink.
+func (l *LocalVolume) checkNoSymlink(name string) error {
+	if name == "." {
+		return nil
+	}
+	p := l.root
+	for _, elem := range strings.Split(name, "/") {
+		p = filepath.Join(p, elem)
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return ErrSymlink
+		}
+	}
+	return nil
+}
+
+func (l *LocalVolume) isHidden(name string) bool {
+	for i := range l.opt.hiddenNames {
+		if l.opt.hiddenNames[i].MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLocalTemp(name string) bool {
+	ok, _ := path.Match(localTempPattern, name)
+	return ok
+}
+
+type localWriter struct {
+	*os.File
+	target string
+	mode   fs.FileMode
+}
+
+func (w *localWriter) Close() error {
+	err := w.File.Close()
+	if err == nil {
+		err = os.Chmod(w.File.Name(), w.mode)
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.target)
+	}
+	if err != nil {
+		_ = os.Remove(w.File.Name())
+	}
+	return err
+}
+
+// localDir is an open folder listing the same entries as ReadDir.
+type localDir struct {
+	*os.File
+	vol     *LocalVolume
+	name    string
+	entries []fs.DirEntry
+	read    bool
+	offset  int
+}
+
+func (d *localDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.vol.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
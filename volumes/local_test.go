@@ -0,0 +1,18 @@
+package volumes_test
+
+import (
+	"testing"
+
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+func TestLocal(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		vol, err := volumes.NewLocal(t.TempDir(), volumes.WithLocalName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vol
+	})
+}
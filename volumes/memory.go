@@ -0,0 +1,434 @@
+package volumes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDirNotEmpty = errors.New("directory not empty")
+
+const (
+	memDirMode  = fs.ModeDir | 0755
+	memFileMode = 0644
+)
+
+var (
+	_ FsVolume      = (*MemVolume)(nil)
+	_ fs.StatFS     = (*MemVolume)(nil)
+	_ fs.ReadFileFS = (*MemVolume)(nil)
+)
+
+// MemVolume is an FsVolume keeping folders and files in memory, e.g. as
+// scratch space or to test the connector. It is safe for concurrent use.
+type MemVolume struct {
+	name  string
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+}
+
+// memNode is an entry of a MemVolume. The content of a file is never changed
+// in place, open files keep reading the content they were opened with.
+type memNode struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]struct{}
+}
+
+func newMemDir(mode fs.FileMode, modTime time.Time) *memNode {
+	return &memNode{mode: mode, modTime: modTime, children: make(map[string]struct{})}
+}
+
+// NewMemory returns an empty volume named name.
+func NewMemory(name string) *MemVolume {
+	return &MemVolume{
+		name:  name,
+		nodes: map[string]*memNode{".": newMemDir(memDirMode, time.Now())},
+	}
+}
+
+// NewMemoryFrom returns a volume named name holding a copy of fsys, e.g. a
+// fstest.MapFS. Modes and modification times are kept, entries without
+// permission bits get 0644 for files and 0755 for folders. Note that MapFS
+// reports the folders it synthesizes as read-only 0555, list them with
+// mode fs.ModeDir|0755 to make them writable.
+func NewMemoryFrom(name string, fsys fs.FS) (*MemVolume, error) {
+	v := NewMemory(name)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := memModeOf(info)
+		if p == "." {
+			v.nodes["."].mode = mode
+			v.nodes["."].modTime = info.ModTime()
+			return nil
+		}
+		node := &memNode{mode: mode, modTime: info.ModTime()}
+		if d.IsDir() {
+			node = newMemDir(mode, info.ModTime())
+		} else if node.data, err = fs.ReadFile(fsys, p); err != nil {
+			return err
+		}
+		v.nodes[p] = node
+		v.nodes[path.Dir(p)].children[path.Base(p)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// memModeOf returns the mode of info, with the default permissions when it
+// has none.
+func memModeOf(info fs.FileInfo) fs.FileMode {
+	mode := info.Mode()
+	if info.IsDir() {
+		mode |= fs.ModeDir
+	}
+	if mode.Perm() != 0 {
+		return mode
+	}
+	if mode.IsDir() {
+		return mode | memDirMode.Perm()
+	}
+	return mode | memFileMode
+}
+
+func (v *MemVolume) Name() string {
+	return v.name
+}
+
+func (v *MemVolume) Open(name string) (fs.File, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	node, err := v.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := node.info(name)
+	if !node.mode.IsDir() {
+		return &memFile{info: info, Reader: bytes.NewReader(node.data)}, nil
+	}
+	return &memDirFile{info: info, entries: v.dirEntries(name, node)}, nil
+}
+
+func (v *MemVolume) Stat(name string) (fs.FileInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	node, err := v.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+func (v *MemVolume) ReadFile(name string) ([]byte, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	node, err := v.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+func (v *MemVolume) ReadDir(name string) ([]fs.DirEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	node, err := v.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return v.dirEntries(name, node), nil
+}
+
+// Create returns a writer replacing the content of the file name when it is
+// closed, a new file is only visible after Close.
+func (v *MemVolume) Create(name string) (io.ReadWriteCloser, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if _, err := v.parentDir("create", name); err != nil {
+		return nil, err
+	}
+	if node, ok := v.nodes[name]; ok && node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	return &memWriter{vol: v, name: name}, nil
+}
+
+func (v *MemVolume) Mkdir(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	parent, err := v.parentDir("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.nodes[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	v.nodes[name] = newMemDir(memDirMode, time.Now())
+	parent.children[path.Base(name)] = struct{}{}
+	parent.modTime = time.Now()
+	return nil
+}
+
+// Remove removes a file or an empty folder.
+func (v *MemVolume) Remove(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	node, err := v.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if len(node.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrDirNotEmpty}
+	}
+	delete(v.nodes, name)
+	parent := v.nodes[path.Dir(name)]
+	delete(parent.children, path.Base(name))
+	parent.modTime = time.Now()
+	return nil
+}
+
+// Rename moves old to new like os.Rename: an existing file at new is
+// replaced, an existing folder only if it is empty and old is a folder too.
+func (v *MemVolume) Rename(old, new string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	node, err := v.lookup("rename", old)
+	if err != nil {
+		return err
+	}
+	newParent, err := v.parentDir("rename", new)
+	if err != nil {
+		return err
+	}
+	if old == "." || new == "." {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	if old == new {
+		return nil
+	}
+	if node.mode.IsDir() && strings.HasPrefix(new, old+"/") {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if existing, ok := v.nodes[new]; ok {
+		switch {
+		case existing.mode.IsDir() && !node.mode.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrExist}
+		case !existing.mode.IsDir() && node.mode.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrInvalid}
+		case len(existing.children) > 0:
+			return &fs.PathError{Op: "rename", Path: new, Err: ErrDirNotEmpty}
+		}
+	}
+	oldParent := v.nodes[path.Dir(old)]
+	delete(oldParent.children, path.Base(old))
+	for p := range v.nodes {
+		if strings.HasPrefix(p, old+"/") {
+			v.nodes[new+strings.TrimPrefix(p, old)] = v.nodes[p]
+			delete(v.nodes, p)
+		}
+	}
+	delete(v.nodes, old)
+	v.nodes[new] = node
+	newParent.children[path.Base(new)] = struct{}{}
+	now := time.Now()
+	oldParent.modTime, newParent.modTime = now, now
+	return nil
+}
+
+// Chmod changes the permission bits of name.
+func (v *MemVolume) Chmod(name string, mode fs.FileMode) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	node, err := v.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode&fs.ModeType | mode.Perm()
+	return nil
+}
+
+// Chtimes changes the modification time of name.
+func (v *MemVolume) Chtimes(name string, modTime time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	node, err := v.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	node.modTime = modTime
+	return nil
+}
+
+// lookup returns the node of name, the caller holds the lock.
+func (v *MemVolume) lookup(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node, ok := v.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// parentDir returns the folder node name is created in, the caller holds
+// the lock.
+func (v *MemVolume) parentDir(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, ok := v.nodes[path.Dir(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return parent, nil
+}
+
+func (v *MemVolume) dirEntries(name string, node *memNode) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for child := range node.children {
+		childPath := path.Join(name, child)
+		entries = append(entries, fs.FileInfoToDirEntry(v.nodes[childPath].info(childPath)))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+func (v *MemVolume) commit(name string, data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	parent, err := v.parentDir("create", name)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	node, ok := v.nodes[name]
+	switch {
+	case !ok:
+		node = &memNode{mode: memFileMode}
+		v.nodes[name] = node
+		parent.children[path.Base(name)] = struct{}{}
+		parent.modTime = now
+	case node.mode.IsDir():
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	node.data = data
+	node.modTime = now
+	return nil
+}
+
+func (n *memNode) info(name string) *memFileInfo {
+	return &memFileInfo{
+		name:    path.Base(name),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return nil }
+
+type memFile struct {
+	info *memFileInfo
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memDirFile struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDirFile) Close() error               { return nil }
+
+func (d *memDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+type memWriter struct {
+	vol    *MemVolume
+	name   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memWriter) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	return w.vol.commit(w.name, w.buf.Bytes())
+}
@@ -0,0 +1,28 @@
+package volumes_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+func TestMemory(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		return volumes.NewMemory("test")
+	})
+}
+
+func TestMemoryFrom(t *testing.T) {
+	vol, err := volumes.NewMemoryFrom("test", fstest.MapFS{
+		"a.txt":     {Data: []byte("a")},
+		"dir/b.txt": {Data: []byte("b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fstest.TestFS(vol, "a.txt", "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
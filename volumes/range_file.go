@@ -0,0 +1,88 @@
+package volumes
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// rangeFile is a remote file read through streams starting at an offset,
+// e.g. ranged GETs or FTP REST. Seek only moves the offset, the next Read
+// reopens the stream there, so http.ServeContent answers Range requests
+// without fetching the whole file.
+type rangeFile struct {
+	info fs.FileInfo
+	open func(offset int64) (io.ReadCloser, error)
+
+	body       io.ReadCloser
+	bodyOffset int64
+	offset     int64
+	closed     bool
+}
+
+// newRangeFile opens the stream at the start of the file so that a missing
+// file fails in Open rather than in the first Read.
+func newRangeFile(info fs.FileInfo, open func(offset int64) (io.ReadCloser, error)) (*rangeFile, error) {
+	body, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeFile{info: info, open: open, body: body}, nil
+}
+
+func (f *rangeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *rangeFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body != nil && f.bodyOffset != f.offset {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		body, err := f.open(f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body, f.bodyOffset = body, f.offset
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+	return n, err
+}
+
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	case io.SeekStart:
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *rangeFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
@@ -0,0 +1,58 @@
+package volumes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRangeFileServeContent(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	var offsets []int64
+	f, err := newRangeFile(&memFileInfo{name: "a.txt", size: int64(len(data))}, func(offset int64) (io.ReadCloser, error) {
+		offsets = append(offsets, offset)
+		return io.NopCloser(bytes.NewReader(data[offset:])), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=10-14")
+	rw := httptest.NewRecorder()
+	http.ServeContent(rw, req, "a.txt", time.Time{}, f)
+	if rw.Code != http.StatusPartialContent || rw.Body.String() != "abcde" {
+		t.Fatalf("got %d %q", rw.Code, rw.Body.String())
+	}
+	if len(offsets) != 2 || offsets[1] != 10 {
+		t.Errorf("streams opened at %v, want [0 10]", offsets)
+	}
+}
+
+func TestRangeFileSeekPastEnd(t *testing.T) {
+	f, err := newRangeFile(&memFileInfo{name: "a.txt", size: 3}, func(offset int64) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte("abc")[offset:])), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+		t.Errorf("read past end: %d %v", n, err)
+	}
+	if _, err = f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("negative seek accepted")
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(make([]byte, 1)); err == nil {
+		t.Error("read after close")
+	}
+}
@@ -0,0 +1,60 @@
+package volumes
+
+import (
+	"io"
+	"io/fs"
+)
+
+// ReadOnlyChecker is implemented by volumes that reject every change. The
+// connector reports their entries as locked and disables the commands that
+// would modify them.
+type ReadOnlyChecker interface {
+	ReadOnly() bool
+}
+
+var (
+	_ FsVolume        = readOnlyVolume{}
+	_ ReadOnlyChecker = readOnlyVolume{}
+	_ Confiner        = readOnlyVolume{}
+)
+
+// ReadOnly wraps vol so that Create, Mkdir, Remove and Rename fail with
+// fs.ErrPermission, e.g. to mount reference data.
+func ReadOnly(vol FsVolume) FsVolume {
+	return readOnlyVolume{FsVolume: vol}
+}
+
+type readOnlyVolume struct {
+	FsVolume
+}
+
+func (v readOnlyVolume) ReadOnly() bool {
+	return true
+}
+
+func (v readOnlyVolume) Stat(path string) (fs.FileInfo, error) {
+	return fs.Stat(v.FsVolume, path)
+}
+
+func (v readOnlyVolume) Confine(path string) error {
+	if confiner, ok := v.FsVolume.(Confiner); ok {
+		return confiner.Confine(path)
+	}
+	return nil
+}
+
+func (v readOnlyVolume) Create(path string) (io.ReadWriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: path, Err: fs.ErrPermission}
+}
+
+func (v readOnlyVolume) Mkdir(path string) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrPermission}
+}
+
+func (v readOnlyVolume) Remove(path string) error {
+	return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrPermission}
+}
+
+func (v readOnlyVolume) Rename(old, new string) error {
+	return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+}
@@ -0,0 +1,35 @@
+package volumes_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+func TestReadOnly(t *testing.T) {
+	mem, err := volumes.NewMemoryFrom("test", fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := volumes.ReadOnly(mem)
+	if checker, ok := vol.(volumes.ReadOnlyChecker); !ok || !checker.ReadOnly() {
+		t.Error("wrapped volume does not report read-only")
+	}
+	_, err = vol.Create("b.txt")
+	for name, err := range map[string]error{
+		"create": err,
+		"mkdir":  vol.Mkdir("dir"),
+		"remove": vol.Remove("a.txt"),
+		"rename": vol.Rename("a.txt", "b.txt"),
+	} {
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err = fstest.TestFS(vol, "a.txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,532 @@
+package volumes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3PartSize = 8 << 20
+	minS3PartSize     = 5 << 20
+	s3DirMode         = fs.ModeDir | 0755
+	s3FileMode        = 0644
+)
+
+var (
+	_ FsVolume  = (*S3Volume)(nil)
+	_ fs.StatFS = (*S3Volume)(nil)
+	_ ETagger   = (*s3FileInfo)(nil)
+)
+
+// S3Config addresses a bucket of an S3 compatible object storage.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g.
+	// "https://s3.eu-west-1.amazonaws.com" or "http://127.0.0.1:9000".
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is the key prefix of the volume root, e.g. "users/alice",
+	// empty for the whole bucket.
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle puts the bucket into the path instead of the host name, as
+	// most self hosted services expect.
+	PathStyle bool
+	// PartSize is the size of the parts of multipart uploads, files up to
+	// this size are stored with a single request. It defaults to 8 MiB and
+	// cannot be smaller than 5 MiB.
+	PartSize int64
+	// Name is the volume name, it defaults to the bucket name.
+	Name       string
+	HTTPClient *http.Client
+}
+
+// S3Object is what fs.FileInfo.Sys returns for files of an S3Volume.
+type S3Object struct {
+	Key  string
+	ETag string
+}
+
+// S3Volume is an FsVolume on an S3 compatible bucket. A folder is a key
+// prefix ending in "/", it exists if an object has that prefix and has no
+// modification time. Mkdir stores an empty marker object with the folder key
+// so empty folders persist. Rename copies and deletes every object, objects
+// over 5 GiB are copied part by part.
+type S3Volume struct {
+	client *s3Client
+	prefix string
+	name   string
+}
+
+func NewS3(cfg S3Config) (*S3Volume, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: s3 bucket not set", fs.ErrInvalid)
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: s3 endpoint %q", fs.ErrInvalid, cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	switch {
+	case cfg.PartSize == 0:
+		cfg.PartSize = defaultS3PartSize
+	case cfg.PartSize < minS3PartSize:
+		return nil, fmt.Errorf("%w: s3 part size below %d bytes", fs.ErrInvalid, minS3PartSize)
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Bucket
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Volume{
+		client: &s3Client{cfg: cfg, endpoint: endpoint, http: httpClient, now: time.Now,
+			maxCopySize: s3MaxCopySize, copyPartSize: s3CopyPartSize},
+		prefix: prefix,
+		name:   name,
+	}, nil
+}
+
+func (s *S3Volume) Name() string {
+	return s.name
+}
+
+func (s *S3Volume) key(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return s.prefix + name
+}
+
+func (s *S3Volume) dirKey(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return s.prefix + name + "/"
+}
+
+func (s *S3Volume) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := s.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// stat heads the object of name and falls back to looking for objects below
+// the folder name.
+func (s *S3Volume) stat(name string) (*s3FileInfo, error) {
+	if name == "." {
+		return &s3FileInfo{name: ".", mode: s3DirMode}, nil
+	}
+	obj, err := s.client.head(s.key(name))
+	if err == nil {
+		return s3ObjectInfo(obj, path.Base(name)), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	found := false
+	err = s.client.list(s.dirKey(name), "", 1, func(page *s3ListResult) bool {
+		found = len(page.Contents) > 0
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fs.ErrNotExist
+	}
+	return &s3FileInfo{name: path.Base(name), mode: s3DirMode}, nil
+}
+
+// s3ObjectInfo describes the file of obj. The modification time is truncated
+// to the second precision of HEAD responses so listing and Stat agree.
+func s3ObjectInfo(obj s3Object, name string) *s3FileInfo {
+	return &s3FileInfo{name: name, size: obj.Size, mode: s3FileMode, modTime: obj.LastModified.Truncate(time.Second),
+		obj: S3Object{Key: obj.Key, ETag: obj.ETag}}
+}
+
+func (s *S3Volume) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Dir{info: info, entries: entries}, nil
+	}
+	f, err := newRangeFile(info, func(offset int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		if offset > 0 {
+			header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		}
+		resp, err := s.client.do(s3Request{method: http.MethodGet, key: s.key(name), header: header})
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		// a service ignoring Range sends the whole object
+		if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+			if _, err = io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				_ = resp.Body.Close()
+				return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+			}
+		}
+		return resp.Body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadDir lists the folder name page by page. Folders and files are merged
+// and sorted by name.
+func (s *S3Volume) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	dirKey := s.dirKey(name)
+	var entries []fs.DirEntry
+	dirs := make(map[string]bool)
+	addDir := func(key string) {
+		childName := strings.TrimSuffix(strings.TrimPrefix(key, dirKey), "/")
+		if childName != "" && !dirs[childName] {
+			dirs[childName] = true
+			entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{name: childName, mode: s3DirMode}))
+		}
+	}
+	err = s.client.list(dirKey, "/", 0, func(page *s3ListResult) bool {
+		for _, p := range page.CommonPrefixes {
+			addDir(p.Prefix)
+		}
+		for _, obj := range page.Contents {
+			// some services list folder markers as objects
+			if strings.HasSuffix(obj.Key, "/") {
+				addDir(obj.Key)
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(s3ObjectInfo(obj, strings.TrimPrefix(obj.Key, dirKey))))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// checkParent fails unless the parent of name is an existing folder.
+func (s *S3Volume) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, err := s.stat(path.Dir(name))
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !parent.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// Create streams the content with a multipart upload once it exceeds the
+// part size, smaller files are stored on Close with a single request.
+func (s *S3Volume) Create(name string) (io.ReadWriteCloser, error) {
+	if err := s.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	if info, err := s.stat(name); err == nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	return &s3Writer{client: s.client, key: s.key(name), partSize: s.client.cfg.PartSize}, nil
+}
+
+func (s *S3Volume) Mkdir(name string) error {
+	if err := s.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if _, err := s.stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := s.client.put(s.dirKey(name), []byte{}); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *S3Volume) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	info, err := s.stat(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	key := s.key(name)
+	if info.IsDir() {
+		key = s.dirKey(name)
+		empty, err := s.emptyDir(name)
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if !empty {
+			return &fs.PathError{Op: "remove", Path: name, Err: ErrDirNotEmpty}
+		}
+	}
+	if err = s.client.delete(key); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// emptyDir reports whether no object but the folder marker has the prefix of
+// the folder name.
+func (s *S3Volume) emptyDir(name string) (bool, error) {
+	empty := true
+	err := s.client.list(s.dirKey(name), "", 2, func(page *s3ListResult) bool {
+		for _, obj := range page.Contents {
+			if obj.Key != s.dirKey(name) {
+				empty = false
+			}
+		}
+		return false
+	})
+	return empty, err
+}
+
+func (s *S3Volume) Rename(old, new string) error {
+	if !fs.ValidPath(old) || !fs.ValidPath(new) {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if old == "." || new == "." {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	info, err := s.stat(old)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: old, Err: err}
+	}
+	if err = s.checkParent("rename", new); err != nil {
+		return err
+	}
+	if old == new {
+		return nil
+	}
+	if existing, err := s.stat(new); err == nil {
+		switch {
+		case existing.IsDir() && !info.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrExist}
+		case !existing.IsDir() && info.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrInvalid}
+		case existing.IsDir():
+			if empty, err := s.emptyDir(new); err != nil || !empty {
+				return &fs.PathError{Op: "rename", Path: new, Err: ErrDirNotEmpty}
+			}
+		}
+	}
+	if !info.IsDir() {
+		if err = s.client.copy(s.key(old), s.key(new), info.Size()); err == nil {
+			err = s.client.delete(s.key(old))
+		}
+		if err != nil {
+			return &fs.PathError{Op: "rename", Path: old, Err: err}
+		}
+		return nil
+	}
+	if strings.HasPrefix(new, old+"/") {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	oldKey, newKey := s.dirKey(old), s.dirKey(new)
+	var objects []s3Object
+	err = s.client.list(oldKey, "", 0, func(page *s3ListResult) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: old, Err: err}
+	}
+	for _, obj := range objects {
+		if err = s.client.copy(obj.Key, newKey+strings.TrimPrefix(obj.Key, oldKey), obj.Size); err != nil {
+			return &fs.PathError{Op: "rename", Path: old, Err: err}
+		}
+	}
+	for _, obj := range objects {
+		if err = s.client.delete(obj.Key); err != nil {
+			return &fs.PathError{Op: "rename", Path: old, Err: err}
+		}
+	}
+	return nil
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	obj     S3Object
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i *s3FileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *s3FileInfo) Sys() interface{}   { return i.obj }
+func (i *s3FileInfo) ETag() string       { return i.obj.ETag }
+
+type s3Dir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *s3Dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *s3Dir) Close() error               { return nil }
+
+func (d *s3Dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *s3Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// s3Writer buffers one part at a time. The multipart upload starts with the
+// first full part, it is completed on Close and aborted on any error.
+type s3Writer struct {
+	client   *s3Client
+	key      string
+	partSize int64
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3CompletePart
+	err      error
+	closed   bool
+}
+
+func (w *s3Writer) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := int(w.partSize) - w.buf.Len()
+		if room > len(p) {
+			room = len(p)
+		}
+		w.buf.Write(p[:room])
+		p = p[room:]
+		if int64(w.buf.Len()) == w.partSize {
+			if w.err = w.flushPart(); w.err != nil {
+				w.abort()
+				return 0, w.err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *s3Writer) flushPart() error {
+	if w.uploadID == "" {
+		uploadID, err := w.client.createMultipart(w.key)
+		if err != nil {
+			return err
+		}
+		w.uploadID = uploadID
+	}
+	number := len(w.parts) + 1
+	etag, err := w.client.uploadPart(w.key, w.uploadID, number, w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, s3CompletePart{PartNumber: number, ETag: etag})
+	w.buf.Reset()
+	return nil
+}
+
+func (w *s3Writer) abort() {
+	if w.uploadID != "" {
+		_ = w.client.abortMultipart(w.key, w.uploadID)
+		w.uploadID = ""
+	}
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.uploadID == "" {
+		return w.client.put(w.key, w.buf.Bytes())
+	}
+	if w.buf.Len() > 0 {
+		if err := w.flushPart(); err != nil {
+			w.abort()
+			return err
+		}
+	}
+	if err := w.client.completeMultipart(w.key, w.uploadID, w.parts); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package volumes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service       = "s3"
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3TimeFormat    = "20060102T150405Z"
+	s3DateFormat    = "20060102"
+	s3ContentSha256 = "X-Amz-Content-Sha256"
+	s3CopySource    = "X-Amz-Copy-Source"
+
+	// s3MaxCopySize is the largest object CopyObject accepts, larger ones
+	// are copied part by part with UploadPartCopy.
+	s3MaxCopySize  = 5 << 30
+	s3CopyPartSize = 1 << 30
+)
+
+var s3EmptySha256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// S3Error is an error response of the object storage.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap maps the response to the fs errors the connector understands.
+func (e *S3Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound || e.Code == "NoSuchKey":
+		return fs.ErrNotExist
+	case e.StatusCode == http.StatusForbidden:
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+// s3Client sends AWS signature version 4 signed requests to one bucket.
+type s3Client struct {
+	cfg      S3Config
+	endpoint *url.URL
+	http     *http.Client
+	now      func() time.Time
+
+	maxCopySize  int64
+	copyPartSize int64
+}
+
+type s3Request struct {
+	method string
+	key    string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// do sends req, a non 2xx response is returned as *S3Error. The caller
+// closes the body of the returned response.
+func (c *s3Client) do(req s3Request) (*http.Response, error) {
+	u := *c.endpoint
+	objectPath := "/" + req.key
+	if c.cfg.PathStyle {
+		objectPath = "/" + c.cfg.Bucket + objectPath
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(req.query)
+
+	var body io.Reader
+	payloadHash := s3EmptySha256
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+		sum := sha256.Sum256(req.body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	httpReq, err := http.NewRequest(req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k := range req.header {
+		httpReq.Header[k] = req.header[k]
+	}
+	c.sign(httpReq, payloadHash)
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3ReadError(resp)
+	}
+	return resp, nil
+}
+
+// doXML sends req and decodes the XML response into out. Some operations,
+// e.g. CopyObject, report errors in the body of a 200 response.
+func (c *s3Client) doXML(req s3Request, out interface{}) error {
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if s3Err := s3BodyError(resp.StatusCode, data); s3Err != nil {
+		return s3Err
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// s3BodyError returns the error of a 200 response whose root element is
+// <Error>, e.g. a CopyObject that failed after the response started.
+func s3BodyError(statusCode int, data []byte) *S3Error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "Error" {
+				return nil
+			}
+			s3Err := &S3Error{StatusCode: statusCode}
+			_ = dec.DecodeElement(s3Err, &start)
+			return s3Err
+		}
+	}
+}
+
+func s3ReadError(resp *http.Response) error {
+	s3Err := &S3Error{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16)); err == nil && len(data) > 0 {
+		_ = xml.Unmarshal(data, s3Err)
+	}
+	return s3Err
+}
+
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format(s3TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set(s3ContentSha256, payloadHash)
+	if c.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.cfg.SessionToken)
+	}
+	if c.cfg.AccessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(s3DateFormat), c.cfg.Region, s3Service, "aws4_request"}, "/")
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, hex.EncodeToString(requestSum[:])}, "\n")
+
+	key := s3Hmac([]byte("AWS4"+c.cfg.SecretKey), now.Format(s3DateFormat))
+	key = s3Hmac(key, c.cfg.Region)
+	key = s3Hmac(key, s3Service)
+	key = s3Hmac(key, "aws4_request")
+	signature := hex.EncodeToString(s3Hmac(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes s as required for signing, everything but the unreserved
+// characters of RFC 3986 is percent encoded.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		segments[i] = s3Escape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+type s3ListResult struct {
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+	Contents              []s3Object `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list calls fn for every page of ListObjectsV2 of prefix until fn returns
+// false. With a delimiter, keys below the next "/" are rolled up into
+// CommonPrefixes.
+func (c *s3Client) list(prefix, delimiter string, maxKeys int, fn func(*s3ListResult) bool) error {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", fmt.Sprint(maxKeys))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page s3ListResult
+		if err := c.doXML(s3Request{method: http.MethodGet, query: query}, &page); err != nil {
+			return err
+		}
+		if !fn(&page) || !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (c *s3Client) head(key string) (s3Object, error) {
+	resp, err := c.do(s3Request{method: http.MethodHead, key: key})
+	if err != nil {
+		return s3Object{}, err
+	}
+	_ = resp.Body.Close()
+	obj := s3Object{Key: key, ETag: resp.Header.Get("ETag"), Size: resp.ContentLength}
+	obj.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return obj, nil
+}
+
+func (c *s3Client) put(key string, data []byte) error {
+	resp, err := c.do(s3Request{method: http.MethodPut, key: key, body: data})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *s3Client) delete(key string) error {
+	resp, err := c.do(s3Request{method: http.MethodDelete, key: key})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// copy copies the object srcKey of size bytes to dstKey. Errors reported in
+// the body of a 200 response are returned like any other.
+func (c *s3Client) copy(srcKey, dstKey string, size int64) error {
+	if size > c.maxCopySize {
+		return c.copyMultipart(srcKey, dstKey, size)
+	}
+	header := http.Header{}
+	header.Set(s3CopySource, s3EscapePath("/"+c.cfg.Bucket+"/"+srcKey))
+	return c.doXML(s3Request{method: http.MethodPut, key: dstKey, header: header, body: []byte{}}, nil)
+}
+
+// copyMultipart copies objects too large for CopyObject with one
+// UploadPartCopy per range of copyPartSize bytes.
+func (c *s3Client) copyMultipart(srcKey, dstKey string, size int64) error {
+	uploadID, err := c.createMultipart(dstKey)
+	if err != nil {
+		return err
+	}
+	var parts []s3CompletePart
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+c.copyPartSize, number+1 {
+		end := offset + c.copyPartSize
+		if end > size {
+			end = size
+		}
+		header := http.Header{}
+		header.Set(s3CopySource, s3EscapePath("/"+c.cfg.Bucket+"/"+srcKey))
+		header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", offset, end-1))
+		query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {uploadID}}
+		var res struct {
+			ETag string `xml:"ETag"`
+		}
+		err = c.doXML(s3Request{method: http.MethodPut, key: dstKey, query: query, header: header, body: []byte{}}, &res)
+		if err != nil {
+			_ = c.abortMultipart(dstKey, uploadID)
+			return err
+		}
+		parts = append(parts, s3CompletePart{PartNumber: number, ETag: res.ETag})
+	}
+	if err = c.completeMultipart(dstKey, uploadID, parts); err != nil {
+		_ = c.abortMultipart(dstKey, uploadID)
+		return err
+	}
+	return nil
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *s3Client) createMultipart(key string) (string, error) {
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := c.doXML(s3Request{method: http.MethodPost, key: key, query: url.Values{"uploads": {""}}, body: []byte{}}, &res)
+	if err == nil && res.UploadID == "" {
+		err = errors.New("s3: no upload id")
+	}
+	return res.UploadID, err
+}
+
+func (c *s3Client) uploadPart(key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {uploadID}}
+	resp, err := c.do(s3Request{method: http.MethodPut, key: key, query: query, body: data})
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *s3Client) completeMultipart(key, uploadID string, parts []s3CompletePart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletePart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	return c.doXML(s3Request{method: http.MethodPost, key: key, query: url.Values{"uploadId": {uploadID}}, body: body}, nil)
+}
+
+func (c *s3Client) abortMultipart(key, uploadID string) error {
+	resp, err := c.do(s3Request{method: http.MethodDelete, key: key, query: url.Values{"uploadId": {uploadID}}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,445 @@
+package volumes_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+const s3TestBucket = "bucket"
+
+// s3Stub is an in-memory stand-in for the S3 operations S3Volume uses,
+// with path style addressing. It pages listings by pageSize keys.
+type s3Stub struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	nextID   int
+	pageSize int
+	// copyError makes CopyObject answer 200 with an <Error> body.
+	copyError bool
+	// ignoreRange answers ranged GETs with the whole object.
+	ignoreRange bool
+	ops         []string
+}
+
+type s3StubObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+type s3StubPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3StubList struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3StubObject `xml:"Contents"`
+	CommonPrefixes        []s3StubPrefix `xml:"CommonPrefixes"`
+}
+
+var s3StubModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newS3Stub() *s3Stub {
+	return &s3Stub{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte), pageSize: 1000}
+}
+
+func s3StubETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *s3Stub) count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for i := range s.ops {
+		if s.ops[i] == op {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *s3Stub) fail(rw http.ResponseWriter, status int, code string) {
+	rw.WriteHeader(status)
+	fmt.Fprintf(rw, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (s *s3Stub) writeXML(rw http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		s.fail(rw, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/xml")
+	_, _ = rw.Write(data)
+}
+
+func (s *s3Stub) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") == "" {
+		s.fail(rw, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/"+s3TestBucket), "/")
+	query := req.URL.Query()
+	body, _ := io.ReadAll(req.Body)
+	copySource := req.Header.Get("X-Amz-Copy-Source")
+	switch {
+	case req.Method == http.MethodGet && key == "":
+		s.ops = append(s.ops, "ListObjectsV2")
+		s.list(rw, query)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		s.ops = append(s.ops, req.Method+"Object")
+		data, ok := s.objects[key]
+		if !ok {
+			s.fail(rw, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		rw.Header().Set("ETag", s3StubETag(data))
+		if s.ignoreRange {
+			req.Header.Del("Range")
+		}
+		http.ServeContent(rw, req, "", s3StubModTime, bytes.NewReader(data))
+	case req.Method == http.MethodPut && query.Get("uploadId") != "":
+		parts, ok := s.uploads[query.Get("uploadId")]
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if !ok || number < 1 {
+			s.fail(rw, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if copySource == "" {
+			s.ops = append(s.ops, "UploadPart")
+			parts[number] = body
+			rw.Header().Set("ETag", s3StubETag(body))
+			return
+		}
+		s.ops = append(s.ops, "UploadPartCopy")
+		src, ok := s.source(copySource)
+		var first, last int
+		if _, err := fmt.Sscanf(req.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &first, &last); err != nil ||
+			!ok || last >= len(src) || first > last {
+			s.fail(rw, http.StatusBadRequest, "InvalidRange")
+			return
+		}
+		parts[number] = append([]byte(nil), src[first:last+1]...)
+		s.writeXML(rw, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: s3StubETag(parts[number])})
+	case req.Method == http.MethodPut && copySource != "":
+		s.ops = append(s.ops, "CopyObject")
+		src, ok := s.source(copySource)
+		if !ok {
+			s.fail(rw, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if s.copyError {
+			s.fail(rw, http.StatusOK, "InternalError")
+			return
+		}
+		s.objects[key] = append([]byte(nil), src...)
+		s.writeXML(rw, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: s3StubETag(src)})
+	case req.Method == http.MethodPut:
+		s.ops = append(s.ops, "PutObject")
+		s.objects[key] = body
+		rw.Header().Set("ETag", s3StubETag(body))
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		s.ops = append(s.ops, "CreateMultipartUpload")
+		s.nextID++
+		uploadID := fmt.Sprintf("upload%d", s.nextID)
+		s.uploads[uploadID] = make(map[int][]byte)
+		s.writeXML(rw, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: uploadID})
+	case req.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.ops = append(s.ops, "CompleteMultipartUpload")
+		parts, ok := s.uploads[query.Get("uploadId")]
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if !ok || xml.Unmarshal(body, &complete) != nil {
+			s.fail(rw, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || parts[part.PartNumber] == nil {
+				s.fail(rw, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, parts[part.PartNumber]...)
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		s.objects[key] = data
+		s.writeXML(rw, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		}{})
+	case req.Method == http.MethodDelete && query.Get("uploadId") != "":
+		s.ops = append(s.ops, "AbortMultipartUpload")
+		delete(s.uploads, query.Get("uploadId"))
+		rw.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodDelete:
+		s.ops = append(s.ops, "DeleteObject")
+		delete(s.objects, key)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(rw, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *s3Stub) source(copySource string) ([]byte, bool) {
+	src, err := url.PathUnescape(copySource)
+	if err != nil {
+		return nil, false
+	}
+	data, ok := s.objects[strings.TrimPrefix(src, "/"+s3TestBucket+"/")]
+	return data, ok
+}
+
+// list answers ListObjectsV2, the continuation token is the index of the
+// next entry.
+func (s *s3Stub) list(rw http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var entries []interface{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			common := key[:len(prefix)+i+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				entries = append(entries, s3StubPrefix{Prefix: common})
+			}
+			continue
+		}
+		data := s.objects[key]
+		entries = append(entries, s3StubObject{Key: key, LastModified: s3StubModTime.Format(time.RFC3339),
+			ETag: s3StubETag(data), Size: len(data)})
+	}
+	maxKeys := s.pageSize
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := start + maxKeys
+	var res s3StubList
+	if end < len(entries) {
+		res.IsTruncated = true
+		res.NextContinuationToken = strconv.Itoa(end)
+	} else {
+		end = len(entries)
+	}
+	for _, entry := range entries[start:end] {
+		switch entry := entry.(type) {
+		case s3StubObject:
+			res.Contents = append(res.Contents, entry)
+		case s3StubPrefix:
+			res.CommonPrefixes = append(res.CommonPrefixes, entry)
+		}
+	}
+	s.writeXML(rw, res)
+}
+
+func newS3Volume(t *testing.T, stub *s3Stub, partSize int64) *volumes.S3Volume {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	vol, err := volumes.NewS3(volumes.S3Config{Endpoint: srv.URL, Bucket: s3TestBucket, Prefix: "users/alice",
+		PathStyle: true, AccessKey: "key", SecretKey: "secret", PartSize: partSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vol
+}
+
+func writeFile(t *testing.T, vol volumes.FsVolume, name string, data []byte) {
+	t.Helper()
+	w, err := vol.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		return newS3Volume(t, newS3Stub(), 0)
+	})
+}
+
+func TestS3ReadDirPages(t *testing.T) {
+	stub := newS3Stub()
+	stub.pageSize = 2
+	vol := newS3Volume(t, stub, 0)
+	var want []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("f%d.txt", i)
+		writeFile(t, vol, name, []byte(name))
+		want = append(want, name)
+	}
+	for _, name := range []string{"d0", "d1"} {
+		if err := vol.Mkdir(name); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, vol, name+"/inner.txt", nil)
+		want = append(want, name)
+	}
+	sort.Strings(want)
+	lists := stub.count("ListObjectsV2")
+	entries, err := vol.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := range entries {
+		got = append(got, entries[i].Name())
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("entries %v, want %v", got, want)
+	}
+	if pages := stub.count("ListObjectsV2") - lists; pages < 4 {
+		t.Errorf("%d pages listed, want at least 4", pages)
+	}
+}
+
+func TestS3MultipartCreate(t *testing.T) {
+	stub := newS3Stub()
+	vol := newS3Volume(t, stub, 5<<20)
+	data := bytes.Repeat([]byte("0123456789abcdef"), (12<<20)/16+3)
+	writeFile(t, vol, "big.bin", data)
+	if n := stub.count("CreateMultipartUpload"); n != 1 {
+		t.Errorf("%d multipart uploads", n)
+	}
+	if n := stub.count("UploadPart"); n != 3 {
+		t.Errorf("%d parts uploaded, want 3", n)
+	}
+	if n := stub.count("CompleteMultipartUpload"); n != 1 {
+		t.Errorf("%d multipart uploads completed", n)
+	}
+	got, err := fs.ReadFile(vol, "big.bin")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("read back %d bytes of %d, %v", len(got), len(data), err)
+	}
+
+	writeFile(t, vol, "small.bin", []byte("small"))
+	if n := stub.count("PutObject"); n != 1 {
+		t.Errorf("%d single part uploads", n)
+	}
+}
+
+func TestS3OpenRange(t *testing.T) {
+	for _, ignoreRange := range []bool{false, true} {
+		stub := newS3Stub()
+		stub.ignoreRange = ignoreRange
+		vol := newS3Volume(t, stub, 0)
+		writeFile(t, vol, "a.txt", []byte("0123456789"))
+		f, err := vol.Open("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeker, ok := f.(io.ReadSeeker)
+		if !ok {
+			t.Fatal("file cannot seek")
+		}
+		if _, err = seeker.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := io.ReadAll(seeker); err != nil || string(data) != "6789" {
+			t.Errorf("read after seek, ignoring Range %v: %q %v", ignoreRange, data, err)
+		}
+		_ = f.Close()
+	}
+}
+
+func TestS3RenameCopiesAndDeletes(t *testing.T) {
+	stub := newS3Stub()
+	vol := newS3Volume(t, stub, 0)
+	writeFile(t, vol, "a.txt", []byte("a"))
+	if err := vol.Rename("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if stub.count("CopyObject") != 1 || stub.count("DeleteObject") != 1 {
+		t.Errorf("file rename: %v", stub.ops)
+	}
+	if err := vol.Mkdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, vol, "dir/c.txt", []byte("c"))
+	if err := vol.Rename("dir", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(vol, "moved/c.txt"); err != nil || string(data) != "c" {
+		t.Errorf("moved/c.txt: %q %v", data, err)
+	}
+	if _, err := vol.Stat("dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("dir after rename: %v", err)
+	}
+
+	volumes.SetS3CopyLimits(vol, 4, 3)
+	writeFile(t, vol, "large.bin", []byte("0123456789"))
+	if err := vol.Rename("large.bin", "copied.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.count("UploadPartCopy"); n != 4 {
+		t.Errorf("%d parts copied, want 4", n)
+	}
+	if data, err := fs.ReadFile(vol, "copied.bin"); err != nil || string(data) != "0123456789" {
+		t.Errorf("copied.bin: %q %v", data, err)
+	}
+}
+
+func TestS3CopyErrorInBody(t *testing.T) {
+	stub := newS3Stub()
+	vol := newS3Volume(t, stub, 0)
+	writeFile(t, vol, "a.txt", []byte("a"))
+	stub.copyError = true
+	err := vol.Rename("a.txt", "b.txt")
+	var s3Err *volumes.S3Error
+	if !errors.As(err, &s3Err) || s3Err.Code != "InternalError" {
+		t.Fatalf("rename with a failed copy: %v", err)
+	}
+	if _, err = vol.Stat("a.txt"); err != nil {
+		t.Errorf("source removed after a failed copy: %v", err)
+	}
+}
@@ -0,0 +1,718 @@
+package volumes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/LeeEirc/elfinder/errs"
+)
+
+const (
+	defaultSFTPPoolSize    = 4
+	defaultSFTPKeepAlive   = 30 * time.Second
+	defaultSFTPDialTimeout = 10 * time.Second
+)
+
+var (
+	ErrHostKeyNotVerified = errors.New("sftp host key verification not configured")
+	ErrOutsideRoot        = errors.New("path resolves outside of the volume root")
+)
+
+var (
+	_ FsVolume  = (*SFTPVolume)(nil)
+	_ Confiner  = (*SFTPVolume)(nil)
+	_ fs.StatFS = (*SFTPVolume)(nil)
+)
+
+// SFTPConfig addresses a directory on an SFTP server. One of HostKeyCallback,
+// KnownHosts and HostKeyFingerprints must be set, an unverified server is
+// only accepted with HostKeyCallback set to ssh.InsecureIgnoreHostKey().
+type SFTPConfig struct {
+	// Addr is the "host:port" of the server.
+	Addr     string
+	User     string
+	Password string
+	Signers  []ssh.Signer
+	// Root is the remote directory of the volume root, it defaults to the
+	// login directory.
+	Root string
+	// Name is the volume name, it defaults to the base name of Root.
+	Name string
+
+	HostKeyCallback ssh.HostKeyCallback
+	// KnownHosts are OpenSSH known_hosts files to verify the host key with.
+	KnownHosts []string
+	// HostKeyFingerprints are accepted "SHA256:..." fingerprints as printed
+	// by ssh-keygen -l.
+	HostKeyFingerprints []string
+
+	// PoolSize is the number of SSH connections, 4 by default. Requests
+	// are spread over the connections, each serves many requests at once.
+	PoolSize int
+	// KeepAlive is the interval of keepalive requests, 30 seconds by
+	// default, a negative value disables them. A connection not answering
+	// within the interval is replaced.
+	KeepAlive   time.Duration
+	DialTimeout time.Duration
+}
+
+// SFTPVolume is an FsVolume on a directory of an SFTP server. Connections
+// are dialed on first use and replaced when they fail, an operation failing
+// with a lost connection is retried once on a new one. Symlinks resolving
+// outside of the root are rejected by Confine.
+type SFTPVolume struct {
+	cfg    SFTPConfig
+	sshCfg *ssh.ClientConfig
+	name   string
+	root   string
+	slots  []sftpSlot
+	next   uint32
+
+	mu       sync.Mutex
+	realRoot string
+}
+
+func NewSFTP(cfg SFTPConfig) (*SFTPVolume, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("%w: sftp address not set", fs.ErrInvalid)
+	}
+	hostKeyCallback, err := sftpHostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = defaultSFTPPoolSize
+	}
+	if cfg.KeepAlive == 0 {
+		cfg.KeepAlive = defaultSFTPKeepAlive
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultSFTPDialTimeout
+	}
+	var auth []ssh.AuthMethod
+	if len(cfg.Signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(cfg.Signers...))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	root := path.Clean(cfg.Root)
+	name := cfg.Name
+	if name == "" {
+		name = path.Base(root)
+		if name == "." || name == "/" {
+			name = cfg.User
+		}
+	}
+	return &SFTPVolume{
+		cfg: cfg,
+		sshCfg: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         cfg.DialTimeout,
+		},
+		name:  name,
+		root:  root,
+		slots: make([]sftpSlot, cfg.PoolSize),
+	}, nil
+}
+
+// sftpHostKeyCallback accepts a host key matching any of the configured
+// verification options.
+func sftpHostKeyCallback(cfg SFTPConfig) (ssh.HostKeyCallback, error) {
+	var callbacks []ssh.HostKeyCallback
+	if cfg.HostKeyCallback != nil {
+		callbacks = append(callbacks, cfg.HostKeyCallback)
+	}
+	if len(cfg.KnownHosts) > 0 {
+		callback, err := knownhosts.New(cfg.KnownHosts...)
+		if err != nil {
+			return nil, err
+		}
+		callbacks = append(callbacks, callback)
+	}
+	if len(cfg.HostKeyFingerprints) > 0 {
+		fingerprints := append([]string(nil), cfg.HostKeyFingerprints...)
+		callbacks = append(callbacks, func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			for i := range fingerprints {
+				if fingerprints[i] == fingerprint {
+					return nil
+				}
+			}
+			return fmt.Errorf("sftp host key %s of %s not accepted", fingerprint, hostname)
+		})
+	}
+	switch len(callbacks) {
+	case 0:
+		return nil, ErrHostKeyNotVerified
+	case 1:
+		return callbacks[0], nil
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		var err error
+		for i := range callbacks {
+			if err = callbacks[i](hostname, remote, key); err == nil {
+				return nil
+			}
+		}
+		return err
+	}, nil
+}
+
+func (s *SFTPVolume) Name() string {
+	return s.name
+}
+
+// Close closes the connections of the volume, later operations reconnect.
+func (s *SFTPVolume) Close() error {
+	for i := range s.slots {
+		slot := &s.slots[i]
+		slot.mu.Lock()
+		conn := slot.conn
+		slot.conn = nil
+		slot.mu.Unlock()
+		if conn != nil {
+			conn.close()
+		}
+	}
+	return nil
+}
+
+type sftpSlot struct {
+	mu   sync.Mutex
+	conn *sftpConn
+}
+
+type sftpConn struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (c *sftpConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		// the sftp client waits for its pending requests, closing the
+		// transport first fails them on a stalled connection
+		_ = c.ssh.Close()
+		_ = c.client.Close()
+	})
+}
+
+// conn returns the connection of slot, dialing a new one if needed.
+func (s *SFTPVolume) conn(slot *sftpSlot) (*sftpConn, error) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.conn != nil {
+		return slot.conn, nil
+	}
+	netConn, err := net.DialTimeout("tcp", s.cfg.Addr, s.cfg.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = netConn.SetDeadline(time.Now().Add(s.cfg.DialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, s.cfg.Addr, s.sshCfg)
+	if err != nil {
+		_ = netConn.Close()
+		if isSFTPConnErr(err) {
+			return nil, err
+		}
+		// a rejected host key or login
+		return nil, &typedError{err: err, errType: errs.ERRAccess}
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, err
+	}
+	conn := &sftpConn{ssh: sshClient, client: client, done: make(chan struct{})}
+	go func() {
+		_ = sshClient.Wait()
+		s.drop(slot, conn)
+	}()
+	if s.cfg.KeepAlive > 0 {
+		go s.keepAlive(slot, conn)
+	}
+	slot.conn = conn
+	return conn, nil
+}
+
+func (s *SFTPVolume) keepAlive(slot *sftpSlot, conn *sftpConn) {
+	ticker := time.NewTicker(s.cfg.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.C:
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := conn.ssh.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case <-conn.done:
+				return
+			case err := <-reply:
+				if err == nil {
+					continue
+				}
+			case <-time.After(s.cfg.KeepAlive):
+				// a stalled connection, closing it fails its pending requests
+			}
+			s.drop(slot, conn)
+			return
+		}
+	}
+}
+
+// drop closes conn and removes it from slot so the next use reconnects.
+func (s *SFTPVolume) drop(slot *sftpSlot, conn *sftpConn) {
+	slot.mu.Lock()
+	if slot.conn == conn {
+		slot.conn = nil
+	}
+	slot.mu.Unlock()
+	conn.close()
+}
+
+// do runs fn with the client of the next connection. It is retried once on a
+// new connection when the connection fails.
+func (s *SFTPVolume) do(fn func(client *sftp.Client) error) error {
+	slot := &s.slots[atomic.AddUint32(&s.next, 1)%uint32(len(s.slots))]
+	for attempt := 0; ; attempt++ {
+		conn, err := s.conn(slot)
+		if err == nil {
+			if err = fn(conn.client); err == nil || !isSFTPConnErr(err) {
+				return err
+			}
+			s.drop(slot, conn)
+		}
+		if attempt > 0 || !isSFTPConnErr(err) {
+			return err
+		}
+	}
+}
+
+func (s *SFTPVolume) remotePath(name string) string {
+	return path.Join(s.root, name)
+}
+
+func (s *SFTPVolume) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	var info fs.FileInfo
+	err := s.do(func(client *sftp.Client) (err error) {
+		info, err = client.Stat(s.remotePath(name))
+		return err
+	})
+	if err != nil {
+		return nil, sftpPathError("stat", name, err)
+	}
+	return sftpFileInfo{FileInfo: info, name: path.Base(name)}, nil
+}
+
+func (s *SFTPVolume) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sftpDir{info: info, entries: entries}, nil
+	}
+	var f *sftp.File
+	err = s.do(func(client *sftp.Client) (err error) {
+		f, err = client.Open(s.remotePath(name))
+		return err
+	})
+	if err != nil {
+		return nil, sftpPathError("open", name, err)
+	}
+	return &sftpFile{File: f, info: info}, nil
+}
+
+// ReadDir lists name sorted by name. Symlinks are reported as their target,
+// dangling ones and those leading out of the root are left out.
+func (s *SFTPVolume) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	realRoot, err := s.rootPath()
+	if err != nil {
+		return nil, sftpPathError("readdir", name, err)
+	}
+	dirPath := s.remotePath(name)
+	var infos []fs.FileInfo
+	err = s.do(func(client *sftp.Client) (err error) {
+		infos, err = client.ReadDir(dirPath)
+		if err != nil {
+			return err
+		}
+		res := infos[:0]
+		for _, info := range infos {
+			if info.Mode()&fs.ModeSymlink != 0 {
+				linkPath := path.Join(dirPath, info.Name())
+				realPath, err := sftpResolve(client, "/", linkPath)
+				if err == nil && !inSFTPRoot(realRoot, realPath) {
+					continue
+				}
+				if err == nil {
+					info, err = client.Stat(linkPath)
+				}
+				if err != nil {
+					if isSFTPConnErr(err) {
+						return err
+					}
+					continue
+				}
+				info = sftpFileInfo{FileInfo: info, name: path.Base(linkPath)}
+			}
+			res = append(res, info)
+		}
+		infos = res
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, sftpPathError("readdir", name, err)
+		}
+		// servers fail opening files as folders with a generic error
+		if info, statErr := s.Stat(name); statErr == nil && !info.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+		}
+		return nil, sftpPathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for i := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(infos[i]))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Confine resolves the symlinks of name and fails with ErrOutsideRoot when it
+// leads out of the root. Links are followed by the client, servers differ in
+// whether realpath resolves them.
+func (s *SFTPVolume) Confine(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "confine", Path: name, Err: fs.ErrInvalid}
+	}
+	realRoot, err := s.rootPath()
+	if err != nil {
+		return sftpPathError("confine", name, err)
+	}
+	if name == "." {
+		return nil
+	}
+	var realPath string
+	err = s.do(func(client *sftp.Client) (err error) {
+		realPath, err = sftpResolve(client, realRoot, name)
+		return err
+	})
+	if err != nil {
+		return sftpPathError("confine", name, err)
+	}
+	if !inSFTPRoot(realRoot, realPath) {
+		return &fs.PathError{Op: "confine", Path: name, Err: ErrOutsideRoot}
+	}
+	return nil
+}
+
+func inSFTPRoot(realRoot, realPath string) bool {
+	return realPath == realRoot || strings.HasPrefix(realPath, strings.TrimSuffix(realRoot, "/")+"/")
+}
+
+func (s *SFTPVolume) rootPath() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.realRoot != "" {
+		return s.realRoot, nil
+	}
+	err := s.do(func(client *sftp.Client) error {
+		root := s.root
+		if !path.IsAbs(root) {
+			wd, err := client.Getwd()
+			if err != nil {
+				return err
+			}
+			root = path.Join(wd, root)
+		}
+		realRoot, err := sftpResolve(client, "/", root)
+		s.realRoot = realRoot
+		return err
+	})
+	return s.realRoot, err
+}
+
+// sftpResolve follows the symlinks of the elements of name below the resolved
+// folder dir. The missing part of name is joined as is.
+func sftpResolve(client *sftp.Client, dir, name string) (string, error) {
+	resolved := dir
+	rest := strings.Split(name, "/")
+	for hops := 0; len(rest) > 0; {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, elem)
+		info, err := client.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", ErrSymlink
+		}
+		target, err := client.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// Create opens name for writing on one connection. The connection is not
+// retried, a failed upload has to be started again.
+func (s *SFTPVolume) Create(name string) (io.ReadWriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	var f *sftp.File
+	err := s.do(func(client *sftp.Client) (err error) {
+		if info, err := client.Stat(s.remotePath(name)); err == nil && info.IsDir() {
+			return fs.ErrExist
+		}
+		f, err = client.OpenFile(s.remotePath(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		return err
+	})
+	if err != nil {
+		return nil, sftpPathError("create", name, err)
+	}
+	return f, nil
+}
+
+func (s *SFTPVolume) Mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	err := s.do(func(client *sftp.Client) error {
+		err := client.Mkdir(s.remotePath(name))
+		if err != nil && !isSFTPConnErr(err) {
+			// the status of most servers does not tell why it failed
+			if _, statErr := client.Lstat(s.remotePath(name)); statErr == nil {
+				return fs.ErrExist
+			}
+			if _, statErr := client.Stat(s.remotePath(path.Dir(name))); errors.Is(statErr, fs.ErrNotExist) {
+				return fs.ErrNotExist
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return sftpPathError("mkdir", name, err)
+	}
+	return nil
+}
+
+func (s *SFTPVolume) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	remotePath := s.remotePath(name)
+	err := s.do(func(client *sftp.Client) error {
+		info, err := client.Lstat(remotePath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return client.Remove(remotePath)
+		}
+		if err = client.RemoveDirectory(remotePath); err != nil && !isSFTPConnErr(err) {
+			if entries, readErr := client.ReadDir(remotePath); readErr == nil && len(entries) > 0 {
+				return ErrDirNotEmpty
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return sftpPathError("remove", name, err)
+	}
+	return nil
+}
+
+// Rename uses the posix-rename extension when the server has it, otherwise
+// an existing file or empty folder at new is removed first.
+func (s *SFTPVolume) Rename(old, new string) error {
+	if !fs.ValidPath(old) || !fs.ValidPath(new) {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if old == "." || new == "." {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	if strings.HasPrefix(new, old+"/") {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	oldPath, newPath := s.remotePath(old), s.remotePath(new)
+	err := s.do(func(client *sftp.Client) error {
+		oldInfo, err := client.Lstat(oldPath)
+		if err != nil {
+			return err
+		}
+		if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+			return client.PosixRename(oldPath, newPath)
+		}
+		if newInfo, err := client.Lstat(newPath); err == nil && old != new {
+			switch {
+			case newInfo.IsDir() && !oldInfo.IsDir():
+				return fs.ErrExist
+			case !newInfo.IsDir() && oldInfo.IsDir():
+				return fs.ErrInvalid
+			case newInfo.IsDir():
+				err = client.RemoveDirectory(newPath)
+			default:
+				err = client.Remove(newPath)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return client.Rename(oldPath, newPath)
+	})
+	if err != nil {
+		return sftpPathError("rename", old, err)
+	}
+	return nil
+}
+
+func sftpPathError(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: mapSFTPError(err)}
+}
+
+// mapSFTPError attaches the elFinder error type to the failures the client
+// can be told about, e.g. an unreachable server.
+func mapSFTPError(err error) error {
+	var typed *typedError
+	var statusErr *sftp.StatusError
+	switch {
+	case errors.As(err, &typed):
+		return err
+	case isTimeout(err):
+		return &typedError{err: err, errType: errs.ERRTimeout}
+	case isSFTPConnErr(err):
+		return &typedError{err: err, errType: errs.ERRConnect}
+	case errors.Is(err, fs.ErrPermission):
+		return &typedError{err: err, errType: errs.ERRPerm}
+	case errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported:
+		return &typedError{err: err, errType: errs.ERRCmdNoSupport}
+	}
+	return err
+}
+
+// isSFTPConnErr reports whether err means the connection is unusable.
+func isSFTPConnErr(err error) bool {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, sftp.ErrSSHFxConnectionLost), errors.Is(err, sftp.ErrSSHFxNoConnection),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return true
+	case errors.As(err, &opErr), errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// sftpFileInfo keeps the name Stat was called with, servers report the
+// resolved name of symlinks.
+type sftpFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i sftpFileInfo) Name() string { return i.name }
+
+type sftpFile struct {
+	*sftp.File
+	info fs.FileInfo
+}
+
+func (f *sftpFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+type sftpDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *sftpDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *sftpDir) Close() error               { return nil }
+
+func (d *sftpDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *sftpDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
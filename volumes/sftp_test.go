@@ -0,0 +1,275 @@
+package volumes_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+// sftpServer is an in-process SSH server with the sftp subsystem, serving
+// the local file system to the password "secret".
+type sftpServer struct {
+	addr string
+	ln   net.Listener
+	key  ssh.PublicKey
+
+	mu       sync.Mutex
+	conns    []*sftpServerConn
+	accepted int
+}
+
+// sftpServerConn stops reading once stalled, the client sees a server that
+// no longer answers.
+type sftpServerConn struct {
+	net.Conn
+	stalled chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (c *sftpServerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	select {
+	case <-c.stalled:
+		<-c.closed
+		return 0, net.ErrClosed
+	default:
+	}
+	return n, err
+}
+
+func (c *sftpServerConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func startSFTP(t *testing.T) *sftpServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if string(password) != "secret" {
+			return nil, errors.New("password rejected")
+		}
+		return nil, nil
+	}}
+	cfg.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sftpServer{addr: ln.Addr().String(), ln: ln, key: signer.PublicKey()}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.closeConns()
+	})
+	go func() {
+		for {
+			netConn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn := &sftpServerConn{Conn: netConn, stalled: make(chan struct{}), closed: make(chan struct{})}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.accepted++
+			s.mu.Unlock()
+			go serveSSH(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				_ = req.Reply(req.Type == "subsystem", nil)
+			}
+		}()
+		go func() {
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			_ = server.Serve()
+			_ = server.Close()
+		}()
+	}
+}
+
+func (s *sftpServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *sftpServer) stallConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		close(conn.stalled)
+	}
+}
+
+func (s *sftpServer) acceptedConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func newSFTPVolume(t *testing.T, s *sftpServer, cfg volumes.SFTPConfig) *volumes.SFTPVolume {
+	t.Helper()
+	cfg.Addr, cfg.User, cfg.Password = s.addr, "alice", "secret"
+	if cfg.Root == "" {
+		cfg.Root = t.TempDir()
+	}
+	if cfg.HostKeyCallback == nil && cfg.KnownHosts == nil && cfg.HostKeyFingerprints == nil {
+		cfg.HostKeyFingerprints = []string{ssh.FingerprintSHA256(s.key)}
+	}
+	vol, err := volumes.NewSFTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = vol.Close() })
+	return vol
+}
+
+func checkErrType(t *testing.T, err error, want errs.ErrType) {
+	t.Helper()
+	var typed volumes.ErrTyper
+	if !errors.As(err, &typed) || typed.ErrType() != want {
+		t.Errorf("error %v, want type %s", err, want)
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	s := startSFTP(t)
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		return newSFTPVolume(t, s, volumes.SFTPConfig{})
+	})
+}
+
+func TestSFTPPool(t *testing.T) {
+	s := startSFTP(t)
+	vol := newSFTPVolume(t, s, volumes.SFTPConfig{PoolSize: 2})
+	for i := 0; i < 10; i++ {
+		if _, err := vol.Stat("."); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.acceptedConns(); n != 2 {
+		t.Errorf("%d connections for a pool of 2", n)
+	}
+}
+
+func TestSFTPReconnect(t *testing.T) {
+	s := startSFTP(t)
+	vol := newSFTPVolume(t, s, volumes.SFTPConfig{PoolSize: 1, KeepAlive: -1})
+	writeFile(t, vol, "a.txt", []byte("a"))
+	s.closeConns()
+	if _, err := vol.Stat("a.txt"); err != nil {
+		t.Fatalf("stat after the connection dropped: %v", err)
+	}
+	if n := s.acceptedConns(); n != 2 {
+		t.Errorf("%d connections, want a second one", n)
+	}
+}
+
+func TestSFTPKeepAliveDropsStalledConn(t *testing.T) {
+	s := startSFTP(t)
+	vol := newSFTPVolume(t, s, volumes.SFTPConfig{PoolSize: 1, KeepAlive: 50 * time.Millisecond})
+	writeFile(t, vol, "a.txt", []byte("a"))
+	s.stallConns()
+	done := make(chan error, 1)
+	go func() {
+		_, err := vol.Stat("a.txt")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("stat on a stalled connection: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stat hangs on a stalled connection")
+	}
+	if n := s.acceptedConns(); n != 2 {
+		t.Errorf("%d connections, want a second one", n)
+	}
+}
+
+func TestSFTPHostKey(t *testing.T) {
+	s := startSFTP(t)
+	if _, err := volumes.NewSFTP(volumes.SFTPConfig{Addr: s.addr}); !errors.Is(err, volumes.ErrHostKeyNotVerified) {
+		t.Errorf("volume without host key verification: %v", err)
+	}
+
+	vol := newSFTPVolume(t, s, volumes.SFTPConfig{HostKeyFingerprints: []string{"SHA256:unknown"}})
+	_, err := vol.Stat(".")
+	checkErrType(t, err, errs.ERRAccess)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := "[" + strings.Replace(s.addr, ":", "]:", 1) + " " + string(ssh.MarshalAuthorizedKey(s.key))
+	if err = os.WriteFile(knownHosts, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vol = newSFTPVolume(t, s, volumes.SFTPConfig{KnownHosts: []string{knownHosts}})
+	if _, err = vol.Stat("."); err != nil {
+		t.Errorf("host key in known_hosts: %v", err)
+	}
+}
+
+func TestSFTPErrTypes(t *testing.T) {
+	s := startSFTP(t)
+	root := t.TempDir()
+	vol := newSFTPVolume(t, s, volumes.SFTPConfig{Root: root})
+	if err := vol.Mkdir("locked"); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() != 0 {
+		if err := os.Chmod(filepath.Join(root, "locked"), 0555); err != nil {
+			t.Fatal(err)
+		}
+		_, err := vol.Create("locked/a.txt")
+		checkErrType(t, err, errs.ERRPerm)
+	}
+
+	_ = s.ln.Close()
+	s.closeConns()
+	_, err := vol.Stat(".")
+	checkErrType(t, err, errs.ERRConnect)
+}
@@ -0,0 +1,310 @@
+package volumestest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FTPServer is an in-process FTP server on a local folder, for testing FTP
+// volumes without a real server. It knows the commands volumes.FTPVolume
+// sends: passive transfers with EPSV, listings with MLST and MLSD, and
+// REST for ranged downloads.
+type FTPServer struct {
+	// Addr is the "host:port" the server listens on.
+	Addr     string
+	User     string
+	Password string
+
+	root string
+	ln   net.Listener
+
+	mu     sync.Mutex
+	conns  []net.Conn
+	logins int
+}
+
+// StartFTP serves root to the user "alice" with the password "secret" until
+// the end of the test.
+func StartFTP(t testing.TB, root string) *FTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &FTPServer{Addr: ln.Addr().String(), User: "alice", Password: "secret", root: root, ln: ln}
+	t.Cleanup(s.Close)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Logins returns the number of successful logins so far.
+func (s *FTPServer) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// CloseConns closes the control connections of the clients, as a server
+// restart would.
+func (s *FTPServer) CloseConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+// Close stops the server and closes its connections.
+func (s *FTPServer) Close() {
+	_ = s.ln.Close()
+	s.CloseConns()
+}
+
+type ftpSession struct {
+	s          *FTPServer
+	conn       net.Conn
+	r          *bufio.Reader
+	user       string
+	loggedIn   bool
+	pasv       net.Listener
+	rest       int64
+	renameFrom string
+}
+
+func (s *FTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &ftpSession{s: s, conn: conn, r: bufio.NewReader(conn)}
+	defer c.closePasv()
+	c.reply(220, "ready")
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg := strings.TrimRight(line, "\r\n"), ""
+		if i := strings.IndexByte(cmd, ' '); i >= 0 {
+			cmd, arg = cmd[:i], cmd[i+1:]
+		}
+		if !c.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+func (c *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(c.conn, "%d %s\r\n", code, msg)
+}
+
+// local maps the remote path p to the file system, the login folder is
+// the root.
+func (c *ftpSession) local(p string) string {
+	return filepath.Join(c.s.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// handle runs one command and reports whether the session goes on.
+func (c *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		c.user = arg
+		c.reply(331, "password required")
+		return true
+	case "PASS":
+		if c.user != c.s.User || arg != c.s.Password {
+			c.reply(530, "login incorrect")
+			return true
+		}
+		c.loggedIn = true
+		c.s.mu.Lock()
+		c.s.logins++
+		c.s.mu.Unlock()
+		c.reply(230, "logged in")
+		return true
+	case "FEAT":
+		fmt.Fprint(c.conn, "211-Features:\r\n MLST type*;size*;modify*;\r\n UTF8\r\n EPSV\r\n REST STREAM\r\n211 End\r\n")
+		return true
+	case "QUIT":
+		c.reply(221, "bye")
+		return false
+	}
+	if !c.loggedIn {
+		c.reply(530, "not logged in")
+		return true
+	}
+	switch cmd {
+	case "TYPE", "OPTS", "NOOP":
+		c.reply(200, "ok")
+	case "PWD":
+		c.reply(257, `"/"`)
+	case "EPSV":
+		c.closePasv()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			c.reply(425, err.Error())
+			break
+		}
+		c.pasv = ln
+		c.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", ln.Addr().(*net.TCPAddr).Port))
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			c.reply(501, "invalid offset")
+			break
+		}
+		c.rest = offset
+		c.reply(350, "restarting")
+	case "MLST":
+		info, err := os.Stat(c.local(arg))
+		if err != nil {
+			c.reply(550, err.Error())
+			break
+		}
+		fmt.Fprintf(c.conn, "250-Listing %s\r\n %s\r\n250 End\r\n", arg, ftpFacts(info, path.Base("/"+arg)))
+	case "MLSD":
+		entries, err := os.ReadDir(c.local(arg))
+		if err != nil {
+			c.closePasv()
+			c.reply(550, err.Error())
+			break
+		}
+		c.transfer(func(data net.Conn) error {
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				if _, err = fmt.Fprintf(data, "%s\r\n", ftpFacts(info, entry.Name())); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "RETR":
+		f, err := os.Open(c.local(arg))
+		if err == nil {
+			_, err = f.Seek(c.rest, io.SeekStart)
+		}
+		c.rest = 0
+		if err != nil {
+			c.closePasv()
+			c.reply(550, err.Error())
+			break
+		}
+		c.transfer(func(data net.Conn) error {
+			_, err := io.Copy(data, f)
+			return err
+		})
+		_ = f.Close()
+	case "STOR":
+		f, err := os.Create(c.local(arg))
+		if err != nil {
+			c.closePasv()
+			c.reply(550, err.Error())
+			break
+		}
+		c.transfer(func(data net.Conn) error {
+			_, err := io.Copy(f, data)
+			return err
+		})
+		_ = f.Close()
+	case "MKD":
+		c.result(257, os.Mkdir(c.local(arg), 0755))
+	case "RMD", "DELE":
+		name := c.local(arg)
+		info, err := os.Lstat(name)
+		if err == nil && info.IsDir() != (cmd == "RMD") {
+			err = fs.ErrInvalid
+		}
+		if err == nil {
+			err = os.Remove(name)
+		}
+		c.result(250, err)
+	case "RNFR":
+		_, err := os.Lstat(c.local(arg))
+		if err == nil {
+			c.renameFrom = c.local(arg)
+			c.reply(350, "ready for RNTO")
+			break
+		}
+		c.result(350, err)
+	case "RNTO":
+		err := fs.ErrNotExist
+		if c.renameFrom != "" {
+			err = os.Rename(c.renameFrom, c.local(arg))
+		}
+		c.renameFrom = ""
+		c.result(250, err)
+	default:
+		c.reply(502, "not implemented")
+	}
+	return true
+}
+
+func (c *ftpSession) result(code int, err error) {
+	if err != nil {
+		c.reply(550, err.Error())
+		return
+	}
+	c.reply(code, "ok")
+}
+
+// transfer runs fn on the data connection of the last EPSV.
+func (c *ftpSession) transfer(fn func(data net.Conn) error) {
+	if c.pasv == nil {
+		c.reply(425, "use EPSV first")
+		return
+	}
+	_ = c.pasv.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	data, err := c.pasv.Accept()
+	c.closePasv()
+	if err != nil {
+		c.reply(425, err.Error())
+		return
+	}
+	c.reply(150, "opening data connection")
+	err = fn(data)
+	_ = data.Close()
+	if err != nil {
+		c.reply(426, err.Error())
+		return
+	}
+	c.reply(226, "transfer complete")
+}
+
+func (c *ftpSession) closePasv() {
+	if c.pasv != nil {
+		_ = c.pasv.Close()
+		c.pasv = nil
+	}
+}
+
+func ftpFacts(info fs.FileInfo, name string) string {
+	kind := "file"
+	if info.IsDir() {
+		kind = "dir"
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s; %s", kind, info.Size(),
+		info.ModTime().UTC().Format("20060102150405"), name)
+}
@@ -0,0 +1,305 @@
+// Package volumestest checks volumes.FsVolume implementations against the
+// behaviour the connector relies on, and runs stand-ins of the servers of
+// remote volumes.
+package volumestest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/LeeEirc/elfinder/volumes"
+)
+
+// Factory returns a new, empty and writable volume for a single test case.
+// It can release the volume with t.Cleanup.
+type Factory func(t *testing.T) volumes.FsVolume
+
+// Run runs the conformance tests against the volumes made by factory:
+//
+//	func TestMemory(t *testing.T) {
+//		volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+//			return volumes.NewMemory("test")
+//		})
+//	}
+func Run(t *testing.T, factory Factory) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, vol volumes.FsVolume)
+	}{
+		{"TestFS", testFS},
+		{"Create", testCreate},
+		{"Mkdir", testMkdir},
+		{"Remove", testRemove},
+		{"Rename", testRename},
+		{"ReadDir", testReadDir},
+		{"NotExist", testNotExist},
+		{"InvalidPath", testInvalidPath},
+	}
+	for i := range cases {
+		fn := cases[i].fn
+		t.Run(cases[i].name, func(t *testing.T) {
+			fn(t, factory(t))
+		})
+	}
+}
+
+var seedFiles = map[string]string{
+	"top.txt":       "top",
+	"a/f.txt":       "f content",
+	"a/b/g.txt":     "g",
+	"a/b/empty.txt": "",
+}
+
+var seedDirs = []string{"a", "a/b", "c"}
+
+// seed creates the folders of seedDirs and the files of seedFiles.
+func seed(t *testing.T, vol volumes.FsVolume) {
+	t.Helper()
+	for _, dir := range seedDirs {
+		mustMkdir(t, vol, dir)
+	}
+	names := make([]string, 0, len(seedFiles))
+	for name := range seedFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mustCreate(t, vol, name, seedFiles[name])
+	}
+}
+
+func testFS(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	expected := append([]string(nil), seedDirs...)
+	for name := range seedFiles {
+		expected = append(expected, name)
+	}
+	sort.Strings(expected)
+	if err := fstest.TestFS(vol, expected...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCreate(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	mustCreate(t, vol, "new.txt", "new content")
+	checkContent(t, vol, "new.txt", "new content")
+	info, err := fs.Stat(vol, "new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.IsDir() || info.Size() != int64(len("new content")) {
+		t.Errorf("Stat(new.txt) = dir %v size %d, want file of size %d", info.IsDir(), info.Size(), len("new content"))
+	}
+
+	// an existing file is replaced, not appended to or partly overwritten
+	mustCreate(t, vol, "a/f.txt", "short")
+	checkContent(t, vol, "a/f.txt", "short")
+
+	if _, err := vol.Create("missing/x.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Create in missing folder: got %v, want fs.ErrNotExist", err)
+	}
+	if w, err := vol.Create("a/b"); err == nil {
+		if err = w.Close(); err == nil {
+			t.Errorf("Create over a folder succeeded")
+		}
+	}
+	checkDir(t, vol, "a/b")
+}
+
+func testMkdir(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	mustMkdir(t, vol, "a/new")
+	checkDir(t, vol, "a/new")
+	entries, err := vol.ReadDir("a/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ReadDir(a/new) = %d entries, want none", len(entries))
+	}
+	if err := vol.Mkdir("a"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir existing folder: got %v, want fs.ErrExist", err)
+	}
+	if err := vol.Mkdir("top.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir existing file: got %v, want fs.ErrExist", err)
+	}
+	if err := vol.Mkdir("missing/new"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Mkdir in missing folder: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func testRemove(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	if err := vol.Remove("top.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, vol, "top.txt")
+	if err := vol.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, vol, "c")
+
+	// Remove is not recursive, the connector removes children first
+	if err := vol.Remove("a/b"); err == nil {
+		t.Errorf("Remove of a non-empty folder succeeded")
+	}
+	checkDir(t, vol, "a/b")
+	checkContent(t, vol, "a/b/g.txt", "g")
+}
+
+func testRename(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	if err := vol.Rename("top.txt", "c/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, vol, "top.txt")
+	checkContent(t, vol, "c/moved.txt", "top")
+
+	// an existing file at new is replaced
+	if err := vol.Rename("c/moved.txt", "a/f.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, vol, "c/moved.txt")
+	checkContent(t, vol, "a/f.txt", "top")
+
+	// a folder moves with its content
+	if err := vol.Rename("a/b", "c/b"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotExist(t, vol, "a/b")
+	checkNotExist(t, vol, "a/b/g.txt")
+	checkDir(t, vol, "c/b")
+	checkContent(t, vol, "c/b/g.txt", "g")
+	checkContent(t, vol, "c/b/empty.txt", "")
+
+	if err := vol.Rename("c/b", "c/b/inside"); err == nil {
+		t.Errorf("Rename of a folder into itself succeeded")
+	}
+}
+
+func testReadDir(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	entries, err := vol.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name  string
+		isDir bool
+	}{{"a", true}, {"c", true}, {"top.txt", false}}
+	if len(entries) != len(want) {
+		t.Fatalf("ReadDir(.) = %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i].Name() != want[i].name || entries[i].IsDir() != want[i].isDir {
+			t.Errorf("ReadDir(.)[%d] = %s dir %v, want %s dir %v", i,
+				entries[i].Name(), entries[i].IsDir(), want[i].name, want[i].isDir)
+		}
+	}
+	if _, err := vol.ReadDir("top.txt"); err == nil {
+		t.Errorf("ReadDir of a file succeeded")
+	}
+}
+
+func testNotExist(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	const missing = "a/missing"
+	if _, err := vol.Open(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open: got %v, want fs.ErrNotExist", err)
+	}
+	if _, err := fs.Stat(vol, missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat: got %v, want fs.ErrNotExist", err)
+	}
+	if _, err := vol.ReadDir(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir: got %v, want fs.ErrNotExist", err)
+	}
+	if err := vol.Remove(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Remove: got %v, want fs.ErrNotExist", err)
+	}
+	if err := vol.Rename(missing, "a/other"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Rename: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func testInvalidPath(t *testing.T, vol volumes.FsVolume) {
+	seed(t, vol)
+	for _, name := range []string{"/top.txt", "../top.txt", "a/../top.txt", "a/", "", "./top.txt"} {
+		if _, err := vol.Open(name); err == nil {
+			t.Errorf("Open(%q) succeeded", name)
+		}
+		if w, err := vol.Create(name); err == nil {
+			_ = w.Close()
+			t.Errorf("Create(%q) succeeded", name)
+		}
+		if err := vol.Mkdir(name); err == nil {
+			t.Errorf("Mkdir(%q) succeeded", name)
+		}
+		if err := vol.Remove(name); err == nil {
+			t.Errorf("Remove(%q) succeeded", name)
+		}
+		if err := vol.Rename("top.txt", name); err == nil {
+			t.Errorf("Rename(top.txt, %q) succeeded", name)
+			return
+		}
+	}
+	checkContent(t, vol, "top.txt", "top")
+}
+
+func mustMkdir(t *testing.T, vol volumes.FsVolume, name string) {
+	t.Helper()
+	if err := vol.Mkdir(name); err != nil {
+		t.Fatalf("Mkdir(%s): %s", name, err)
+	}
+}
+
+func mustCreate(t *testing.T, vol volumes.FsVolume, name, content string) {
+	t.Helper()
+	w, err := vol.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%s): %s", name, err)
+	}
+	if _, err = io.Copy(w, bytes.NewBufferString(content)); err != nil {
+		_ = w.Close()
+		t.Fatalf("write %s: %s", name, err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("close %s: %s", name, err)
+	}
+}
+
+func checkContent(t *testing.T, vol volumes.FsVolume, name, content string) {
+	t.Helper()
+	data, err := fs.ReadFile(vol, name)
+	if err != nil {
+		t.Errorf("ReadFile(%s): %s", name, err)
+		return
+	}
+	if string(data) != content {
+		t.Errorf("ReadFile(%s) = %q, want %q", name, data, content)
+	}
+}
+
+func checkDir(t *testing.T, vol volumes.FsVolume, name string) {
+	t.Helper()
+	info, err := fs.Stat(vol, name)
+	if err != nil {
+		t.Errorf("Stat(%s): %s", name, err)
+		return
+	}
+	if !info.IsDir() {
+		t.Errorf("Stat(%s) is not a folder", name)
+	}
+}
+
+func checkNotExist(t *testing.T, vol volumes.FsVolume, name string) {
+	t.Helper()
+	if _, err := fs.Stat(vol, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(%s): got %v, want fs.ErrNotExist", name, err)
+	}
+}
@@ -0,0 +1,532 @@
+package volumes
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeEirc/elfinder/errs"
+)
+
+const (
+	webdavDirMode  = fs.ModeDir | 0755
+	webdavFileMode = 0644
+)
+
+var (
+	_ FsVolume  = (*WebDAVVolume)(nil)
+	_ fs.StatFS = (*WebDAVVolume)(nil)
+	_ ETagger   = (*webdavFileInfo)(nil)
+)
+
+// webdavPropfind asks for the properties mapped into fs.FileInfo.
+var webdavPropfind = []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/><D:getcontenttype/>
+</D:prop></D:propfind>`)
+
+// WebDAVConfig addresses a collection of a WebDAV server.
+type WebDAVConfig struct {
+	// URL is the collection of the volume root, e.g.
+	// "https://cloud.example.com/remote.php/dav/files/alice/".
+	URL      string
+	User     string
+	Password string
+	// Header is added to every request, e.g. a bearer token.
+	Header http.Header
+	// Name is the volume name, it defaults to the last element of the URL
+	// path.
+	Name       string
+	HTTPClient *http.Client
+}
+
+// WebDAVProps is what fs.FileInfo.Sys returns for entries of a WebDAVVolume.
+type WebDAVProps struct {
+	ETag        string
+	ContentType string
+}
+
+// WebDAVVolume is an FsVolume on a collection of a WebDAV server. Entries are
+// read with PROPFIND, files are written with a streamed PUT and folders
+// created with MKCOL. DELETE and MOVE of collections are recursive on the
+// server, the volume checks the FsVolume contract before sending them.
+type WebDAVVolume struct {
+	cfg    WebDAVConfig
+	base   *url.URL
+	client *http.Client
+	name   string
+}
+
+func NewWebDAV(cfg WebDAVConfig) (*WebDAVVolume, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("%w: webdav url %q", fs.ErrInvalid, cfg.URL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	base.RawPath = ""
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	name := cfg.Name
+	if name == "" {
+		name = path.Base(base.Path)
+		if name == "/" {
+			name = base.Hostname()
+		}
+	}
+	return &WebDAVVolume{cfg: cfg, base: base, client: client, name: name}, nil
+}
+
+func (w *WebDAVVolume) Name() string {
+	return w.name
+}
+
+// url returns the URL of name, folders end with a slash.
+func (w *WebDAVVolume) url(name string, isDir bool) string {
+	u := *w.base
+	if name != "." {
+		u.Path += name
+		if isDir {
+			u.Path += "/"
+		}
+	}
+	return u.String()
+}
+
+// do sends a request for name, a response with a status outside of 2xx is
+// returned as *WebDAVError. The caller closes the body.
+func (w *WebDAVVolume) do(method, name string, isDir bool, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, w.url(name, isDir), body)
+	if err != nil {
+		return nil, err
+	}
+	for k := range w.cfg.Header {
+		req.Header[k] = w.cfg.Header[k]
+	}
+	for k := range header {
+		req.Header[k] = header[k]
+	}
+	if w.cfg.User != "" || w.cfg.Password != "" {
+		req.SetBasicAuth(w.cfg.User, w.cfg.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, mapNetError(err)
+	}
+	if resp.StatusCode/100 != 2 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		_ = resp.Body.Close()
+		return nil, &WebDAVError{Method: method, StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// WebDAVError is an error response of the WebDAV server.
+type WebDAVError struct {
+	Method     string
+	StatusCode int
+}
+
+func (e *WebDAVError) Error() string {
+	return fmt.Sprintf("webdav: %s: %d %s", e.Method, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap maps the status to the fs errors the connector understands. A
+// conflict means a missing parent collection.
+func (e *WebDAVError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound, http.StatusConflict:
+		return fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+func (e *WebDAVError) ErrType() errs.ErrType {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return errs.ERRAccess
+	case http.StatusForbidden:
+		return errs.ERRPerm
+	case http.StatusInsufficientStorage:
+		return errs.ERRWrite
+	case http.StatusGatewayTimeout:
+		return errs.ERRTimeout
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return errs.ERRConnect
+	}
+	return errs.ERRResponse
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+}
+
+// propfind returns the decoded href paths and file infos of name and, with
+// depth "1", its children.
+func (w *WebDAVVolume) propfind(name, depth string) ([]string, []*webdavFileInfo, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := w.do("PROPFIND", name, false, header, bytes.NewReader(webdavPropfind))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var ms davMultistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, nil, err
+	}
+	hrefs := make([]string, 0, len(ms.Responses))
+	infos := make([]*webdavFileInfo, 0, len(ms.Responses))
+	for i := range ms.Responses {
+		href, err := url.Parse(ms.Responses[i].Href)
+		if err != nil {
+			return nil, nil, err
+		}
+		hrefPath := strings.TrimSuffix(href.Path, "/")
+		info := &webdavFileInfo{name: path.Base(hrefPath), mode: webdavFileMode}
+		for _, propstat := range ms.Responses[i].Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			info.setProps(propstat.Prop)
+		}
+		hrefs = append(hrefs, hrefPath)
+		infos = append(infos, info)
+	}
+	return hrefs, infos, nil
+}
+
+func (w *WebDAVVolume) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := w.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (w *WebDAVVolume) stat(name string) (*webdavFileInfo, error) {
+	_, infos, err := w.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	info := infos[0]
+	info.name = path.Base(name)
+	return info, nil
+}
+
+func (w *WebDAVVolume) Open(name string) (fs.File, error) {
+	info, err := w.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := w.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavDir{info: info, entries: entries}, nil
+	}
+	f, err := newRangeFile(info, func(offset int64) (io.ReadCloser, error) {
+		return w.get(name, offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// get streams name from offset. Servers without Range support answer 200
+// with the whole file, the bytes before offset are skipped then.
+func (w *WebDAVVolume) get(name string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := w.do(http.MethodGet, name, false, header, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if _, err = io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			_ = resp.Body.Close()
+			return nil, &fs.PathError{Op: "read", Path: name, Err: mapNetError(err)}
+		}
+	}
+	return resp.Body, nil
+}
+
+// ReadDir lists name with a single PROPFIND of depth 1, sorted by name.
+func (w *WebDAVVolume) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	hrefs, infos, err := w.propfind(name, "1")
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	dirPath := strings.TrimSuffix(w.base.Path, "/")
+	if name != "." {
+		dirPath += "/" + name
+	}
+	var entries []fs.DirEntry
+	for i := range infos {
+		if hrefs[i] == dirPath {
+			if !infos[i].IsDir() {
+				return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+			}
+			continue
+		}
+		if path.Dir(hrefs[i]) != dirPath {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(infos[i]))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// checkParent fails unless the parent of name is an existing collection.
+func (w *WebDAVVolume) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, err := w.stat(path.Dir(name))
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !parent.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// Create streams the written content as the body of a PUT, Close returns the
+// result of the request.
+func (w *WebDAVVolume) Create(name string) (io.ReadWriteCloser, error) {
+	if err := w.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	// some servers replace a collection by the file
+	if info, err := w.stat(name); err == nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	pr, pw := io.Pipe()
+	writer := &webdavWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := w.do(http.MethodPut, name, false, nil, pr)
+		if err == nil {
+			err = resp.Body.Close()
+		} else {
+			err = &fs.PathError{Op: "create", Path: name, Err: err}
+		}
+		_ = pr.CloseWithError(err)
+		writer.done <- err
+	}()
+	return writer, nil
+}
+
+func (w *WebDAVVolume) Mkdir(name string) error {
+	if err := w.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	resp, err := w.do("MKCOL", name, true, nil, nil)
+	var davErr *WebDAVError
+	if errors.As(err, &davErr) && davErr.StatusCode == http.StatusMethodNotAllowed {
+		err = fs.ErrExist
+	}
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+func (w *WebDAVVolume) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	_, infos, err := w.propfind(name, "1")
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if len(infos) > 1 {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrDirNotEmpty}
+	}
+	isDir := len(infos) == 1 && infos[0].IsDir()
+	resp, err := w.do(http.MethodDelete, name, isDir, nil, nil)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+// Rename sends a MOVE after checking that new is not a file replaced by a
+// folder or the reverse, or a non-empty folder.
+func (w *WebDAVVolume) Rename(old, new string) error {
+	if !fs.ValidPath(old) || !fs.ValidPath(new) {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if old == "." || new == "." {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrPermission}
+	}
+	info, err := w.stat(old)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: old, Err: err}
+	}
+	if err = w.checkParent("rename", new); err != nil {
+		return err
+	}
+	if old == new {
+		return nil
+	}
+	if info.IsDir() && strings.HasPrefix(new, old+"/") {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrInvalid}
+	}
+	if _, existing, err := w.propfind(new, "1"); err == nil {
+		switch {
+		case existing[0].IsDir() && !info.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrExist}
+		case !existing[0].IsDir() && info.IsDir():
+			return &fs.PathError{Op: "rename", Path: new, Err: fs.ErrInvalid}
+		case len(existing) > 1:
+			return &fs.PathError{Op: "rename", Path: new, Err: ErrDirNotEmpty}
+		}
+	}
+	header := http.Header{"Destination": {w.url(new, info.IsDir())}, "Overwrite": {"T"}}
+	resp, err := w.do("MOVE", old, info.IsDir(), header, nil)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: old, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	props   WebDAVProps
+}
+
+func (i *webdavFileInfo) setProps(prop davProp) {
+	if prop.ResourceType.Collection != nil {
+		i.mode = webdavDirMode
+	}
+	if prop.ContentLength != "" {
+		i.size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+	}
+	if prop.LastModified != "" {
+		i.modTime, _ = http.ParseTime(prop.LastModified)
+	}
+	if prop.ETag != "" {
+		i.props.ETag = prop.ETag
+	}
+	if prop.ContentType != "" {
+		i.props.ContentType = prop.ContentType
+	}
+}
+
+func (i *webdavFileInfo) Name() string       { return i.name }
+func (i *webdavFileInfo) Size() int64        { return i.size }
+func (i *webdavFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *webdavFileInfo) ModTime() time.Time { return i.modTime }
+func (i *webdavFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *webdavFileInfo) Sys() interface{}   { return i.props }
+func (i *webdavFileInfo) ETag() string       { return i.props.ETag }
+
+type webdavDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *webdavDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *webdavDir) Close() error               { return nil }
+
+func (d *webdavDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *webdavDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+type webdavWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+}
+
+func (w *webdavWriter) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *webdavWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.pw.Write(p)
+}
+
+func (w *webdavWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.Close()
+	return <-w.done
+}
@@ -0,0 +1,184 @@
+package volumes_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/LeeEirc/elfinder/errs"
+	"github.com/LeeEirc/elfinder/volumes"
+	"github.com/LeeEirc/elfinder/volumes/volumestest"
+)
+
+// webdavServer serves a webdav.FileSystem below "/dav/files alice/" to
+// alice with the password "secret" and records the requests.
+type webdavServer struct {
+	URL     string
+	handler *webdav.Handler
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func startWebDAV(t *testing.T, fsys webdav.FileSystem) *webdavServer {
+	t.Helper()
+	ctx := context.Background()
+	for _, name := range []string{"/dav", "/dav/files alice"} {
+		if err := fsys.Mkdir(ctx, name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &webdavServer{handler: &webdav.Handler{FileSystem: fsys, LockSystem: webdav.NewMemLS()}}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if user, password, ok := req.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, strings.TrimSpace(req.Method+" "+req.Header.Get("Depth")))
+		s.mu.Unlock()
+		s.handler.ServeHTTP(rw, req)
+	}))
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL + "/dav/files%20alice/"
+	return s
+}
+
+// since returns the requests after the first n.
+func (s *webdavServer) since(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests[n:]...)
+}
+
+func (s *webdavServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func newWebDAVVolume(t *testing.T, s *webdavServer) *volumes.WebDAVVolume {
+	t.Helper()
+	vol, err := volumes.NewWebDAV(volumes.WebDAVConfig{URL: s.URL, User: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vol
+}
+
+func TestWebDAV(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		return newWebDAVVolume(t, startWebDAV(t, webdav.Dir(t.TempDir())))
+	})
+}
+
+func TestWebDAVMemFS(t *testing.T) {
+	volumestest.Run(t, func(t *testing.T) volumes.FsVolume {
+		return newWebDAVVolume(t, startWebDAV(t, webdav.NewMemFS()))
+	})
+}
+
+func TestWebDAVMethods(t *testing.T) {
+	s := startWebDAV(t, webdav.NewMemFS())
+	vol := newWebDAVVolume(t, s)
+	if vol.Name() != "files alice" {
+		t.Errorf("volume name %q", vol.Name())
+	}
+	for _, step := range []struct {
+		name string
+		run  func() error
+		want string
+	}{
+		{"mkdir", func() error { return vol.Mkdir("dir") }, "MKCOL"},
+		{"create", func() error { writeFile(t, vol, "dir/a.txt", []byte("a")); return nil }, "PUT"},
+		{"readdir", func() error { _, err := vol.ReadDir("dir"); return err }, "PROPFIND 1"},
+		{"rename", func() error { return vol.Rename("dir/a.txt", "b.txt") }, "MOVE"},
+		{"remove", func() error { return vol.Remove("b.txt") }, "DELETE"},
+	} {
+		n := s.count()
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		requests := s.since(n)
+		if len(requests) == 0 || requests[len(requests)-1] != step.want {
+			t.Errorf("%s sent %v, want %s last", step.name, requests, step.want)
+		}
+	}
+	if _, err := vol.Stat("dir/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("moved file still at its old name: %v", err)
+	}
+}
+
+func TestWebDAVFileInfo(t *testing.T) {
+	root := t.TempDir()
+	s := startWebDAV(t, webdav.Dir(root))
+	vol := newWebDAVVolume(t, s)
+	writeFile(t, vol, "a.txt", []byte("hello"))
+	if err := vol.Mkdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "dav", "files alice", "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := vol.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.IsDir() || !info.ModTime().Equal(modTime) {
+		t.Errorf("file info %s %d %v %v", info.Name(), info.Size(), info.IsDir(), info.ModTime())
+	}
+	props, ok := info.Sys().(volumes.WebDAVProps)
+	if !ok {
+		t.Fatalf("Sys returned %T", info.Sys())
+	}
+	req, _ := http.NewRequest(http.MethodHead, s.URL+"a.txt", nil)
+	req.SetBasicAuth("alice", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if props.ETag == "" || props.ETag != resp.Header.Get("ETag") {
+		t.Errorf("etag %q, server sent %q", props.ETag, resp.Header.Get("ETag"))
+	}
+	if !strings.HasPrefix(props.ContentType, "text/plain") {
+		t.Errorf("content type %q", props.ContentType)
+	}
+
+	entries, err := vol.ReadDir(".")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "a.txt" || entries[1].Name() != "dir" {
+		t.Fatalf("entries %v %v", entries, err)
+	}
+	if !entries[1].IsDir() || entries[1].Type() != fs.ModeDir {
+		t.Errorf("folder entry of type %v", entries[1].Type())
+	}
+}
+
+func TestWebDAVErrTypes(t *testing.T) {
+	s := startWebDAV(t, webdav.NewMemFS())
+	vol, err := volumes.NewWebDAV(volumes.WebDAVConfig{URL: s.URL, User: "alice", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vol.Stat(".")
+	checkErrType(t, err, errs.ERRAccess)
+
+	vol, err = volumes.NewWebDAV(volumes.WebDAVConfig{URL: "http://127.0.0.1:1/dav/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vol.Stat(".")
+	checkErrType(t, err, errs.ERRConnect)
+}